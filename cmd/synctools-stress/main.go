@@ -0,0 +1,301 @@
+// Copyright © 2024 Timothy E. Peoples
+
+// Command synctools-stress exercises this module's Waypoints, Pipelines and
+// Groups under heavy concurrency -- with random resizes, cancelations and
+// panics -- while asserting their invariants and printing their metrics.
+// It is best run using a binary built with the race detector enabled:
+//
+//	go run -race ./cmd/synctools-stress -duration 30s
+//
+// The command exits with a non-zero status if any invariant is violated.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+	"github.com/go-sage/synctools/pkg/pipeline"
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+type config struct {
+	duration   time.Duration
+	workers    int
+	capacity   int
+	resize     time.Duration
+	cancelRate float64
+	panicRate  float64
+	items      int
+}
+
+func main() {
+	var cfg config
+
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to run the waypoint stress test")
+	flag.IntVar(&cfg.workers, "workers", 200, "number of concurrent goroutines")
+	flag.IntVar(&cfg.capacity, "capacity", 16, "maximum waypoint/stage capacity")
+	flag.DurationVar(&cfg.resize, "resize", 10*time.Millisecond, "interval between random resizes")
+	flag.Float64Var(&cfg.cancelRate, "cancel-rate", 0.05, "fraction of waits using a quickly canceled context")
+	flag.Float64Var(&cfg.panicRate, "panic-rate", 0.01, "fraction of workers that panic (and recover)")
+	flag.IntVar(&cfg.items, "items", 10000, "number of items pushed through the pipeline")
+	flag.Parse()
+
+	failed := false
+	for _, t := range []struct {
+		name string
+		run  func(config) error
+	}{
+		{"waypoint", stressWaypoint},
+		{"pipeline", stressPipeline},
+		{"errgroupx", stressGroup},
+	} {
+		start := time.Now()
+		if err := t.run(cfg); err != nil {
+			fmt.Printf("FAIL %-10s %v\n", t.name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("ok   %-10s %v\n", t.name, time.Since(start).Round(time.Millisecond))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// stressWaypoint hammers a single Waypoint with Wait/Done calls while
+// randomly resizing it and canceling waits; it fails if concurrency ever
+// exceeds the largest capacity set or if CheckInvariants reports an error.
+func stressWaypoint(cfg config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+
+	wp := waypoint.New(cfg.capacity)
+
+	var (
+		active  atomic.Int64
+		highest atomic.Int64
+		panics  atomic.Int64
+		errs    = make(chan error, 1)
+		wg      sync.WaitGroup
+	)
+
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+		cancel()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cfg.resize)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			wp.Resize(1 + rand.Intn(cfg.capacity))
+
+			if err := wp.CheckInvariants(); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < cfg.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				wctx, wcancel := ctx, context.CancelFunc(func() {})
+				if rand.Float64() < cfg.cancelRate {
+					wctx, wcancel = context.WithTimeout(ctx, time.Duration(rand.Intn(100))*time.Microsecond)
+				}
+
+				a, err := wp.Wait(wctx)
+				wcancel()
+				if err != nil {
+					continue
+				}
+
+				func() {
+					defer func() {
+						if recover() != nil {
+							panics.Add(1)
+						}
+					}()
+					defer a.Done()
+					defer active.Add(-1)
+
+					if n := active.Add(1); n > highest.Load() {
+						highest.Store(n)
+					}
+
+					if n := active.Load(); n > int64(cfg.capacity) {
+						fail(fmt.Errorf("%d active workers exceeds maximum capacity %d", n, cfg.capacity))
+					}
+
+					if rand.Float64() < cfg.panicRate {
+						panic("stress")
+					}
+
+					time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+				}()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+
+	if err := wp.CheckInvariants(); err != nil {
+		return err
+	}
+
+	m := wp.Metrics()
+	if m.Active != 0 {
+		return fmt.Errorf("%d workers still active after completion", m.Active)
+	}
+
+	fmt.Printf("     waypoint   finished=%d highest=%d panics=%d wait=%v active=%v\n",
+		m.Finished, highest.Load(), panics.Load(), m.WaitTime.Round(time.Millisecond), m.ActiveTime.Round(time.Millisecond))
+
+	return nil
+}
+
+// stressPipeline pushes cfg.items through a three stage Pipeline while
+// randomly resizing its stages; it fails unless every item arrives intact.
+func stressPipeline(cfg config) error {
+	src := &source{n: cfg.items}
+	p := pipeline.New(src)
+
+	for _, name := range []string{"one", "two", "three"} {
+		p.Add(name, cfg.capacity, func(ctx context.Context, in any) (any, error) {
+			time.Sleep(time.Duration(rand.Intn(50)) * time.Microsecond)
+			return in.(int) + 1, nil
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(cfg.resize)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.Resize([]string{"one", "two", "three"}[rand.Intn(3)], 1+rand.Intn(cfg.capacity))
+			}
+		}
+	}()
+
+	if err := p.Run(ctx); err != nil {
+		return err
+	}
+
+	if src.count != cfg.items {
+		return fmt.Errorf("collected %d items; wanted %d", src.count, cfg.items)
+	}
+
+	// Each of 0..n-1 is incremented once by each of 3 stages
+	if want := cfg.items*(cfg.items-1)/2 + 3*cfg.items; src.sum != want {
+		return fmt.Errorf("sum of collected items is %d; wanted %d", src.sum, want)
+	}
+
+	for _, name := range []string{"one", "two", "three"} {
+		sm, _ := p.StageMetrics(name)
+		fmt.Printf("     pipeline   stage=%-5s finished=%d wait=%v active=%v\n",
+			name, sm.Waypoint.Finished, sm.Waypoint.WaitTime.Round(time.Millisecond), sm.Waypoint.ActiveTime.Round(time.Millisecond))
+	}
+
+	return nil
+}
+
+type source struct {
+	n, count, sum int
+}
+
+func (s *source) Feed(ctx context.Context, ch chan<- any) error {
+	for i := 0; i < s.n; i++ {
+		if err := pipeline.Send(ctx, i, ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *source) Collect(ctx context.Context, ch <-chan any) error {
+	for {
+		v, ok, err := pipeline.Recv[int](ctx, ch)
+		if err != nil || !ok {
+			return err
+		}
+		s.count++
+		s.sum += v
+	}
+}
+
+// stressGroup repeatedly starts Groups of workers -- some of which fail and
+// some of which are shut down -- verifying that Wait classifies each
+// outcome correctly.
+func stressGroup(cfg config) error {
+	errBoom := errors.New("boom")
+	deadline := time.Now().Add(cfg.duration / 4)
+
+	for rounds := 0; time.Now().Before(deadline); rounds++ {
+		eg, ctx, cancel := errgroupx.WithCancel(context.Background())
+		fail := rand.Intn(2) == 0
+
+		for i := 0; i < cfg.workers; i++ {
+			eg.GoContext(ctx, func(ctx context.Context) error {
+				if fail && i == cfg.workers/2 {
+					return errBoom
+				}
+				<-ctx.Done()
+				return ctx.Err()
+			})
+		}
+
+		if !fail {
+			cancel()
+		}
+
+		err := eg.Wait()
+		cancel()
+
+		switch {
+		case fail && err != errBoom:
+			return fmt.Errorf("round %d: got %v; wanted %v", rounds, err, errBoom)
+		case !fail && !errgroupx.IsShutdown(err):
+			return fmt.Errorf("round %d: got %v; wanted shutdown error", rounds, err)
+		}
+	}
+
+	return nil
+}
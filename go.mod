@@ -1,5 +1,5 @@
 module github.com/go-sage/synctools
 
-go 1.21.6
+go 1.23
 
 require golang.org/x/sync v0.6.0
@@ -0,0 +1,25 @@
+// Copyright © 2024 Timothy E. Peoples
+
+// Package options provides the functional options infrastructure shared by
+// all of this module's packages. Each package declares its own Option type
+// as an alias of Option[T] (for its own configurable type T) and applies
+// them with Apply. This keeps the shape of every constructor consistent
+// so that cross-cutting features (names, clocks, loggers, metrics backends
+// and the like) may be added uniformly without breaking any package's API.
+package options
+
+// An Option alters the default behavior of a value of type T. Options are
+// normally applied at construction time.
+type Option[T any] func(*T)
+
+// Apply applies each of the non-nil opts to target, in order, and returns
+// target.
+func Apply[T any](target *T, opts []Option[T]) *T {
+	for _, opt := range opts {
+		if opt != nil {
+			opt(target)
+		}
+	}
+
+	return target
+}
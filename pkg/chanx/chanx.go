@@ -0,0 +1,48 @@
+// Copyright © 2024 Timothy E. Peoples
+
+// Package chanx provides converters between channels and the iterator types
+// from the standard library's iter package.
+package chanx
+
+import (
+	"context"
+	"iter"
+)
+
+// Seq returns an iter.Seq that yields each value received from ch until ch
+// is closed, ctx is canceled, or the consumer stops iterating.
+func Seq[T any](ctx context.Context, ch <-chan T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-ch:
+				if !ok || !yield(v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// FromSeq returns a channel to which each value from seq is sent by a new
+// goroutine. The channel is closed once seq is exhausted or ctx is canceled
+// (in which case any remaining values are abandoned).
+func FromSeq[T any](ctx context.Context, seq iter.Seq[T]) <-chan T {
+	ch := make(chan T)
+
+	go func() {
+		defer close(ch)
+
+		for v := range seq {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- v:
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,20 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package chanx
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	want := []int{1, 2, 3, 4}
+
+	got := slices.Collect(Seq(ctx, FromSeq(ctx, slices.Values(want))))
+
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package errgroupx
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// NoDeadline is the value returned by Remaining for a Group whose Context
+// has no deadline.
+const NoDeadline = time.Duration(math.MaxInt64)
+
+// Remaining returns the amount of time left before the receiver's Context
+// reaches its deadline (as set by WithDeadline, WithTimeout or any parent
+// Context). Zero is returned once the deadline has passed and NoDeadline is
+// returned if there is no deadline at all.
+func (g *Group) Remaining() time.Duration {
+	if g.ctx == nil {
+		return NoDeadline
+	}
+
+	d, ok := g.ctx.Deadline()
+	if !ok {
+		return NoDeadline
+	}
+
+	if r := time.Until(d); r > 0 {
+		return r
+	}
+
+	return 0
+}
+
+// Share returns an even share of the receiver's remaining time budget when
+// split across n tasks; i.e. Remaining divided by n. If n is less than 1 or
+// the receiver has no deadline, Remaining is returned unchanged.
+func (g *Group) Share(n int) time.Duration {
+	r := g.Remaining()
+	if n < 1 || r == NoDeadline {
+		return r
+	}
+
+	return r / time.Duration(n)
+}
+
+// WithShare returns a copy of ctx (and its CancelFunc) whose timeout is the
+// value returned by Share(n). This allows a task that is one of n queued
+// tasks to adapt its own sub-timeout to the budget remaining for the Group
+// instead of blindly using the Group's original timeout. If the receiver
+// has no deadline, the returned Context has none either.
+func (g *Group) WithShare(ctx context.Context, n int) (context.Context, context.CancelFunc) {
+	share := g.Share(n)
+	if share == NoDeadline {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, share)
+}
@@ -0,0 +1,153 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package errgroupx
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// ErrDependencyFailed is returned (wrapped) by a task started with
+	// GoAfter when one of its prerequisites failed.
+	ErrDependencyFailed = errstr("prerequisite task failed")
+
+	// ErrDependencyCycle is returned (wrapped) by a task started with
+	// GoAfter if its prerequisites would form a cycle.
+	ErrDependencyCycle = errstr("task dependency cycle")
+
+	// ErrUnknownTask is returned (wrapped) by a task started with GoAfter
+	// if one of its prerequisites was never started.
+	ErrUnknownTask = errstr("unknown prerequisite task")
+
+	// ErrDuplicateTask is returned (wrapped) by a task started with GoAfter
+	// using a name that has already been registered.
+	ErrDuplicateTask = errstr("duplicate task name")
+)
+
+// task tracks the completion of a named function started with GoAfter.
+type task struct {
+	deps     []string
+	declared bool
+	done     chan struct{}
+	err      error
+}
+
+// GoNamed is equivalent to GoAfter with no prerequisites; it executes cfunc
+// immediately while registering it under the given name so that it may be
+// referenced as a prerequisite by subsequent calls to GoAfter.
+func (g *Group) GoNamed(ctx context.Context, name string, cfunc ContextFunc) {
+	g.GoAfter(ctx, name, nil, cfunc)
+}
+
+// GoAfter is similar to GoContext except cfunc is registered as a task with
+// the given name and is not executed until all of the tasks named by after
+// have completed successfully. Tasks may be registered in any order, which
+// allows for a micro-DAG of startup sequences; the Group computes the order
+// in which they start. Task names must be unique within the Group.
+//
+// If any prerequisite fails, cfunc is never executed and its task fails with
+// an error wrapping ErrDependencyFailed. Similarly, ErrDependencyCycle is
+// used if the new task would create a dependency cycle and ErrUnknownTask
+// if a prerequisite has still not been registered when Wait is called. All
+// tasks must be registered before Wait is called.
+func (g *Group) GoAfter(ctx context.Context, name string, after []string, cfunc ContextFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	t := g._task(name)
+	if t.declared {
+		g.group.Go(func() error {
+			return fmt.Errorf("%w: %q", ErrDuplicateTask, name)
+		})
+		return
+	}
+
+	t.declared = true
+	t.deps = after
+
+	deps := make([]*task, len(after))
+	for i, dep := range after {
+		deps[i] = g._task(dep)
+	}
+
+	cyclic := g._reaches(after, name, map[string]bool{})
+
+	g.group.Go(func() error {
+		t.err = func() error {
+			if cyclic {
+				return fmt.Errorf("%w: %q", ErrDependencyCycle, name)
+			}
+
+			for i, dep := range deps {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-dep.done:
+				}
+
+				if dep.err != nil {
+					return fmt.Errorf("%w: %q (needed by %q): %w", ErrDependencyFailed, after[i], name, dep.err)
+				}
+			}
+
+			return cfunc(ctx)
+		}()
+
+		close(t.done)
+
+		return t.err
+	})
+}
+
+// _task returns the task registered with the given name, creating an
+// undeclared placeholder if none yet exists.
+func (g *Group) _task(name string) *task {
+	if g.tasks == nil {
+		g.tasks = make(map[string]*task)
+	}
+
+	t, ok := g.tasks[name]
+	if !ok {
+		t = &task{done: make(chan struct{})}
+		g.tasks[name] = t
+	}
+
+	return t
+}
+
+// _reaches returns true if target is reachable by following dependencies
+// from any of the named tasks.
+func (g *Group) _reaches(names []string, target string, seen map[string]bool) bool {
+	for _, n := range names {
+		if n == target {
+			return true
+		}
+
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+
+		if t, ok := g.tasks[n]; ok && g._reaches(t.deps, target, seen) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// failUnknown causes all prerequisites that were referenced but never
+// registered to fail with ErrUnknownTask.
+func (g *Group) failUnknown() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for name, t := range g.tasks {
+		if !t.declared {
+			t.declared = true
+			t.err = fmt.Errorf("%w: %q", ErrUnknownTask, name)
+			close(t.done)
+		}
+	}
+}
@@ -34,9 +34,12 @@ package errgroupx
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
+
+	"github.com/go-sage/synctools/internal/options"
 )
 
 type (
@@ -46,16 +49,25 @@ type (
 	// available here as well.
 	Group struct {
 		*group
+		ctx    context.Context
+		ignore bool
+		tasks  map[string]*task
+		mu     sync.Mutex
 	}
 
 	group = errgroup.Group
 )
 
+// An Option alters the default behavior of a Group. Options are passed to
+// the constructor functions (WithCancel, WithDeadline or WithTimeout) and
+// are applied in the order provided.
+type Option = options.Option[Group]
+
 // New is equivalent to WithCancel.
 //
 // Deprecated: use WithCancel instead.
-func New(ctx context.Context) (*Group, context.Context, context.CancelFunc) {
-	return newGroup(context.WithCancel(ctx))
+func New(ctx context.Context, opts ...Option) (*Group, context.Context, context.CancelFunc) {
+	return WithCancel(ctx, opts...)
 }
 
 // WithCancel is a wrapper around errgroup.WithContext and context.WithCancel
@@ -64,28 +76,47 @@ func New(ctx context.Context) (*Group, context.Context, context.CancelFunc) {
 // similar) returns a non-nil error, or the first time Wait returns, whichever
 // occurs first.
 //
-// See package 'context' about what to do with the CancelFunc.
-func WithCancel(ctx context.Context) (*Group, context.Context, context.CancelFunc) {
-	return newGroup(context.WithCancel(ctx))
+// See package 'context' about what to do with the CancelFunc. Note however
+// that calling the returned CancelFunc is considered an intentional shutdown
+// of the Group; see IsShutdown for details.
+func WithCancel(ctx context.Context, opts ...Option) (*Group, context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return newGroup(ctx, shutdown(cancel, nil), opts)
 }
 
 // WithDeadline is a similar to WithCancel but wraps context.WithDeadline
 // instead of context.WithCancel.
-func WithDeadline(ctx context.Context, d time.Time) (*Group, context.Context, context.CancelFunc) {
-	return newGroup(context.WithDeadline(ctx, d))
+func WithDeadline(ctx context.Context, d time.Time, opts ...Option) (*Group, context.Context, context.CancelFunc) {
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	ctx, cancel := context.WithDeadline(ctx, d)
+	return newGroup(ctx, shutdown(cancelCause, cancel), opts)
 }
 
 // WithTimeout is a similar to WithCancel but wraps context.WithTimeout
 // instead of context.WithCancel.
-func WithTimeout(ctx context.Context, timeout time.Duration) (*Group, context.Context, context.CancelFunc) {
-	return newGroup(context.WithTimeout(ctx, timeout))
+func WithTimeout(ctx context.Context, timeout time.Duration, opts ...Option) (*Group, context.Context, context.CancelFunc) {
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return newGroup(ctx, shutdown(cancelCause, cancel), opts)
 }
 
 // newGroup provides common logic for the constructor functions WithCancel,
 // WithDeadline, and WithTimeout.
-func newGroup(ctx context.Context, cancel context.CancelFunc) (*Group, context.Context, context.CancelFunc) {
+func newGroup(ctx context.Context, cancel context.CancelFunc, opts []Option) (*Group, context.Context, context.CancelFunc) {
 	group, ctx := errgroup.WithContext(ctx)
-	return &Group{group}, ctx, cancel
+	g := options.Apply(&Group{group: group, ctx: ctx}, opts)
+	return g, ctx, cancel
+}
+
+// shutdown returns a CancelFunc that cancels its Context using ErrShutdown
+// as the cause followed by calling cancel (if it is not nil).
+func shutdown(cancelCause context.CancelCauseFunc, cancel context.CancelFunc) context.CancelFunc {
+	return func() {
+		cancelCause(ErrShutdown)
+		if cancel != nil {
+			cancel()
+		}
+	}
 }
 
 // ContextFunc is the function type passed to GoContext or TryGoContext.
@@ -0,0 +1,142 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package errgroupx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsShutdown(t *testing.T) {
+	eg, ctx, cancel := WithCancel(context.Background())
+
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	cancel()
+
+	if err := eg.Wait(); !IsShutdown(err) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait after shutdown: got %v; wanted shutdown error", err)
+	}
+
+	errBoom := errors.New("boom")
+	eg, ctx, cancel = WithCancel(context.Background())
+	defer cancel()
+
+	eg.GoContext(ctx, func(ctx context.Context) error { return errBoom })
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if err := eg.Wait(); err != errBoom || IsShutdown(err) {
+		t.Errorf("Wait after failure: got %v; wanted %v", err, errBoom)
+	}
+}
+
+func TestIgnoreShutdown(t *testing.T) {
+	eg, ctx, cancel := WithTimeout(context.Background(), time.Hour, IgnoreShutdown())
+
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	cancel()
+
+	if err := eg.Wait(); err != nil {
+		t.Errorf("Wait after shutdown: got %v; wanted nil", err)
+	}
+}
+
+func TestGoAfter(t *testing.T) {
+	eg, ctx, cancel := WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+
+	run := func(name string) ContextFunc {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	// Declared out of order: c after b after a.
+	eg.GoAfter(ctx, "c", []string{"b"}, run("c"))
+	eg.GoAfter(ctx, "b", []string{"a"}, run("b"))
+	eg.GoNamed(ctx, "a", run("a"))
+
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := strings.Join(order, ","), "a,b,c"; got != want {
+		t.Errorf("start order: got %q; wanted %q", got, want)
+	}
+}
+
+func TestGoAfterFailures(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	eg, ctx, cancel := WithCancel(context.Background())
+	defer cancel()
+
+	ran := false
+	eg.GoNamed(ctx, "a", func(context.Context) error { return errBoom })
+	eg.GoAfter(ctx, "b", []string{"a"}, func(context.Context) error { ran = true; return nil })
+
+	if err := eg.Wait(); err != errBoom || ran {
+		t.Errorf("Wait: got (%v, ran=%v); wanted (%v, ran=false)", err, ran, errBoom)
+	}
+
+	eg, ctx, cancel = WithCancel(context.Background())
+	defer cancel()
+
+	eg.GoAfter(ctx, "x", []string{"missing"}, func(context.Context) error { return nil })
+
+	if err := eg.Wait(); !errors.Is(err, ErrUnknownTask) && !errors.Is(err, ErrDependencyFailed) {
+		t.Errorf("Wait: got %v; wanted %v", err, ErrUnknownTask)
+	}
+
+	eg, ctx, cancel = WithCancel(context.Background())
+	defer cancel()
+
+	eg.GoAfter(ctx, "p", []string{"q"}, func(context.Context) error { return nil })
+	eg.GoAfter(ctx, "q", []string{"p"}, func(context.Context) error { return nil })
+
+	if err := eg.Wait(); !errors.Is(err, ErrDependencyCycle) {
+		t.Errorf("Wait: got %v; wanted %v", err, ErrDependencyCycle)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	eg, _, cancel := WithCancel(context.Background())
+	defer cancel()
+
+	if got := eg.Remaining(); got != NoDeadline {
+		t.Errorf("Remaining without deadline: got %v; wanted NoDeadline", got)
+	}
+
+	eg, _, cancel = WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	if got := eg.Remaining(); got <= 59*time.Minute || got > time.Hour {
+		t.Errorf("Remaining: got %v; wanted ~1h", got)
+	}
+
+	if got := eg.Share(4); got <= 14*time.Minute || got > 15*time.Minute {
+		t.Errorf("Share(4): got %v; wanted ~15m", got)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package errgroupx
+
+import (
+	"context"
+	"errors"
+)
+
+type errstr string
+
+func (s errstr) Error() string {
+	return string(s)
+}
+
+// ErrShutdown is the cause attached to a Group's Context when it is canceled
+// by the CancelFunc returned from its constructor (as opposed to a failure
+// from one of the Group's functions or cancelation of a parent Context).
+const ErrShutdown = errstr("group shut down")
+
+// IsShutdown returns true if err was returned by a Group's Wait method and
+// resulted solely from an intentional shutdown; i.e. it was caused by calling
+// the CancelFunc returned from the Group's constructor.
+func IsShutdown(err error) bool {
+	return errors.Is(err, ErrShutdown)
+}
+
+// IgnoreShutdown returns an Option causing the Group's Wait method to return
+// nil instead of errors for which IsShutdown would return true.
+func IgnoreShutdown() Option {
+	return func(g *Group) {
+		g.ignore = true
+	}
+}
+
+// Wait is a wrapper around the (*Group).Wait method from package
+// golang.org/x/sync/errgroup. If the error returned is a context.Canceled
+// error resulting from an intentional shutdown, it is wrapped such that
+// IsShutdown will report true (or, if the Group was created with the
+// IgnoreShutdown option, nil is returned instead).
+//
+// Before waiting, any prerequisites named in calls to GoAfter that have
+// not been registered are failed with ErrUnknownTask.
+func (g *Group) Wait() error {
+	g.failUnknown()
+
+	err := g.group.Wait()
+	if err == nil || g.ctx == nil || !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	if !errors.Is(context.Cause(g.ctx), ErrShutdown) {
+		return err
+	}
+
+	if g.ignore {
+		return nil
+	}
+
+	return &shutdownError{err}
+}
+
+// shutdownError wraps a context.Canceled error caused by ErrShutdown.
+type shutdownError struct {
+	err error
+}
+
+func (e *shutdownError) Error() string {
+	return e.err.Error()
+}
+
+func (e *shutdownError) Unwrap() []error {
+	return []error{ErrShutdown, e.err}
+}
@@ -0,0 +1,97 @@
+// Copyright © 2024 Timothy E. Peoples
+
+// Package parallel provides helpers for concurrently processing the values
+// of an iter.Seq with concurrency coordinated by this module's [waypoint]
+// package.
+//
+// [waypoint]: https://pkg.go.dev/github.com/go-sage/synctools/pkg/waypoint
+package parallel
+
+import (
+	"context"
+	"iter"
+	"sync"
+
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// Map returns an iter.Seq2 yielding the result of calling fn for each value
+// from seq with at most capacity calls executing concurrently. Results are
+// yielded in the order they complete (not the order of seq). The first error
+// returned by fn is yielded (along with a zero Out value) and ends the
+// iteration; no further calls to fn are started and those in-flight have
+// their Context canceled. Iteration also ends if ctx is canceled, in which
+// case ctx.Err() is yielded last.
+//
+// If the consumer stops iterating early, all in-flight calls have their
+// Context canceled and are waited upon before the iteration returns.
+func Map[In, Out any](ctx context.Context, seq iter.Seq[In], capacity int, fn func(context.Context, In) (Out, error)) iter.Seq2[Out, error] {
+	type result struct {
+		out Out
+		err error
+	}
+
+	return func(yield func(Out, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var (
+			wp      = waypoint.New(capacity)
+			results = make(chan result)
+			wg      sync.WaitGroup
+		)
+
+		go func() {
+			defer close(results)
+			defer wg.Wait()
+
+			// n.b. Wait may still provide a Worker once ctx is canceled
+			//      (if capacity is available) so ctx is checked both
+			//      before and after; returning stops the range over seq.
+			for in := range seq {
+				if err := ctx.Err(); err != nil {
+					wg.Wait()
+					results <- result{err: err}
+					return
+				}
+
+				w, err := wp.Wait(ctx)
+				if err == nil && ctx.Err() != nil {
+					w.Done()
+					err = ctx.Err()
+				}
+
+				if err != nil {
+					wg.Wait()
+					results <- result{err: err}
+					return
+				}
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer w.Done()
+
+					out, err := fn(ctx, in)
+					results <- result{out, err}
+				}()
+			}
+		}()
+
+		// n.b. After the first error (or an early return by the consumer)
+		//      we cancel ctx but keep draining results so that all of the
+		//      above goroutines can exit (which is why they never need to
+		//      worry about ctx when sending).
+		done := false
+		for r := range results {
+			if done {
+				continue
+			}
+
+			if !yield(r.out, r.err) || r.err != nil {
+				done = true
+				cancel()
+			}
+		}
+	}
+}
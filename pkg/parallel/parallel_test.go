@@ -0,0 +1,91 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package parallel
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	ctx := context.Background()
+	double := func(_ context.Context, i int) (int, error) { return i * 2, nil }
+
+	var got []int
+	for v, err := range Map(ctx, slices.Values([]int{1, 2, 3, 4, 5}), 2, double) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+
+	slices.Sort(got)
+	if want := []int{2, 4, 6, 8, 10}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+}
+
+func TestMapError(t *testing.T) {
+	errBoom := errors.New("boom")
+	fail := func(_ context.Context, i int) (int, error) {
+		if i == 3 {
+			return 0, errBoom
+		}
+		return i, nil
+	}
+
+	var last error
+	for _, err := range Map(context.Background(), slices.Values([]int{1, 2, 3, 4, 5}), 1, fail) {
+		last = err
+	}
+
+	if last != errBoom {
+		t.Errorf("last error: got %v; wanted %v", last, errBoom)
+	}
+}
+
+func TestMapStopsAfterError(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	var failed atomic.Bool
+	var late int
+
+	naturals := func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			if failed.Load() {
+				late++
+			}
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	fail := func(ctx context.Context, i int) (int, error) {
+		if i == 10 {
+			failed.Store(true)
+			return 0, errBoom
+		}
+
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+
+	var last error
+	for _, err := range Map(context.Background(), naturals, 100, fail) {
+		last = err
+	}
+
+	if last != errBoom {
+		t.Errorf("last error: got %v; wanted %v", last, errBoom)
+	}
+
+	// n.b. The slot freed by the failing call may be reused (and one more
+	//      value taken from seq) before the error is seen.
+	if late > 2 {
+		t.Errorf("got %d values from seq after the first error; wanted at most 2", late)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// An AckItem is a single data element received from an AckSource along with
+// the callbacks used to acknowledge its outcome. Exactly one of Ack or Nack
+// is called for each item that reaches a terminal state (see NewAck); either
+// may be nil.
+//
+// If Context is not nil, its values (but not its deadline or cancelation) are
+// visible to each stage through the Context passed to its StageFunc. This
+// allows, for example, a span context extracted from a message's headers to
+// be propagated through the Pipeline (see WithTracer). Likewise, Metadata is
+// made available to each stage using ItemFrom. If Deadline is not zero, the
+// item is dropped by the first stage that has yet to process it once the
+// deadline has passed (see WithItemDeadline). Priority is used only by a
+// Pipeline created using WithPriority.
+type AckItem struct {
+	Value    any
+	Ack      func()
+	Nack     func(err error)
+	Context  context.Context
+	Metadata Metadata
+	Deadline time.Time
+	Priority int
+}
+
+// An AckSource is a data source whose items must be acknowledged once they
+// have been processed, such as a message queue consumer. Receive returns
+// io.EOF once no more items are available.
+type AckSource interface {
+	Receive(ctx context.Context) (AckItem, error)
+}
+
+// An AckSink is the data sink for a Pipeline created by NewAck. A non-nil
+// error from Write causes the item to be Nack'ed (and the Pipeline to fail).
+type AckSink interface {
+	Write(ctx context.Context, value any) error
+}
+
+// NewAck creates a Pipeline that is fed by src and collected by sink, such
+// that each item's Ack callback is called only after the item has passed
+// through every stage and has been successfully written to sink. If a stage
+// fails while processing an item (or the item is written to sink
+// unsuccessfully), that item's Nack callback is called with the error.
+//
+// Note that items that are still in-flight when a Pipeline fails (or is
+// canceled) may be neither Ack'ed nor Nack'ed; as with any at-least-once
+// consumer, such items are expected to be redelivered by the source.
+func NewAck(src AckSource, sink AckSink, opts ...Option) *Pipeline {
+	p := New(ackImpl{src: src, sink: sink}, opts...)
+	p.impl = ackImpl{src, sink, p.envelopes, p.clock}
+
+	return p
+}
+
+// An envelope carries a single item through a Pipeline along with its
+// callbacks, Context and itemState (see ItemFrom). Stages process (and
+// replace) its Value while leaving everything else intact.
+type envelope struct {
+	AckItem
+	state *itemState
+
+	pool   *envelopePool    // if taken from one; see WithPooling
+	sctx   stateCtx         // see context
+	worker *waypoint.Worker // see withWorker
+}
+
+func (m *envelope) ack() {
+	if m.Ack != nil {
+		m.Ack()
+	}
+}
+
+func (m *envelope) nack(err error) {
+	if m.Nack != nil {
+		m.Nack(err)
+	}
+}
+
+// split returns n copies of the receiver for use by parallel branches (see
+// AddBranch). The receiver is Ack'ed once every copy has been Ack'ed or is
+// Nack'ed as soon as any copy is Nack'ed.
+func (m *envelope) split(n int) []*envelope {
+	var (
+		remaining atomic.Int64
+		once      sync.Once
+	)
+
+	remaining.Store(int64(n))
+
+	item := AckItem{
+		Value:   m.Value,
+		Context: m.Context,
+		Ack: func() {
+			if remaining.Add(-1) == 0 {
+				m.ack()
+			}
+		},
+		Nack: func(err error) {
+			once.Do(func() { m.nack(err) })
+		},
+	}
+
+	msgs := make([]*envelope, n)
+	for i := range msgs {
+		msgs[i] = &envelope{AckItem: item, state: m.state}
+	}
+
+	return msgs
+}
+
+// onAck returns a copy of item whose Ack and Nack callbacks also call fn
+// (exactly once) with a nil error or the Nack error, respectively.
+func onAck(item AckItem, fn func(err error)) AckItem {
+	var once sync.Once
+
+	done := func(err error) {
+		once.Do(func() { fn(err) })
+	}
+
+	ack, nack := item.Ack, item.Nack
+
+	item.Ack = func() {
+		done(nil)
+		if ack != nil {
+			ack()
+		}
+	}
+
+	item.Nack = func(err error) {
+		done(err)
+		if nack != nil {
+			nack(err)
+		}
+	}
+
+	return item
+}
+
+// combineAcks returns an envelope carrying value whose callbacks Ack (or Nack)
+// every one of msgs; it is used by stages that aggregate multiple items. The
+// returned envelope carries forward the itemState (i.e. the Metadata,
+// deadline and so on; see ItemFrom) of the first of msgs, the others' being
+// discarded.
+func combineAcks(msgs []*envelope, value any) *envelope {
+	return &envelope{
+		AckItem: AckItem{
+			Value: value,
+			Ack: func() {
+				for _, m := range msgs {
+					m.ack()
+				}
+			},
+			Nack: func(err error) {
+				for _, m := range msgs {
+					m.nack(err)
+				}
+			},
+		},
+		state: msgs[0].state,
+	}
+}
+
+// nackOnError calls the Nack callback for in, if it is an envelope, when err is
+// not nil.
+func nackOnError(in any, err error) {
+	if m, ok := in.(*envelope); ok && err != nil {
+		m.nack(err)
+	}
+}
+
+// ackSkipped calls the Ack callback for in, if it is an envelope, since it was
+// dropped by a stage's ErrorPolicy.
+func ackSkipped(in any) {
+	if m, ok := in.(*envelope); ok {
+		m.ack()
+	}
+}
+
+// ackImpl is the Interface used by NewAck.
+type ackImpl struct {
+	src   AckSource
+	sink  AckSink
+	pool  *envelopePool // see WithPooling
+	clock waypoint.Clock
+}
+
+func (ai ackImpl) Feed(ctx context.Context, ch chan<- any) error {
+	for {
+		item, err := ai.src.Receive(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		m := ai.pool.get(item, ai.clock.Now())
+
+		if err := Send(ctx, m, ch); err != nil {
+			m.nack(err)
+			return err
+		}
+	}
+}
+
+func (ai ackImpl) Collect(ctx context.Context, ch <-chan any) error {
+	for {
+		m, ok, err := Recv[*envelope](ctx, ch)
+		if err != nil || !ok {
+			return err
+		}
+
+		if err := ai.sink.Write(ctx, m.Value); err != nil {
+			m.nack(err)
+			return err
+		}
+
+		m.ack()
+		ai.pool.put(m)
+	}
+}
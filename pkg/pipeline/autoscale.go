@@ -0,0 +1,47 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// WithAutoScale returns a StageOption that, while the Pipeline is running,
+// periodically resizes the stage's Waypoint according to policy (see
+// waypoint.AutoScale). Capacity is increased while items wait longer than
+// policy.TargetWait for the stage to accept them and decreased while the
+// stage has unused capacity, always within the bounds of policy.Min and
+// policy.Max. The stage's registered capacity is its initial capacity.
+//
+// A manual call to Resize is respected (see waypoint.AutoScale). Stages
+// added using AddBatch or AddReduce do not use their Waypoint and are
+// therefore unaffected.
+func WithAutoScale(policy waypoint.ScalePolicy) StageOption {
+	return func(s *stage) {
+		s.scale = &policy
+	}
+}
+
+// autoscale starts a controller for the receiver's Waypoint if it was
+// configured using WithAutoScale and returns a function that stops it (and
+// waits for it to return).
+func (s *stage) autoscale(ctx context.Context) func() {
+	if s.scale == nil {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		waypoint.AutoScale(ctx, s.waypt, *s.scale)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
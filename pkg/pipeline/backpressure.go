@@ -0,0 +1,85 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// A BackpressureEvent is passed to the function registered using
+// OnBackpressure.
+type BackpressureEvent struct {
+	Stage   string        // Name of the stage sending the item
+	Blocked time.Duration // How long the send was blocked
+}
+
+// OnBackpressure returns an Option that causes fn to be called each time a
+// stage is blocked for at least threshold while sending an item on to the
+// stage (or Collect) that follows it; i.e. whenever the downstream side of a
+// stage cannot keep up. If threshold is not positive, a value of one
+// millisecond is used.
+//
+// Note that fn is called by the stage's worker goroutine and should therefore
+// return quickly. The total time each stage has spent blocked is also
+// reported by Metrics (see StageMetrics.SendBlocked).
+func OnBackpressure(fn func(BackpressureEvent), threshold time.Duration) Option {
+	if threshold <= 0 {
+		threshold = time.Millisecond
+	}
+
+	return func(p *Pipeline) {
+		p.blocking = &backpressure{fn, threshold}
+	}
+}
+
+type backpressure struct {
+	fn        func(BackpressureEvent)
+	threshold time.Duration
+}
+
+// send sends out to outch (see sendOut), recording the time spent doing so
+// as time the receiver was blocked by backpressure.
+func (s *stage) send(ctx context.Context, out any, outch chan<- any) error {
+	start := s.clock.Now()
+	err := sendOut(ctx, out, outch)
+	d := s.clock.Since(start)
+
+	s.stats.blocked.Add(int64(d))
+
+	if bp := s.blocking; bp != nil && err == nil && d >= bp.threshold {
+		bp.fn(BackpressureEvent{s.name, d})
+	}
+
+	return err
+}
+
+// queued records n, the current length of the stage's input channel, if it
+// is the greatest seen so far.
+func (ss *stageStats) queued(n int) {
+	for {
+		peak := ss.peak.Load()
+		if int64(n) <= peak || ss.peak.CompareAndSwap(peak, int64(n)) {
+			return
+		}
+	}
+}
+
+// bottleneck returns the name of the stage in sms whose workers have spent
+// the greatest share of their time in its StageFunc, or an empty string if
+// no stage has yet processed an item.
+func bottleneck(sms []StageMetrics) string {
+	var (
+		name string
+		most time.Duration
+	)
+
+	for _, sm := range sms {
+		busy := sm.ProcessingTime / time.Duration(max(sm.Waypoint.Capacity, 1))
+		if busy > most {
+			name, most = sm.Name, busy
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,139 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// batcher holds the configuration for a stage added using AddBatch.
+type batcher struct {
+	size   int
+	window time.Duration
+}
+
+// AddBatch registers a named Pipeline stage that groups its input items into
+// batches, each sent on to the next stage as a []any, holding up to size
+// items. A batch is sent once it is full or, if window is positive, once
+// window has elapsed since its first item was received -- whichever comes
+// first. Any partial batch is sent once no more input is forthcoming. A
+// size less than 1 is treated as 1.
+//
+// For a Pipeline created by NewAck, the items in a batch are Ack'ed (or
+// Nack'ed) together. If items carry state (see ItemFrom), each batch takes
+// that of its first item. Since a batching stage has no StageFunc, StageOptions
+// pertaining to one have no effect and it cannot be resized. See also
+// AddUnbatch.
+func (p *Pipeline) AddBatch(name string, size int, window time.Duration, opts ...StageOption) error {
+	opts = append(opts, func(s *stage) {
+		s.batch = &batcher{max(size, 1), window}
+	})
+
+	return p.Add(name, 1, nil, opts...)
+}
+
+// AddUnbatch registers a named Pipeline stage, as described for Add, that
+// sends on each of the items in its []any input (e.g. as produced by
+// AddBatch) individually. An input of any other type causes an error
+// wrapping ErrWrongType.
+func (p *Pipeline) AddUnbatch(name string, capacity int, opts ...StageOption) error {
+	return p.AddFlatMap(name, capacity, func(ctx context.Context, in any, emit func(any)) error {
+		items, ok := in.([]any)
+		if !ok {
+			return wrongType[[]any](in)
+		}
+
+		for _, v := range items {
+			emit(v)
+		}
+
+		return nil
+	}, opts...)
+}
+
+// batchRunner returns an errgroupx.ContextFunc, used in place of runner for
+// a stage added using AddBatch, that sends batches of the items received
+// from inch to outch.
+func (s *stage) batchRunner(inch <-chan any, outch chan<- any) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer close(outch)
+
+		var (
+			batch []any
+			timer *time.Timer
+			tchan <-chan time.Time
+		)
+
+		flush := func() error {
+			if timer != nil {
+				timer.Stop()
+				timer, tchan = nil, nil
+			}
+
+			if len(batch) == 0 {
+				return nil
+			}
+
+			out := batchOut(batch)
+			batch = nil
+
+			s.stats.out.Add(1)
+
+			return s.send(ctx, out, outch)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case <-tchan:
+				timer, tchan = nil, nil
+				if err := flush(); err != nil {
+					return err
+				}
+
+			case in, ok := <-inch:
+				if !ok {
+					return flush()
+				}
+
+				s.stats.in.Add(1)
+				s.stats.queued(len(inch))
+				batch = append(batch, in)
+
+				if len(batch) == 1 && s.batch.window > 0 {
+					timer = time.NewTimer(s.batch.window)
+					tchan = timer.C
+				}
+
+				if len(batch) >= s.batch.size {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+}
+
+// batchOut returns the []any value to be sent for batch or, if batch holds
+// envelope values, an envelope carrying that value; see combineAcks.
+func batchOut(batch []any) any {
+	if _, ok := batch[0].(*envelope); !ok {
+		return batch
+	}
+
+	msgs := make([]*envelope, len(batch))
+	values := make([]any, len(batch))
+
+	for i, v := range batch {
+		msgs[i] = v.(*envelope)
+		values[i] = msgs[i].Value
+	}
+
+	return combineAcks(msgs, values)
+}
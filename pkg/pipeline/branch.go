@@ -0,0 +1,182 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// A Branch is a sequence of stages executed in parallel with one or more
+// other Branches; see AddBranch.
+type Branch struct {
+	defs []stageDef
+}
+
+// NewBranch returns a new, empty Branch.
+func NewBranch() *Branch {
+	return new(Branch)
+}
+
+// Add appends a stage to the receiver, as described for (*Pipeline).Add,
+// and returns the receiver to allow for chaining. Errors (e.g. a name
+// conflict) are reported by AddBranch.
+func (b *Branch) Add(name string, capacity int, sfunc StageFunc, opts ...StageOption) *Branch {
+	b.defs = append(b.defs, stageDef{name, capacity, sfunc, opts})
+	return b
+}
+
+// Branch returns a new Branch made up of the receiver's stages.
+func (s Stage[In, Out]) Branch() *Branch {
+	return &Branch{defs: s.defs}
+}
+
+// AddBranch registers a set of parallel branches as the Pipeline's next
+// stage. Each item emerging from the previous stage (or from Feed) is sent
+// to every branch and the outputs of all branches are merged, in no
+// particular order, before being sent on to the next stage (or to Collect).
+// Since the same item is passed to each branch, StageFuncs must not modify
+// their input if it is shared (e.g. a pointer or map).
+//
+// Stages within a branch are registered with the receiver under their own
+// names, which must be unique among all of the Pipeline's stages, and may
+// be resized using Resize. For a Pipeline created by NewAck, each item is
+// Ack'ed only once every branch has finished with it.
+//
+// AddBranch returns ErrNoStages if no branches are provided or if any of
+// them is empty; it otherwise returns the same errors as Add.
+func (p *Pipeline) AddBranch(branches ...*Branch) error {
+	if p == nil {
+		return ErrNilReceiver
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.started {
+		return ErrIsStarted
+	}
+
+	if len(branches) == 0 {
+		return ErrNoStages
+	}
+
+	names := make(map[string]bool)
+
+	for _, b := range branches {
+		if b == nil || len(b.defs) == 0 {
+			return ErrNoStages
+		}
+
+		for _, d := range b.defs {
+			if _, ok := p.byname[d.name]; ok || names[d.name] {
+				return ErrNameConflict
+			}
+			names[d.name] = true
+		}
+	}
+
+	p.groups++
+
+	for lane, b := range branches {
+		for _, d := range b.defs {
+			p._register(d, p.groups, lane)
+		}
+	}
+
+	return nil
+}
+
+// fork starts each lane of the branch group made up of the given stages
+// (see chain) along with goroutines to send each item received from in to
+// every lane and to merge their outputs. The channel carrying the merged
+// output is returned.
+func (p *Pipeline) fork(ctx context.Context, eg *errgroupx.Group, stages []stage, in <-chan any) <-chan any {
+	var (
+		ins  []chan<- any
+		outs []<-chan any
+	)
+
+	for i := 0; i < len(stages); {
+		j := i + 1
+		for j < len(stages) && stages[j].lane == stages[i].lane {
+			j++
+		}
+
+		ch := p.newChan(&stages[i])
+		ins = append(ins, ch)
+		outs = append(outs, p.chain(ctx, eg, stages[i:j], ch))
+
+		i = j
+	}
+
+	out := p.newChan(nil)
+
+	eg.GoContext(ctx, fanOut(in, ins))
+	eg.GoContext(ctx, fanIn(outs, out))
+
+	return out
+}
+
+// fanOut returns an errgroupx.ContextFunc that sends each item received from
+// in to every channel in outs, closing them all once in is closed.
+func fanOut(in <-chan any, outs []chan<- any) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer func() {
+			for _, ch := range outs {
+				close(ch)
+			}
+		}()
+
+		for {
+			v, ok, err := Recv[any](ctx, in)
+			if err != nil || !ok {
+				return err
+			}
+
+			var msgs []*envelope
+			if m, ok := v.(*envelope); ok {
+				msgs = m.split(len(outs))
+			}
+
+			for i, ch := range outs {
+				if msgs != nil {
+					v = msgs[i]
+				}
+
+				if err := Send(ctx, v, ch); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// fanIn returns an errgroupx.ContextFunc that merges the items received from
+// each channel in ins into out, closing out once they are all closed.
+func fanIn(ins []<-chan any, out chan<- any) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer close(out)
+
+		eg, ctx, cancel := errgroupx.WithCancel(ctx)
+		defer cancel()
+
+		for _, in := range ins {
+			eg.GoContext(ctx, func(ctx context.Context) error {
+				for {
+					v, ok, err := Recv[any](ctx, in)
+					if err != nil || !ok {
+						return err
+					}
+
+					if err := Send(ctx, v, out); err != nil {
+						return err
+					}
+				}
+			})
+		}
+
+		return eg.Wait()
+	}
+}
@@ -4,8 +4,12 @@ package pipeline
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 )
 
+// Send sends value to ch unless ctx is canceled first, in which case ctx's
+// error is returned.
 func Send[T any](ctx context.Context, value T, ch chan<- any) error {
 	select {
 	case <-ctx.Done():
@@ -15,6 +19,10 @@ func Send[T any](ctx context.Context, value T, ch chan<- any) error {
 	}
 }
 
+// Recv receives a value from ch unless ctx is canceled first, in which case
+// ctx's error is returned. The boolean result is false if ch is closed. If
+// the value received is not of type T, a zero T is returned along with an
+// error wrapping ErrWrongType.
 func Recv[T any](ctx context.Context, ch <-chan any) (T, bool, error) {
 	var (
 		out T
@@ -34,9 +42,76 @@ func Recv[T any](ctx context.Context, ch <-chan any) (T, bool, error) {
 		return out, false, nil
 	}
 
-	if out, ok = val.(T); ok {
+	if out, ok = as[T](val); ok {
 		return out, true, nil
 	}
 
-	return out, true, nil
+	return out, true, wrongType[T](val)
+}
+
+// as returns v as a value of type T. A nil v is accepted (as the zero T) if
+// nil is a valid T; e.g. if T is an interface or pointer type.
+func as[T any](v any) (T, bool) {
+	t, ok := v.(T)
+	if ok || v != nil {
+		return t, ok
+	}
+
+	switch reflect.TypeFor[T]().Kind() {
+	case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return t, true
+	}
+
+	return t, false
+}
+
+// wrongType returns an error wrapping ErrWrongType that describes a value
+// v which was expected to be of type T.
+func wrongType[T any](v any) error {
+	return fmt.Errorf("%w: %T (wanted %v)", ErrWrongType, v, reflect.TypeFor[T]())
+}
+
+// FromChan returns a FeedFunc that sends each value received from in into
+// the Pipeline until in is closed.
+func FromChan[T any](in <-chan T) FeedFunc {
+	return func(ctx context.Context, ch chan<- any) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case v, ok := <-in:
+				if !ok {
+					return nil
+				}
+
+				if err := Send(ctx, v, ch); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// ToChan returns a CollectFunc that sends each data element emerging from
+// the Pipeline (asserted to be of type T) to out. An element of any other
+// type causes an error wrapping ErrWrongType. Since the Pipeline is the
+// sole sender to out, ToChan closes out when it returns.
+func ToChan[T any](out chan<- T) CollectFunc {
+	return func(ctx context.Context, ch <-chan any) error {
+		defer close(out)
+
+		for {
+			v, ok, err := Recv[T](ctx, ch)
+			if err != nil || !ok {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- v:
+			}
+		}
+	}
 }
@@ -0,0 +1,138 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// A Checkpointer persists the progress of a Pipeline so that an interrupted
+// Run may resume where it left off; see WithCheckpoints. Progress is measured
+// as the number of leading items, in the order they were sent by Feed, that
+// have been fully processed.
+type Checkpointer interface {
+	// Load returns the number of items most recently passed to Save (or
+	// zero if there is no checkpoint).
+	Load(ctx context.Context) (uint64, error)
+
+	// Save records that the first n items sent by Feed have been fully
+	// processed.
+	Save(ctx context.Context, n uint64) error
+}
+
+// WithCheckpoints returns an Option supporting resumable batch jobs by
+// periodically saving the Pipeline's progress to c. This requires that Feed
+// send the same items, in the same order, each time the Pipeline is Run (e.g.
+// by reading a file or the rows of a table in a fixed order). When Run is
+// called, the value returned by c.Load is the number of leading items sent
+// by Feed that are discarded rather than processed; for a Pipeline created
+// using NewAck, they are Ack'ed.
+//
+// An item is fully processed once it has reached Collect (for NewAck, once
+// it is Ack'ed) or it has been dropped (e.g. by a stage's ErrorPolicy). The
+// number of leading items fully processed is saved every interval (if it has
+// changed) and once more when the Run ends -- even if it fails, since only
+// fully processed items are counted. Since items are processed concurrently,
+// some beyond the last checkpoint may also have been processed; they are
+// processed again when the Pipeline resumes. An error returned by c fails
+// the Run. If interval is not positive, a value of one second is used. The
+// Checkpointer is not used by DryRun.
+func WithCheckpoints(c Checkpointer, interval time.Duration) Option {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	return func(p *Pipeline) {
+		p.checkpoints = &checkpoints{c, interval}
+	}
+}
+
+type checkpoints struct {
+	c        Checkpointer
+	interval time.Duration
+}
+
+// A watermark tracks the number of leading items, in the order they were
+// received from Feed, that have been fully processed during a single Run.
+type watermark struct {
+	loaded bool
+	next   uint64          // all items before next are done
+	saved  uint64          // value of next when last saved
+	done   map[uint64]bool // items after next that are done
+	mutex
+}
+
+// load sets the receiver's initial value to n, the number of items already
+// processed as of the last checkpoint.
+func (wm *watermark) load(n uint64) {
+	wm.Lock()
+	defer wm.Unlock()
+
+	wm.loaded, wm.next, wm.saved = true, n, n
+	wm.done = make(map[uint64]bool)
+}
+
+// complete marks the item at the given offset as fully processed.
+func (wm *watermark) complete(offset uint64) {
+	wm.Lock()
+	defer wm.Unlock()
+
+	if offset != wm.next {
+		wm.done[offset] = true
+		return
+	}
+
+	for wm.next++; wm.done[wm.next]; wm.next++ {
+		delete(wm.done, wm.next)
+	}
+}
+
+// unsaved returns the receiver's current value if it has advanced since it
+// was last returned; otherwise, false is returned.
+func (wm *watermark) unsaved() (uint64, bool) {
+	wm.Lock()
+	defer wm.Unlock()
+
+	if !wm.loaded || wm.next == wm.saved {
+		return 0, false
+	}
+
+	wm.saved = wm.next
+
+	return wm.next, true
+}
+
+// checkpointFunc returns an errgroupx.ContextFunc that saves the value of wm
+// to the receiver's Checkpointer every interval until either ctx is canceled
+// or the collected channel is closed, either of which triggers a final save.
+func (p *Pipeline) checkpointFunc(wm *watermark, collected <-chan struct{}) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(p.checkpoints.interval)
+		defer ticker.Stop()
+
+		save := func(ctx context.Context) error {
+			if n, ok := wm.unsaved(); ok {
+				return p.checkpoints.c.Save(ctx, n)
+			}
+			return nil
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return save(context.WithoutCancel(ctx))
+
+			case <-collected:
+				return save(ctx)
+
+			case <-ticker.C:
+				if err := save(ctx); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import "github.com/go-sage/synctools/pkg/waypoint"
+
+// WithClock returns an Option that causes the Pipeline to use c, as described
+// for waypoint.WithClock, for the timestamps on its Metrics, State snapshots
+// and items (see ItemInfo.Enqueued) and for measuring the time spent by each
+// stage (e.g. StageMetrics.ProcessingTime); c is also passed to each stage's
+// Waypoint. As with a Waypoint, timers (e.g. retry backoff, batch windows and
+// OnProgress intervals) continue to use real time.
+//
+// A nil Clock is ignored.
+func WithClock(c waypoint.Clock) Option {
+	return func(p *Pipeline) {
+		if c != nil {
+			p.clock = c
+		}
+	}
+}
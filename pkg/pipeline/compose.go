@@ -0,0 +1,76 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+// AddPipeline adds each of the stages registered with sub, in order, to the
+// receiver as if they had been registered with it directly. Each stage's
+// name is qualified by the given name (i.e. "name/stage") and it retains its
+// own capacity, StageFunc (including any replacement; see Replace) and
+// StageOptions; branch groups (see AddBranch) are preserved. An error from
+// any of these stages fails the receiver as usual, with the qualified stage
+// name reported in its StageError. This allows reusable segments to be
+// built (and tested) as Pipelines of their own and then composed.
+//
+// The Feed and Collect methods of sub, along with its Pipeline-level Options
+// (e.g. WithMiddleware), are not used; those of the receiver apply instead.
+// Later changes to sub do not affect the receiver. If sub has no stages
+// then ErrNoStages is returned and, since graph connections (see Connect)
+// cannot be qualified, if sub has any then ErrNotLinear is returned.
+func (p *Pipeline) AddPipeline(name string, sub *Pipeline) error {
+	if p == nil || sub == nil {
+		return ErrNilReceiver
+	}
+
+	if p == sub {
+		return ErrCycle
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	sub.Lock()
+	defer sub.Unlock()
+
+	switch {
+	case p.started:
+		return ErrIsStarted
+	case len(sub.stages) == 0:
+		return ErrNoStages
+	case len(sub.edges) > 0:
+		return ErrNotLinear
+	}
+
+	qualify := func(s *stage) string {
+		return name + "/" + s.name
+	}
+
+	for i := range sub.stages {
+		if _, ok := p.byname[qualify(&sub.stages[i])]; ok {
+			return ErrNameConflict
+		}
+	}
+
+	groups := make(map[int]int)
+
+	for i := range sub.stages {
+		s := &sub.stages[i]
+
+		group := 0
+		if s.group != 0 {
+			if _, ok := groups[s.group]; !ok {
+				p.groups++
+				groups[s.group] = p.groups
+			}
+			group = groups[s.group]
+		}
+
+		sfunc := s.sfunc
+		if f := s.swapped.Load(); f != nil {
+			sfunc = *f
+		}
+
+		p._register(stageDef{qualify(s), s.capacity, sfunc, s.opts}, group, s.lane)
+	}
+
+	return nil
+}
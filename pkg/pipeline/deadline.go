@@ -0,0 +1,64 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// WithItemDeadline returns an Option that gives each item received from Feed
+// the deadline returned by fn (or none, if it returns the zero time). For a
+// Pipeline created using NewAck, a non-zero value replaces the item's own
+// Deadline (see AckItem). For example, each item may be given a TTL of d
+// using:
+//
+//	WithItemDeadline(func(any) time.Time { return time.Now().Add(d) })
+//
+// The deadline is propagated to each stage (and is available using ItemFrom)
+// as the deadline of the Context passed to its StageFunc. An item whose
+// deadline passes before a stage has finished processing it (including any
+// retries; see WithRetry) is dropped rather than being subject to the
+// stage's ErrorPolicy: if that policy is DeadLetter, or the Pipeline was
+// created using WithDeadLetters, the item is first passed to the dead letter
+// function with an Err of ErrExpired. As with Skip, an expired item is
+// Ack'ed and is counted by StageMetrics.Skipped and StageMetrics.Expired.
+//
+// Deadlines are not checked by stages added by AddBatch or AddReduce. Items
+// are wrapped as described for WithMetadata.
+func WithItemDeadline(fn func(v any) time.Time) Option {
+	return func(p *Pipeline) {
+		p.deadlines = fn
+	}
+}
+
+// withDeadline returns a Context for processing the item carried by ctx (see
+// ItemFrom) which, if the item has a deadline, is canceled with a cause of
+// ErrExpired once it passes.
+func withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	is, ok := ctx.Value(itemKey{}).(*itemState)
+	if !ok || is.deadline.IsZero() {
+		return ctx, func() {}
+	}
+
+	return context.WithDeadlineCause(ctx, is.deadline, ErrExpired)
+}
+
+// expired returns true if ctx, as returned by withDeadline, was canceled
+// because its item's deadline has passed.
+func expired(ctx context.Context) bool {
+	return context.Cause(ctx) == ErrExpired
+}
+
+// expire handles input value in, whose deadline passed after the given
+// number of attempts by the receiver's StageFunc, by dropping it; it is
+// first passed to the receiver's dead letter function (if any). The returned
+// error is errSkipped unless that function fails.
+func (s *stage) expire(ctx context.Context, in any, attempt int) error {
+	ep := Skip
+	if s.onError.action == deadLetterAction {
+		ep = s.onError
+	}
+
+	return ep.handle(ctx, s, in, ErrExpired, attempt)
+}
@@ -0,0 +1,143 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// RunDirect executes the stages from s, much like Run, except that items are
+// passed between stages using channels of their own types (rather than
+// chan any). This avoids the allocation needed to store each item (other
+// than a pointer) in an interface value, along with the type assertion made
+// by each stage, which can make a significant difference for pipelines
+// carrying large numbers of small values (e.g. structs) through stages that
+// do relatively little work.
+//
+// In exchange, RunDirect provides only the essentials: each stage runs as
+// many goroutines as its capacity, each of which calls the stage's function
+// for one item at a time, and a Reduce stage folds all of its input into a
+// single value. A StageFunc may return ErrDrop to drop its input; any other
+// error, returned as a *StageError, fails the run. StageOptions are ignored
+// and no Pipeline is created, so there are no Metrics, Middleware, error or
+// retry policies, etc.; use Run for these.
+//
+// The feed function should return once ctx is canceled, as should collect
+// (which should otherwise receive every item from its channel).
+func RunDirect[In, Out any](ctx context.Context, s Stage[In, Out], feed func(context.Context, chan<- In) error, collect func(context.Context, <-chan Out) error) error {
+	if len(s.direct) == 0 {
+		return ErrNoStages
+	}
+
+	eg, ctx, cancel := errgroupx.WithCancel(ctx)
+	defer cancel()
+
+	feedch := make(chan In)
+
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		defer close(feedch)
+		return feed(ctx, feedch)
+	})
+
+	// n.b. Each channel is boxed only once, here, rather than each item.
+	var ch any = (<-chan In)(feedch)
+	for _, df := range s.direct {
+		ch = df(ctx, eg, ch)
+	}
+
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		return collect(ctx, ch.(<-chan Out))
+	})
+
+	return eg.Wait()
+}
+
+// A directFunc starts the goroutines for a single stage, as part of group eg,
+// that process the items received from in (a <-chan In) and returns the
+// channel (a <-chan Out) to which they send their results; see RunDirect.
+type directFunc func(ctx context.Context, eg *errgroupx.Group, in any) any
+
+// directStage returns the directFunc for a stage named name that runs up to
+// capacity workers, each of which calls newFunc once for the function it
+// calls with each of its items.
+func directStage[In, Out any](name string, capacity int, newFunc func() func(context.Context, In) (Out, error)) directFunc {
+	return func(ctx context.Context, eg *errgroupx.Group, v any) any {
+		var (
+			in  = v.(<-chan In)
+			out = make(chan Out)
+			wg  sync.WaitGroup
+		)
+
+		for range max(capacity, 1) {
+			wg.Add(1)
+
+			eg.GoContext(ctx, func(ctx context.Context) error {
+				defer wg.Done()
+
+				fn := newFunc()
+
+				for item := range in {
+					res, err := fn(ctx, item)
+
+					switch {
+					case errors.Is(err, ErrDrop):
+						continue
+					case err != nil:
+						return &StageError{Stage: name, Input: item, Err: err, Attempt: 1}
+					}
+
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case out <- res:
+					}
+				}
+
+				return nil
+			})
+		}
+
+		go func() {
+			wg.Wait()
+			close(out)
+		}()
+
+		return (<-chan Out)(out)
+	}
+}
+
+// directReduce returns the directFunc for a Reduce stage named name.
+func directReduce[T, A any](name string, seed A, fn func(context.Context, A, T) (A, error)) directFunc {
+	return func(ctx context.Context, eg *errgroupx.Group, v any) any {
+		var (
+			in  = v.(<-chan T)
+			out = make(chan A)
+		)
+
+		eg.GoContext(ctx, func(ctx context.Context) error {
+			defer close(out)
+
+			acc := seed
+
+			for item := range in {
+				var err error
+				if acc, err = fn(ctx, acc, item); err != nil {
+					return &StageError{Stage: name, Input: item, Err: err, Attempt: 1}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- acc:
+				return nil
+			}
+		})
+
+		return (<-chan A)(out)
+	}
+}
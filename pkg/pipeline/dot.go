@@ -0,0 +1,116 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dot returns a description of the receiver's topology in the Graphviz DOT
+// language. Each stage is shown along with its capacity (its current
+// capacity, if the receiver is running) and items flow from a "Feed" node,
+// along the edges between stages (see Connect and AddBranch), to a "Collect"
+// node. The stages of each branch group are enclosed in a cluster and
+// routers (see AddRouter) are drawn as diamonds. The output may be rendered
+// using, for example:
+//
+//	dot -Tsvg -o pipeline.svg
+func (p *Pipeline) Dot() string {
+	if p == nil {
+		return ""
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	var (
+		b      strings.Builder
+		outdeg = make([]int, len(p.stages))
+		indeg  = make([]int, len(p.stages))
+		edges  = p._flowEdges()
+	)
+
+	name := p.name
+	if name == "" {
+		name = "pipeline"
+	}
+
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(name))
+	b.WriteString("\trankdir=LR;\n\tnode [shape=box];\n")
+	b.WriteString("\tfeed [label=\"Feed\" shape=oval];\n\tcollect [label=\"Collect\" shape=oval];\n")
+
+	for i := range p.stages {
+		s := &p.stages[i]
+
+		if s.group != 0 && (i == 0 || p.stages[i-1].group != s.group) {
+			fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=\"branch\";\n", s.group)
+		}
+
+		indent := "\t"
+		if s.group != 0 {
+			indent = "\t\t"
+		}
+
+		fmt.Fprintf(&b, "%ss%d [label=%s%s];\n", indent, i, dotQuote(s.dotLabel()), s.dotShape())
+
+		if s.group != 0 && (i == len(p.stages)-1 || p.stages[i+1].group != s.group) {
+			b.WriteString("\t}\n")
+		}
+	}
+
+	for _, e := range edges {
+		outdeg[e.from]++
+		indeg[e.to]++
+		fmt.Fprintf(&b, "\ts%d -> s%d;\n", e.from, e.to)
+	}
+
+	for i := range p.stages {
+		if indeg[i] == 0 {
+			fmt.Fprintf(&b, "\tfeed -> s%d;\n", i)
+		}
+
+		if outdeg[i] == 0 {
+			fmt.Fprintf(&b, "\ts%d -> collect;\n", i)
+		}
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// dotLabel returns the label for the receiver's node in the output of Dot.
+func (s *stage) dotLabel() string {
+	capacity := s.capacity
+	if s.waypt != nil {
+		capacity = s.waypt.Metrics().Capacity
+	}
+
+	switch {
+	case s.batch != nil:
+		return fmt.Sprintf("%s\nbatch of %d", s.name, s.batch.size)
+	case s.reduce != nil:
+		return fmt.Sprintf("%s\nreduce", s.name)
+	}
+
+	return fmt.Sprintf("%s\ncapacity %d", s.name, capacity)
+}
+
+// dotShape returns the attribute (if any) giving the shape of the receiver's
+// node in the output of Dot.
+func (s *stage) dotShape() string {
+	if s.routes != nil {
+		return " shape=diamond"
+	}
+
+	return ""
+}
+
+// dotQuote returns s as a double-quoted DOT string; newlines are converted to
+// line breaks.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -10,9 +10,16 @@ func (s errstr) Error() string {
 
 const (
 	ErrCorrupted    = errstr("pipeline state is corrupted")
+	ErrCycle        = errstr("pipeline stages form a cycle")
+	ErrDrop         = errstr("drop this item")
+	ErrExpired      = errstr("item deadline exceeded")
 	ErrIsStarted    = errstr("pipeline is already started")
 	ErrNameConflict = errstr("stage name conflict")
 	ErrNameUnknown  = errstr("stage name not found")
 	ErrNilReceiver  = errstr("nil receiver")
+	ErrNoStageFunc  = errstr("stage has no StageFunc")
 	ErrNoStages     = errstr("no pipeline stages registered")
+	ErrNotLinear    = errstr("pipeline is not linear")
+	ErrTimeout      = errstr("stage timeout exceeded")
+	ErrWrongType    = errstr("unexpected data element type")
 )
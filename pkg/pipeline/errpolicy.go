@@ -0,0 +1,188 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// An ErrorPolicy determines how a stage reacts when its StageFunc returns an
+// error for an item; see OnError. The zero value is equivalent to Fail.
+type ErrorPolicy struct {
+	action errAction
+	retry  *RetryPolicy
+	dlfunc func(context.Context, *StageError) error
+}
+
+type errAction int
+
+const (
+	failAction errAction = iota
+	skipAction
+	deadLetterAction
+)
+
+var (
+	// Fail causes an item's error to fail the entire Pipeline (i.e. Run
+	// returns the error). This is the default ErrorPolicy.
+	Fail = ErrorPolicy{}
+
+	// Skip causes a failed item to be dropped; the Pipeline continues with
+	// the next item. If the Pipeline was created using WithDeadLetters, the
+	// item is first routed there. For a Pipeline created by NewAck, a skipped
+	// item is Ack'ed since it should not be redelivered.
+	Skip = ErrorPolicy{action: skipAction}
+)
+
+// Retry returns an ErrorPolicy that calls the StageFunc for a failed item
+// up to n more times, waiting backoff between each attempt. If the item
+// still fails, it fails the Pipeline (as with Fail). It is shorthand for
+// using OnError(Fail) along with a RetryPolicy having a MaxAttempts of n+1
+// and a ConstantBackoff; see WithRetry.
+func Retry(n int, backoff time.Duration) ErrorPolicy {
+	return ErrorPolicy{retry: &RetryPolicy{
+		MaxAttempts: n + 1,
+		Backoff:     ConstantBackoff(backoff),
+	}}
+}
+
+// DeadLetter returns an ErrorPolicy that passes each failed item, wrapped
+// in a *StageError, to fn and then drops the item as with Skip. If fn
+// returns an error, it fails the Pipeline.
+func DeadLetter(fn func(ctx context.Context, se *StageError) error) ErrorPolicy {
+	return ErrorPolicy{action: deadLetterAction, dlfunc: fn}
+}
+
+// OnError returns a StageOption that sets the stage's ErrorPolicy. Note that
+// an ErrorPolicy created by Retry also sets the stage's RetryPolicy.
+func OnError(ep ErrorPolicy) StageOption {
+	return func(s *stage) {
+		s.onError = ep
+		if ep.retry != nil {
+			s.retry = *ep.retry
+		}
+	}
+}
+
+// WithDeadLetters returns an Option that routes each item dropped by a stage
+// using the Skip ErrorPolicy, wrapped in a *StageError, to fn. If fn returns
+// an error, it fails the Pipeline. Note that stages using DeadLetter call
+// their own function instead.
+func WithDeadLetters(fn func(ctx context.Context, se *StageError) error) Option {
+	return func(p *Pipeline) {
+		p.deadLetter = fn
+	}
+}
+
+// DeadLetterChan returns a function, suitable for use with WithDeadLetters
+// or DeadLetter, that sends each *StageError to ch (unless its Context is
+// canceled first). The Pipeline never closes ch.
+func DeadLetterChan(ch chan<- *StageError) func(context.Context, *StageError) error {
+	return func(ctx context.Context, se *StageError) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- se:
+			return nil
+		}
+	}
+}
+
+// A StageError describes an item for which a stage's StageFunc returned an
+// error. An error that fails a Pipeline (see Fail) is returned by Run as a
+// *StageError, which may be retrieved using errors.As.
+type StageError struct {
+	Stage   string // The stage's registered name
+	Input   any    // The input value passed to the StageFunc
+	Err     error  // The error returned by the StageFunc
+	Worker  uint64 // ID of the stage's waypoint.Worker (or zero; see ItemInfo)
+	Attempt int    // The final attempt made (see WithRetry)
+}
+
+func (se *StageError) Error() string {
+	return fmt.Sprintf("stage %q: %v", se.Stage, se.Err)
+}
+
+func (se *StageError) Unwrap() error {
+	return se.Err
+}
+
+// A PanicError is the error produced when a stage's StageFunc panics. It is
+// subject to the stage's RetryPolicy and ErrorPolicy like any other error.
+type PanicError struct {
+	Stage string // The stage's registered name
+	Value any    // The value passed to panic
+	Stack []byte // The stack trace of the panicking goroutine
+}
+
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("stage %q: panic: %v", pe.Stage, pe.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error (or nil
+// otherwise).
+func (pe *PanicError) Unwrap() error {
+	err, _ := pe.Value.(error)
+	return err
+}
+
+// errSkipped is returned by (*stage).call when a failed item has been
+// handled by the stage's ErrorPolicy and should be dropped.
+const errSkipped = errstr("item skipped")
+
+// A failedError is returned by (*stage).call, in place of errSkipped, when a
+// failed item has been recorded by ContinueOnError. The item is dropped, as
+// with errSkipped, but it is Nack'ed (with the StageError) rather than Ack'ed.
+type failedError struct {
+	*StageError
+}
+
+// isDropped returns true if err, as returned by (*stage).call, indicates that
+// its item was dropped rather than failing the Pipeline.
+func isDropped(err error) bool {
+	_, failed := err.(failedError)
+	return err == errSkipped || failed
+}
+
+// handle applies the receiver to the err returned by stage s for input in
+// (on the given attempt). A nil error is returned only if err is nil.
+func (ep ErrorPolicy) handle(ctx context.Context, s *stage, in any, err error, attempt int) error {
+	if err == nil {
+		return nil
+	}
+
+	se := &StageError{
+		Stage:   s.name,
+		Input:   in,
+		Err:     err,
+		Worker:  workerFrom(ctx),
+		Attempt: attempt,
+	}
+
+	switch ep.action {
+	case skipAction:
+		if s.deadLetter == nil {
+			return errSkipped
+		}
+
+		if derr := s.deadLetter(ctx, se); derr != nil {
+			return derr
+		}
+		return errSkipped
+
+	case deadLetterAction:
+		if derr := ep.dlfunc(ctx, se); derr != nil {
+			return derr
+		}
+		return errSkipped
+	}
+
+	if s.report != nil {
+		s.report.add(se)
+		return failedError{se}
+	}
+
+	return se
+}
@@ -0,0 +1,131 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import "time"
+
+// LifecycleEventType identifies the kind of change described by a
+// LifecycleEvent.
+type LifecycleEventType string
+
+const (
+	// PipelineStarted events are emitted once each time the Pipeline is
+	// run (see Run, Start and RunSequential) and precede all others.
+	PipelineStarted = LifecycleEventType("PipelineStarted")
+
+	// StageStarted events are emitted as each stage begins processing
+	// items; i.e. after its setup function (see WithSetup) has returned.
+	StageStarted = LifecycleEventType("StageStarted")
+
+	// StageDrained events are emitted as each stage finishes, having
+	// processed all of its input and passed on all of its output.
+	StageDrained = LifecycleEventType("StageDrained")
+
+	// StageFailed events are emitted for each stage that stops due to an
+	// error of its own (including one from its setup or teardown
+	// functions).
+	StageFailed = LifecycleEventType("StageFailed")
+
+	// StageCanceled events are emitted for each stage that stops early
+	// because the Pipeline was canceled; e.g. by another stage's failure
+	// or by the Context passed to Run.
+	StageCanceled = LifecycleEventType("StageCanceled")
+
+	// PipelineFinished events are emitted once each Run has finished and
+	// follow all others.
+	PipelineFinished = LifecycleEventType("PipelineFinished")
+)
+
+// A LifecycleEvent describes a change in the state of a running Pipeline or
+// one of its stages; see OnLifecycle.
+type LifecycleEvent struct {
+	Type     LifecycleEventType
+	Time     time.Time // see WithClock
+	Pipeline string    // the Pipeline's name (see WithName)
+	Stage    string    // the stage's registered name (or empty)
+
+	// Err is the error that caused a StageFailed (or StageCanceled) event
+	// or, for a PipelineFinished event, the error returned by Run.
+	Err error
+}
+
+// OnLifecycle returns an Option that causes fn to be called with a
+// LifecycleEvent as the Pipeline starts and finishes each Run and as each of
+// its stages starts and then drains, fails or is canceled. This allows, for
+// example, an orchestration layer to drive a status display or alerting.
+//
+// Events are delivered, in the order they occurred, by a single goroutine
+// so that fn need not be safe for concurrent use. A slow fn does not hold up
+// the Pipeline's stages but Run does not return until fn has been called for
+// every event (including PipelineFinished). When using RunSequential, in
+// which all stages are run by a single goroutine, an error is reported by a
+// StageFailed event for each stage.
+func OnLifecycle(fn func(LifecycleEvent)) Option {
+	return func(p *Pipeline) {
+		p.onEvent = fn
+	}
+}
+
+// LifecycleChan returns a function, suitable for use with OnLifecycle, that
+// sends each LifecycleEvent to ch. The Pipeline never closes ch.
+func LifecycleChan(ch chan<- LifecycleEvent) func(LifecycleEvent) {
+	return func(ev LifecycleEvent) {
+		ch <- ev
+	}
+}
+
+// An eventStream delivers the LifecycleEvents for a single Run to the
+// function registered using OnLifecycle. Its channel is sized to hold every
+// event for the Run so that sending never blocks. A nil *eventStream emits
+// nothing.
+type eventStream struct {
+	pipeline string
+	ch       chan LifecycleEvent
+	done     chan struct{} // closed once every event has been delivered
+}
+
+// _startEvents creates the eventStream for a new Run (if the receiver has
+// an OnLifecycle function) and emits its PipelineStarted event.
+func (p *Pipeline) _startEvents() {
+	p.events = nil
+	if p.onEvent == nil {
+		return
+	}
+
+	es := &eventStream{
+		pipeline: p.name,
+		ch:       make(chan LifecycleEvent, 2+2*len(p.stages)),
+		done:     make(chan struct{}),
+	}
+
+	go func(fn func(LifecycleEvent)) {
+		defer close(es.done)
+		for ev := range es.ch {
+			fn(ev)
+		}
+	}(p.onEvent)
+
+	es.ch <- LifecycleEvent{Type: PipelineStarted, Time: p.clock.Now(), Pipeline: p.name}
+
+	p.events = es
+}
+
+// stageEvent emits a LifecycleEvent of type et for stage s.
+func (es *eventStream) stageEvent(et LifecycleEventType, s *stage, err error) {
+	if es != nil {
+		es.ch <- LifecycleEvent{Type: et, Time: s.clock.Now(), Pipeline: es.pipeline, Stage: s.name, Err: err}
+	}
+}
+
+// finish emits the PipelineFinished event for a Run that returned err and
+// then waits for every event to be delivered.
+func (es *eventStream) finish(now time.Time, err error) {
+	if es == nil {
+		return
+	}
+
+	es.ch <- LifecycleEvent{Type: PipelineFinished, Time: now, Pipeline: es.pipeline, Err: err}
+	close(es.ch)
+
+	<-es.done
+}
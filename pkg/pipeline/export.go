@@ -0,0 +1,160 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// A StateStore persists the State snapshots periodically exported by a
+// Pipeline configured using WithStateExport.
+type StateStore interface {
+	Export(ctx context.Context, state State) error
+}
+
+// State is a point-in-time snapshot of a running Pipeline's progress.
+type State struct {
+	Timestamp time.Time    // Time this snapshot was taken
+	Fed       uint64       // Number of items received from Feed
+	Emitted   uint64       // Number of items sent on toward Collect
+	Stages    []StageState // Per-stage item counts, in stage order
+	Final     bool         // True for the last snapshot of a Run
+}
+
+// StageState holds the item counts for a single stage in a State snapshot.
+type StageState struct {
+	Name     string // The stage's registered name
+	In       uint64 // Items passed to the stage's StageFunc
+	Out      uint64 // Items successfully returned by the StageFunc
+	Errors   uint64 // Items for which the StageFunc returned an error
+	Skipped  uint64 // Items dropped by the StageFunc or its ErrorPolicy
+	InFlight uint64 // Items currently being processed by the StageFunc
+}
+
+// WithStateExport returns an Option supporting a "crash-only" style of
+// operation by exporting the Pipeline's State to store every interval while
+// it is running. A final State (with its Final field set) is exported once
+// Collect returns successfully. An abrupt termination of the process will
+// therefore lose, at most, one interval's worth of progress.
+//
+// Note that an error returned by store is treated like any other Pipeline
+// error; i.e. it causes Run to fail. If interval is not positive, a value
+// of one second is used.
+func WithStateExport(store StateStore, interval time.Duration) Option {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	return func(p *Pipeline) {
+		p.store = store
+		p.exportEvery = interval
+	}
+}
+
+// stageStats holds counters maintained for each stage while it is running.
+type stageStats struct {
+	in      atomic.Uint64
+	out     atomic.Uint64
+	errs    atomic.Uint64
+	skipped atomic.Uint64
+	expired atomic.Uint64 // items dropped by WithItemDeadline
+	done    atomic.Uint64 // calls completed
+	busy    atomic.Int64  // nanoseconds spent in the StageFunc
+	blocked atomic.Int64  // nanoseconds spent sending output
+	peak    atomic.Int64  // greatest input channel length observed
+	spilled atomic.Uint64 // items written to disk; see WithSpill
+}
+
+// state returns a snapshot of the receiver's current State.
+func (p *Pipeline) state(final bool) State {
+	p.Lock()
+	defer p.Unlock()
+
+	st := State{
+		Timestamp: p.clock.Now(),
+		Stages:    make([]StageState, len(p.stages)),
+		Final:     final,
+	}
+
+	for i := range p.stages {
+		s := &p.stages[i]
+		done := s.stats.done.Load() // n.b. loaded before 'in'
+
+		st.Stages[i] = StageState{
+			Name:    s.name,
+			In:      s.stats.in.Load(),
+			Out:     s.stats.out.Load(),
+			Errors:  s.stats.errs.Load(),
+			Skipped: s.stats.skipped.Load(),
+		}
+
+		st.Stages[i].InFlight = st.Stages[i].In - done
+	}
+
+	if n := len(st.Stages); n > 0 {
+		st.Fed = st.Stages[0].In
+		st.Emitted = st.Stages[n-1].Out
+	}
+
+	if len(p.edges) > 0 {
+		// Every stage without inbound edges receives each item from Feed
+		// and every stage without outbound edges emits to Collect.
+		indeg, outdeg := p._degrees()
+		st.Fed, st.Emitted = 0, 0
+
+		for i := len(p.stages) - 1; i >= 0; i-- {
+			if indeg[i] == 0 {
+				st.Fed = st.Stages[i].In
+			}
+
+			if outdeg[i] == 0 {
+				st.Emitted += st.Stages[i].Out
+			}
+		}
+
+		return st
+	}
+
+	// If the final stage belongs to a branch group, each of that group's
+	// lanes emits its own output.
+	if n := len(p.stages); n > 0 && p.stages[n-1].group != 0 {
+		last := &p.stages[n-1]
+
+		for i := n - 2; i >= 0 && p.stages[i].group == last.group; i-- {
+			if p.stages[i].lane != p.stages[i+1].lane {
+				st.Emitted += st.Stages[i].Out
+			}
+		}
+	}
+
+	return st
+}
+
+// exportFunc returns an errgroupx.ContextFunc that exports the receiver's
+// State to its StateStore until either ctx is canceled or the collected
+// channel is closed (which triggers a final export).
+func (p *Pipeline) exportFunc(collected <-chan struct{}) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(p.exportEvery)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+
+			case <-collected:
+				return p.store.Export(ctx, p.state(true))
+
+			case <-ticker.C:
+				if err := p.store.Export(ctx, p.state(false)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import "context"
+
+// An EmitFunc is the function called to process each piece of data for a
+// stage registered using AddFlatMap. It calls emit once for each output
+// value it produces; it may do so zero or more times.
+type EmitFunc func(ctx context.Context, input any, emit func(output any)) error
+
+// AddFlatMap registers a named Pipeline stage, as described for Add, which
+// may produce any number of output items for each of its input items (e.g.
+// splitting a batch into its individual records). Items are sent on to the
+// next stage, in the order they were emitted, once fn returns successfully;
+// if fn returns an error, none are sent and the error is handled like any
+// other StageFunc error.
+//
+// For a Pipeline created by NewAck, an input item is Ack'ed only once all
+// of the items emitted for it have been Ack'ed (or immediately, if none
+// were emitted).
+func (p *Pipeline) AddFlatMap(name string, capacity int, fn EmitFunc, opts ...StageOption) error {
+	return p.Add(name, capacity, func(ctx context.Context, in any) (any, error) {
+		var out flatOut
+
+		if err := fn(ctx, in, func(v any) { out = append(out, v) }); err != nil {
+			return nil, err
+		}
+
+		return out, nil
+	}, opts...)
+}
+
+// flatOut holds the output items from a stage added using AddFlatMap.
+type flatOut []any
+
+// apply calls the receiver's StageFunc (see call) for v or, if v is a
+// flatOut, for each of its items; items that are dropped are omitted from
+// the result.
+func (s *stage) apply(ctx context.Context, v any) (any, error) {
+	fo, ok := v.(flatOut)
+	if !ok {
+		return s.call(ctx, v)
+	}
+
+	var out flatOut
+
+	for _, in := range fo {
+		switch v, err := s.call(ctx, in); {
+		case isDropped(err):
+			continue
+		case err != nil:
+			return nil, err
+		default:
+			out = append(out, v)
+		}
+	}
+
+	return out, nil
+}
+
+// sendOut sends out to ch as a single item unless it is a flatOut (or an
+// envelope carrying one), in which case each of its items is sent separately.
+func sendOut(ctx context.Context, out any, ch chan<- any) error {
+	return eachOut(out, func(item any) error {
+		return Send(ctx, item, ch)
+	})
+}
+
+// eachOut calls fn with out or, if it is a flatOut (or an envelope carrying
+// one), with each of its items in turn. An envelope carrying an empty flatOut
+// is Ack'ed instead.
+func eachOut(out any, fn func(item any) error) error {
+	switch v := out.(type) {
+	case flatOut:
+		for _, item := range v {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *envelope:
+		fo, ok := v.Value.(flatOut)
+		if !ok {
+			break
+		}
+
+		if len(fo) == 0 {
+			v.ack()
+			return nil
+		}
+
+		for i, m := range v.split(len(fo)) {
+			m.Value = fo[i]
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fn(out)
+}
@@ -0,0 +1,126 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// FromCSV returns a FeedFunc that reads each record from r, converts it to
+// a value of type T using decode, and sends that value into the Pipeline.
+// If decode is nil, each record is sent as-is (as a []string) regardless
+// of T. Any error returned by r or decode is returned.
+//
+// The caller may configure r (e.g. its Comma or FieldsPerRecord fields)
+// before the Pipeline is run.
+func FromCSV[T any](r *csv.Reader, decode func(record []string) (T, error)) FeedFunc {
+	return func(ctx context.Context, ch chan<- any) error {
+		for {
+			rec, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			var v any = rec
+
+			if decode != nil {
+				if v, err = decode(rec); err != nil {
+					return err
+				}
+			}
+
+			if err := Send(ctx, v, ch); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ToCSV returns a CollectFunc that converts each data element emerging from
+// the Pipeline (asserted to be of type T) into a record using encode and
+// writes that record to w. If encode is nil, each element must be a []string.
+// The writer is flushed before the CollectFunc returns and any error that
+// occurs while encoding or writing is returned.
+func ToCSV[T any](w *csv.Writer, encode func(T) ([]string, error)) CollectFunc {
+	return func(ctx context.Context, ch <-chan any) error {
+		defer w.Flush()
+
+		for {
+			var (
+				rec []string
+				ok  bool
+				err error
+			)
+
+			if encode == nil {
+				rec, ok, err = Recv[[]string](ctx, ch)
+			} else {
+				var v T
+				if v, ok, err = Recv[T](ctx, ch); ok && err == nil {
+					rec, err = encode(v)
+				}
+			}
+
+			switch {
+			case err != nil:
+				return err
+			case !ok:
+				w.Flush()
+				return w.Error()
+			}
+
+			if err := w.Write(rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// FromJSONLines returns a FeedFunc that decodes a stream of JSON values
+// (e.g. one per line) from r into values of type T and sends each of them
+// into the Pipeline. Any decoding error is returned.
+func FromJSONLines[T any](r io.Reader) FeedFunc {
+	return func(ctx context.Context, ch chan<- any) error {
+		dec := json.NewDecoder(r)
+
+		for {
+			var v T
+
+			if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if err := Send(ctx, v, ch); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ToJSONLines returns a CollectFunc that encodes each data element emerging
+// from the Pipeline as JSON and writes it, followed by a newline, to w. Any
+// encoding (or writing) error is returned.
+func ToJSONLines(w io.Writer) CollectFunc {
+	return func(ctx context.Context, ch <-chan any) error {
+		enc := json.NewEncoder(w)
+
+		for {
+			v, ok, err := Recv[any](ctx, ch)
+			if err != nil || !ok {
+				return err
+			}
+
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+	}
+}
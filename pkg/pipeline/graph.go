@@ -0,0 +1,241 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// An edge connects the output of one stage to the input of another; see
+// Connect. Stages are identified by their index.
+type edge struct {
+	from int
+	to   int
+}
+
+// Connect adds an edge to the receiver's graph of stages such that the
+// output of the stage named from is sent to the stage named to. Both stages
+// must already be registered (using Add or AddBranch) or ErrNameUnknown is
+// returned. Connecting the same two stages more than once has no effect.
+//
+// Once Connect has been called, data flows through the receiver only along
+// the edges it defines -- rather than in the order stages were registered
+// -- allowing for any directed acyclic graph of stages. In that case:
+//
+//   - each item sent by Feed is sent to every stage with no inbound edges,
+//   - each stage with multiple outbound edges (a split) sends its output
+//     to every one of those stages,
+//   - each stage with multiple inbound edges (a join) merges its input
+//     from all of them, in no particular order, and
+//   - the output of every stage with no outbound edges is sent to Collect.
+//
+// As with AddBranch, items sent to multiple stages are shared and must not
+// be modified. Inline stages (see WithInline) are not fused when running a
+// graph. The graph is validated (see Validate) when Run is called.
+func (p *Pipeline) Connect(from, to string) error {
+	if p == nil {
+		return ErrNilReceiver
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.started {
+		return ErrIsStarted
+	}
+
+	fi, ok := p.byname[from]
+	if !ok {
+		return ErrNameUnknown
+	}
+
+	ti, ok := p.byname[to]
+	if !ok {
+		return ErrNameUnknown
+	}
+
+	p._connect(edge{fi, ti})
+
+	return nil
+}
+
+// _connect adds e to the receiver's edges unless it is already present.
+func (p *Pipeline) _connect(e edge) {
+	for _, x := range p.edges {
+		if x == e {
+			return
+		}
+	}
+
+	p.edges = append(p.edges, e)
+}
+
+// Validate checks that the receiver's stages are properly defined without
+// running anything. It returns ErrNoStages if no stages have been registered
+// or ErrCycle if edges added by Connect form a cycle. Otherwise, each stage
+// is checked for a non-negative capacity (wrapping waypoint.ErrInvalidCapacity;
+// a stage with zero capacity is paused until it is resized, see Resize)
+// and a StageFunc (wrapping ErrNoStageFunc) and, where the types of adjacent
+// stages are known (e.g. those created using NewStage or Reduce), that the
+// output of each may be passed to the next (wrapping ErrWrongType); all such
+// problems are reported, joined using errors.Join, or nil is returned. Note
+// that stage names are already checked for uniqueness as stages are added.
+//
+// Validate is called by Run but may also be called beforehand; see also
+// DryRun.
+func (p *Pipeline) Validate() error {
+	if p == nil {
+		return ErrNilReceiver
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	return p._validate()
+}
+
+func (p *Pipeline) _validate() error {
+	if len(p.stages) == 0 {
+		return ErrNoStages
+	}
+
+	// Kahn's algorithm: repeatedly remove stages having no inbound edges;
+	// any stages that remain are part of a cycle.
+	indeg := make([]int, len(p.stages))
+	for _, e := range p.edges {
+		indeg[e.to]++
+	}
+
+	var ready []int
+	for i, n := range indeg {
+		if n == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	seen := 0
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		seen++
+
+		for _, e := range p.edges {
+			if e.from != i {
+				continue
+			}
+
+			if indeg[e.to]--; indeg[e.to] == 0 {
+				ready = append(ready, e.to)
+			}
+		}
+	}
+
+	if seen != len(p.stages) {
+		return ErrCycle
+	}
+
+	return p._checkStages()
+}
+
+// _degrees returns the number of inbound and outbound edges for each of the
+// receiver's stages.
+func (p *Pipeline) _degrees() (indeg, outdeg []int) {
+	indeg = make([]int, len(p.stages))
+	outdeg = make([]int, len(p.stages))
+
+	for _, e := range p.edges {
+		outdeg[e.from]++
+		indeg[e.to]++
+	}
+
+	return indeg, outdeg
+}
+
+// graph starts a runner for each of the receiver's stages, connected as
+// described for Connect, with items received from feed going to every stage
+// having no inbound edges. The channel carrying the merged output of all
+// stages having no outbound edges is returned.
+func (p *Pipeline) graph(ctx context.Context, eg *errgroupx.Group, feed <-chan any) <-chan any {
+	var (
+		ins   = make([][]<-chan any, len(p.stages))
+		outs  = make([][]chan<- any, len(p.stages))
+		dests = make([]map[int]chan<- any, len(p.stages))
+		roots []int
+		sinks []<-chan any
+	)
+
+	for _, e := range p.edges {
+		ch := p.newChan(&p.stages[e.to])
+		outs[e.from] = append(outs[e.from], ch)
+		ins[e.to] = append(ins[e.to], ch)
+
+		if dests[e.from] == nil {
+			dests[e.from] = make(map[int]chan<- any)
+		}
+		dests[e.from][e.to] = ch
+	}
+
+	for i := range p.stages {
+		if len(ins[i]) == 0 {
+			roots = append(roots, i)
+		}
+
+		if len(outs[i]) == 0 {
+			ch := p.newChan(nil)
+			sinks = append(sinks, ch)
+			outs[i] = []chan<- any{ch}
+		}
+	}
+
+	if len(roots) == 1 {
+		ins[roots[0]] = []<-chan any{feed}
+	} else {
+		rchans := make([]chan<- any, len(roots))
+		for j, i := range roots {
+			ch := p.newChan(&p.stages[i])
+			rchans[j] = ch
+			ins[i] = []<-chan any{ch}
+		}
+
+		eg.GoContext(ctx, fanOut(feed, rchans))
+	}
+
+	for i := range p.stages {
+		s := &p.stages[i]
+		p.inherit(s)
+		s.init()
+
+		in := ins[i][0]
+		if len(ins[i]) > 1 {
+			ch := p.newChan(s)
+			eg.GoContext(ctx, fanIn(ins[i], ch))
+			in = ch
+		}
+
+		out := outs[i][0]
+		switch {
+		case s.routes != nil:
+			ch := p.newChan(nil)
+			eg.GoContext(ctx, routeOut(ch, s.routes, dests[i], outs[i], p.envelopes))
+			out = ch
+
+		case len(outs[i]) > 1:
+			ch := p.newChan(nil)
+			eg.GoContext(ctx, fanOut(ch, outs[i]))
+			out = ch
+		}
+
+		eg.GoContext(ctx, s.runner(in, out))
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+
+	out := p.newChan(nil)
+	eg.GoContext(ctx, fanIn(sinks, out))
+
+	return out
+}
@@ -0,0 +1,88 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import "context"
+
+// A Handle is returned by Start for managing a Pipeline running in the
+// background.
+type Handle struct {
+	p    *Pipeline
+	done chan struct{}
+	err  error
+}
+
+// Start is the non-blocking equivalent of Run; it starts the receiver in
+// the background and returns a Handle that may be used to wait for it to
+// complete, stop it or inspect its progress. Errors that would have been
+// returned by Run before any of its goroutines are started (e.g. ErrNoStages
+// or ErrCycle) are returned here; all others are reported by the Handle.
+func (p *Pipeline) Start(ctx context.Context) (*Handle, error) {
+	if p == nil {
+		return nil, ErrNilReceiver
+	}
+
+	eg, cancel, err := p.run(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handle{p: p, done: make(chan struct{})}
+
+	go func() {
+		defer close(h.done)
+		h.err = p.wait(eg, cancel)
+	}()
+
+	return h, nil
+}
+
+// Wait blocks until the Pipeline has finished and then returns the same
+// error that would have been returned by Run.
+func (h *Handle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Done returns a channel that is closed once the Pipeline has finished.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err returns nil while the Pipeline is still running. Once it has finished,
+// Err returns the same error as Wait.
+func (h *Handle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Stop gracefully stops the Pipeline (see (*Pipeline).Stop) and waits for it
+// to finish. Stop returns nil once the Pipeline has finished (see Wait for
+// its result) or, if ctx is done first, ctx.Err().
+func (h *Handle) Stop(ctx context.Context) error {
+	select {
+	case <-h.done:
+		return nil
+	default:
+	}
+
+	if err := h.p.Stop(ctx); err != nil {
+		return err
+	}
+
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Metrics returns a point-in-time Metrics value for the Pipeline.
+func (h *Handle) Metrics() Metrics {
+	return h.p.Metrics()
+}
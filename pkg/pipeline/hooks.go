@@ -0,0 +1,89 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// WithSetup returns a StageOption that causes fn to be called once, each
+// time the Pipeline is run, before the stage processes its first item. This
+// is the place to acquire resources needed by the StageFunc (e.g. database
+// connections or open files). If fn returns an error, the Pipeline fails.
+func WithSetup(fn func(ctx context.Context) error) StageOption {
+	return func(s *stage) {
+		s.setup = fn
+	}
+}
+
+// WithTeardown returns a StageOption that causes fn to be called once, each
+// time the Pipeline is run, after the stage has processed its last item --
+// whether or not the Pipeline succeeded -- provided that its setup function
+// (see WithSetup), if any, returned successfully. The Context passed to fn
+// is not canceled when the Pipeline fails (or is canceled) so that it may
+// still be used to release resources. An error returned by fn is joined
+// with any other error returned by the stage.
+func WithTeardown(fn func(ctx context.Context) error) StageOption {
+	return func(s *stage) {
+		s.teardown = fn
+	}
+}
+
+// lifecycle wraps run with calls to the setup and teardown functions of the
+// receiver and of any stages fused into it (see WithSetup and WithTeardown)
+// and emits their LifecycleEvents (see OnLifecycle).
+func (s *stage) lifecycle(run errgroupx.ContextFunc) errgroupx.ContextFunc {
+	stages := append([]*stage{s}, s.fused...)
+
+	teardown := func(ctx context.Context, stages []*stage) error {
+		ctx = context.WithoutCancel(ctx)
+
+		var errs []error
+		for i := len(stages) - 1; i >= 0; i-- {
+			if t := stages[i]; t.teardown != nil {
+				if err := t.teardown(ctx); err != nil {
+					errs = append(errs, fmt.Errorf("stage %q: teardown: %w", t.name, err))
+				}
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+
+	return func(ctx context.Context) (err error) {
+		defer func() {
+			et := StageDrained
+			switch {
+			case err == nil:
+			case ctx.Err() != nil:
+				et = StageCanceled
+			default:
+				et = StageFailed
+			}
+
+			for _, t := range stages {
+				s.events.stageEvent(et, t, err)
+			}
+		}()
+
+		for i, t := range stages {
+			if t.setup != nil {
+				if err := t.setup(ctx); err != nil {
+					return errors.Join(fmt.Errorf("stage %q: setup: %w", t.name, err), teardown(ctx, stages[:i]))
+				}
+			}
+
+			s.events.stageEvent(StageStarted, t, nil)
+		}
+
+		defer func() {
+			err = errors.Join(err, teardown(ctx, stages))
+		}()
+
+		return run(ctx)
+	}
+}
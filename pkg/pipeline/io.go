@@ -0,0 +1,70 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+)
+
+// FromReader returns a FeedFunc that reads from r, splitting its content into
+// tokens using split (or bufio.ScanLines if split is nil), and sends each
+// token into the Pipeline as a string. Any error encountered while reading
+// from r is returned (see bufio.Scanner for details).
+func FromReader(r io.Reader, split bufio.SplitFunc) FeedFunc {
+	if split == nil {
+		split = bufio.ScanLines
+	}
+
+	return func(ctx context.Context, ch chan<- any) error {
+		s := bufio.NewScanner(r)
+		s.Split(split)
+
+		for s.Scan() {
+			if err := Send(ctx, s.Text(), ch); err != nil {
+				return err
+			}
+		}
+
+		return s.Err()
+	}
+}
+
+// ToWriter returns a CollectFunc that writes each data element emerging
+// from the Pipeline to w followed by a newline. Elements of type string or
+// []byte are written as-is while all others are formatted using fmt.Fprint.
+// Output is buffered and flushed before the CollectFunc returns; any error
+// encountered while writing is returned.
+func ToWriter(w io.Writer) CollectFunc {
+	return func(ctx context.Context, ch <-chan any) error {
+		bw := bufio.NewWriter(w)
+
+		for {
+			v, ok, err := Recv[any](ctx, ch)
+			if err != nil {
+				return err
+			} else if !ok {
+				return bw.Flush()
+			}
+
+			switch v := v.(type) {
+			case string:
+				_, err = bw.WriteString(v)
+			case []byte:
+				_, err = bw.Write(v)
+			default:
+				_, err = fmt.Fprint(bw, v)
+			}
+
+			if err == nil {
+				err = bw.WriteByte('\n')
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
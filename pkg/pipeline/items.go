@@ -0,0 +1,365 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// Metadata are arbitrary key/value pairs (e.g. a trace ID or tenant name)
+// that travel along with a single item as it passes through a Pipeline.
+type Metadata map[string]string
+
+// clone returns a copy of the receiver (or nil if it is empty).
+func (md Metadata) clone() Metadata {
+	if len(md) == 0 {
+		return nil
+	}
+
+	c := make(Metadata, len(md))
+	for k, v := range md {
+		c[k] = v
+	}
+
+	return c
+}
+
+// ItemInfo describes the item being processed by a StageFunc; see ItemFrom.
+type ItemInfo struct {
+	Enqueued time.Time // When the item was received from Feed
+	Deadline time.Time // When the item expires (see WithItemDeadline)
+	Priority int       // The item's priority (see WithPriority)
+	Attempt  int       // Current attempt by this stage (see WithRetry)
+	Worker   uint64    // ID of the stage's waypoint.Worker (see below)
+	Metadata Metadata  // A copy of the item's Metadata
+}
+
+// ItemFrom returns an ItemInfo describing the item being processed using
+// ctx, which should be the Context passed to a StageFunc. Enqueued, Deadline,
+// Priority and Metadata are only available for Pipelines created using NewAck
+// or the WithMetadata (or WithItemDeadline or WithPriority) Option;
+// otherwise, they are zero.
+// Attempt is always at least one. Worker is zero for stages that have no
+// Waypoint of their own (i.e. stages added by AddBatch and AddReduce); a
+// stage fused into another (see WithInline) reports the Worker of the stage
+// into which it is fused.
+func ItemFrom(ctx context.Context) ItemInfo {
+	info := ItemInfo{Attempt: 1, Worker: workerFrom(ctx)}
+
+	if n, ok := ctx.Value(attemptKey{}).(int); ok {
+		info.Attempt = n
+	}
+
+	if is, ok := ctx.Value(itemKey{}).(*itemState); ok {
+		is.Lock()
+		defer is.Unlock()
+
+		info.Enqueued = is.enqueued
+		info.Deadline = is.deadline
+		info.Priority = is.priority
+		info.Metadata = is.md.clone()
+	}
+
+	return info
+}
+
+// SetMetadata sets key to value in the Metadata of the item being processed
+// using ctx (see ItemFrom), making it visible to all subsequent stages. It
+// does nothing if the item carries no Metadata.
+//
+// Note that the copies of an item sent to each of the stages of a Branch
+// share the same Metadata.
+func SetMetadata(ctx context.Context, key, value string) {
+	is, ok := ctx.Value(itemKey{}).(*itemState)
+	if !ok {
+		return
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	if is.md == nil {
+		is.md = make(Metadata)
+	}
+
+	is.md[key] = value
+}
+
+// WithMetadata returns an Option that causes each item received from Feed
+// to carry Metadata (and the time it was received) through the Pipeline,
+// where it is available to each StageFunc using ItemFrom. If fn is not nil,
+// it is called with each item to provide its initial Metadata.
+//
+// Items in a Pipeline created using NewAck always carry Metadata (see
+// AckItem), so this Option is needed only to provide fn. Otherwise, note
+// that each item passes through an additional goroutine on its way to (and
+// from) the Pipeline's stages. The same is true of the other Options that
+// track each item: WithMaxInFlight, WithItemDeadline, WithPriority,
+// WithDebugSampling, WithCheckpoints and WithTracer.
+func WithMetadata(fn func(v any) Metadata) Option {
+	return func(p *Pipeline) {
+		p.metadata = true
+		p.metaFunc = fn
+	}
+}
+
+type (
+	itemKey    struct{}
+	attemptKey struct{}
+	workerKey  struct{}
+)
+
+// itemState holds the mutable, per-item state carried by an envelope.
+type itemState struct {
+	enqueued time.Time
+
+	// n.b. These are not modified once the item enters a stage and so
+	//      may be read without holding the lock.
+	deadline time.Time // see WithItemDeadline
+	priority int       // see WithPriority
+	sampled  bool      // see WithDebugSampling
+
+	md Metadata
+	mutex
+}
+
+// newItemState returns the itemState for item, received at time now.
+func newItemState(item AckItem, now time.Time) *itemState {
+	return &itemState{
+		enqueued: now,
+		deadline: item.Deadline,
+		priority: item.Priority,
+		md:       item.Metadata.clone(),
+	}
+}
+
+// merge adds md to the receiver's Metadata, replacing any existing values.
+func (is *itemState) merge(md Metadata) {
+	if len(md) == 0 {
+		return
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	if is.md == nil {
+		is.md = make(Metadata, len(md))
+	}
+
+	for k, v := range md {
+		is.md[k] = v
+	}
+}
+
+// context returns the Context used to process the receiver's Value; it has
+// the deadline and cancelation of ctx but also carries the values of the
+// receiver's Context (which take precedence) and its itemState.
+func (m *envelope) context(ctx context.Context) context.Context {
+	if m.pool != nil {
+		m.sctx = stateCtx{ctx, m.state, m.Context, m.worker}
+		m.worker = nil
+		return &m.sctx
+	}
+
+	if m.Context != nil {
+		ctx = &itemCtx{ctx, m.Context}
+	}
+
+	if m.state != nil {
+		ctx = context.WithValue(ctx, itemKey{}, m.state)
+	}
+
+	return ctx
+}
+
+type itemCtx struct {
+	context.Context
+	values context.Context
+}
+
+func (c *itemCtx) Value(key any) any {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+
+	return c.Context.Value(key)
+}
+
+// withAttempt returns a Context for calling a StageFunc for the given
+// attempt under RetryPolicy rp (see ItemFrom).
+func withAttempt(ctx context.Context, rp RetryPolicy, attempt int) context.Context {
+	if rp.MaxAttempts <= 1 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// withWorker returns a Context for processing item in using Worker w (see
+// ItemFrom). The Context carries w itself, rather than its ID, since storing
+// a pointer in an interface value requires no allocation. If in is a pooled
+// envelope, ctx is returned as is and w is instead carried by the Context
+// returned by its context method (see WithPooling).
+func withWorker(ctx context.Context, w *waypoint.Worker, in any) context.Context {
+	if m, ok := in.(*envelope); ok && m.pool != nil {
+		m.worker = w
+		return ctx
+	}
+
+	return context.WithValue(ctx, workerKey{}, w)
+}
+
+// workerFrom returns the ID of the Worker carried by ctx (or zero).
+func workerFrom(ctx context.Context) uint64 {
+	if w, ok := ctx.Value(workerKey{}).(*waypoint.Worker); ok {
+		return w.ID
+	}
+	return 0
+}
+
+// wrapsItems returns true if items received from Feed must be wrapped in an
+// envelope before being sent to the receiver's stages (see WithMetadata,
+// WithItemDeadline, WithPriority, WithDebugSampling, WithTracer,
+// WithMaxInFlight and WithCheckpoints).
+func (p *Pipeline) wrapsItems() bool {
+	return p.metadata || p.deadlines != nil || p.prioritize || p.debug != nil || p.tracer != nil || p.maxInFlight > 0 || p.checkpoints != nil
+}
+
+// wrapItems returns an errgroupx.ContextFunc that wraps each value received
+// from in (unless it already is) within an envelope carrying its itemState
+// (and item Span; see WithTracer) and sends it to out. If the receiver has
+// a limit on in-flight items (see WithMaxInFlight), each item holds a slot
+// until it is Ack'ed or Nack'ed. If wm is not nil, it is loaded from the
+// receiver's Checkpointer, items that were already processed are discarded
+// and each remaining item updates wm once it is Ack'ed.
+func (p *Pipeline) wrapItems(in <-chan any, out chan<- any, wm *watermark) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer close(out)
+
+		var slots chan struct{}
+		if p.maxInFlight > 0 {
+			slots = make(chan struct{}, p.maxInFlight)
+		}
+
+		var skip, offset uint64
+		if wm != nil {
+			n, err := p.checkpoints.c.Load(ctx)
+			if err != nil {
+				return err
+			}
+
+			wm.load(n)
+			skip = n
+		}
+
+		for ; ; offset++ {
+			v, ok, err := Recv[any](ctx, in)
+			if err != nil || !ok {
+				return err
+			}
+
+			if offset < skip {
+				ackSkipped(v)
+				continue
+			}
+
+			if slots != nil {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case slots <- struct{}{}:
+				}
+			}
+
+			m, ok := v.(*envelope)
+			if !ok {
+				m = p.envelopes.get(AckItem{Value: v}, p.clock.Now())
+			}
+
+			if m.state == nil {
+				m.state = newItemState(m.AckItem, p.clock.Now())
+			}
+
+			if p.metaFunc != nil {
+				m.state.merge(p.metaFunc(m.Value))
+			}
+
+			if p.deadlines != nil {
+				if d := p.deadlines(m.Value); !d.IsZero() {
+					m.state.deadline = d
+				}
+			}
+
+			if p.prioFunc != nil {
+				m.state.priority = p.prioFunc(m.Value)
+			}
+
+			m.state.sampled = p.debug.sampled(m.Value)
+
+			if p.tracer != nil {
+				pctx := ctx
+				if m.Context != nil {
+					pctx = m.Context
+				}
+
+				ictx, span := p.tracer.Start(pctx, ItemSpanName)
+				m.AckItem = traceAcks(m.AckItem, ictx, span)
+			}
+
+			if slots != nil {
+				m.AckItem = onAck(m.AckItem, func(error) { <-slots })
+			}
+
+			if wm != nil {
+				offset := offset
+				m.AckItem = onAck(m.AckItem, func(err error) {
+					if err == nil {
+						wm.complete(offset)
+					}
+				})
+			}
+
+			if err := Send(ctx, m, out); err != nil {
+				m.nack(err)
+				return err
+			}
+		}
+	}
+}
+
+// unwrapItems returns an errgroupx.ContextFunc that removes the envelope
+// added by wrapItems from each value received from in, sends the value to
+// out and then Acks the envelope (thereby ending its item Span) before
+// returning it to pool (see WithPooling).
+func unwrapItems(in <-chan any, out chan<- any, pool *envelopePool) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer close(out)
+
+		for {
+			v, ok, err := Recv[any](ctx, in)
+			if err != nil || !ok {
+				return err
+			}
+
+			m, ok := v.(*envelope)
+			if !ok {
+				if err := Send(ctx, v, out); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := Send(ctx, m.Value, out); err != nil {
+				m.nack(err)
+				return err
+			}
+
+			m.ack()
+			pool.put(m)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// FeedSeq returns a function, suitable for use as the Feed method of an
+// Interface implementation, that sends each value from seq into the
+// Pipeline.
+func FeedSeq[T any](seq iter.Seq[T]) FeedFunc {
+	return func(ctx context.Context, ch chan<- any) error {
+		for v := range seq {
+			if err := Send(ctx, v, ch); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Iterate returns an iter.Seq2 which, when iterated, runs a new Pipeline
+// whose data source is the sequence in and yields each data element that
+// emerges from its final stage (asserted to be of type Out). The setup
+// function is called with the new Pipeline (created using the provided
+// Options) so that it may register the Pipeline's stages.
+//
+// Any error returned by setup or Run (or encountered while asserting the
+// type of an emerging data element) is yielded last, along with a zero Out
+// value. If the consumer stops iterating early, the Pipeline is canceled
+// and no error is yielded.
+func Iterate[In, Out any](ctx context.Context, in iter.Seq[In], setup func(*Pipeline) error, opts ...Option) iter.Seq2[Out, error] {
+	return func(yield func(Out, error) bool) {
+		var zero Out
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		impl := &seqImpl{feed: FeedSeq(in), out: make(chan any)}
+		p := New(impl, opts...)
+
+		if err := setup(p); err != nil {
+			yield(zero, err)
+			return
+		}
+
+		errc := make(chan error, 1)
+		go func() {
+			errc <- p.Run(ctx)
+		}()
+
+		var (
+			stopped bool
+			err     error
+		)
+
+		// n.b. We must drain impl.out until it's closed (even after we've
+		//      stopped yielding) so that Collect is never blocked.
+		for v := range impl.out {
+			if stopped {
+				continue
+			}
+
+			o, ok := as[Out](v)
+			if !ok {
+				err = fmt.Errorf("pipeline: unexpected result type %T", v)
+				stopped = true
+				cancel()
+				continue
+			}
+
+			if !yield(o, nil) {
+				stopped = true
+				cancel()
+			}
+		}
+
+		if rerr := <-errc; err == nil && !stopped {
+			err = rerr
+		}
+
+		if err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+// seqImpl is the Interface used by Iterate.
+type seqImpl struct {
+	feed FeedFunc
+	out  chan any
+}
+
+func (si *seqImpl) Feed(ctx context.Context, ch chan<- any) error {
+	return si.feed(ctx, ch)
+}
+
+func (si *seqImpl) Collect(ctx context.Context, ch <-chan any) error {
+	defer close(si.out)
+
+	for {
+		v, ok, err := Recv[any](ctx, ch)
+		if err != nil || !ok {
+			return err
+		}
+
+		si.out <- v
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// WithKey returns a StageOption that causes items sharing the same key, as
+// returned by fn, to be processed by the stage sequentially and in the order
+// they were received (and to be sent on to the next stage in that order).
+// Items with different keys are still processed concurrently, subject to the
+// stage's capacity. The keys returned by fn must be comparable.
+//
+// Note that an item waiting for an earlier item with the same key still
+// occupies one of the stage's capacity slots.
+func WithKey(fn func(input any) any) StageOption {
+	return func(s *stage) {
+		s.key = fn
+	}
+}
+
+// keyOf returns the receiver's key for input value in.
+func (s *stage) keyOf(in any) any {
+	if m, ok := in.(*envelope); ok {
+		in = m.Value
+	}
+
+	return s.key(in)
+}
+
+// keyedLanes tracks, for each key with an item currently being processed,
+// the queue of items with that key waiting their turn. Each queued item
+// holds the Worker that was acquired for it.
+type keyedLanes struct {
+	handle func(context.Context, any) error
+	queues map[any][]keyedItem
+	mutex
+}
+
+type keyedItem struct {
+	in any
+	w  *waypoint.Worker
+}
+
+func newKeyedLanes(handle func(context.Context, any) error) *keyedLanes {
+	return &keyedLanes{
+		handle: handle,
+		queues: make(map[any][]keyedItem),
+	}
+}
+
+// submit queues in (along with its Worker) behind any other items with the
+// same key or, if there are none, starts a new goroutine in eg to process
+// in along with any items that are queued behind it.
+func (kl *keyedLanes) submit(ctx context.Context, eg *errgroupx.Group, key, in any, w *waypoint.Worker) {
+	kl.Lock()
+	defer kl.Unlock()
+
+	if q, ok := kl.queues[key]; ok {
+		kl.queues[key] = append(q, keyedItem{in, w})
+		return
+	}
+
+	kl.queues[key] = nil
+
+	eg.Go(func() error {
+		return kl.run(ctx, key, keyedItem{in, w})
+	})
+}
+
+// run processes item followed by each item queued with the same key until
+// that queue is empty. If an item fails, the Workers for all remaining
+// queued items are released.
+func (kl *keyedLanes) run(ctx context.Context, key any, item keyedItem) error {
+	for {
+		var err error
+		item.w.Do(ctx, func(ctx context.Context) {
+			err = kl.handle(withWorker(ctx, item.w, item.in), item.in)
+		})
+		item.w.Done()
+
+		kl.Lock()
+		q := kl.queues[key]
+
+		if err != nil || len(q) == 0 {
+			delete(kl.queues, key)
+			kl.Unlock()
+
+			for _, qi := range q {
+				qi.w.Done()
+			}
+
+			return err
+		}
+
+		item, kl.queues[key] = q[0], q[1:]
+		kl.Unlock()
+	}
+}
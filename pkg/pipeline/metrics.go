@@ -0,0 +1,135 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"time"
+
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// Metrics represents point-in-time metrics for a Pipeline.
+type Metrics struct {
+	Name      string         // The Pipeline's name (see WithName)
+	Timestamp time.Time      // Time these metrics were gathered
+	Stages    []StageMetrics // Per-stage metrics, in registration order
+
+	// Bottleneck is the name of the stage whose workers have spent the
+	// greatest share of their time processing items (i.e. its total
+	// ProcessingTime divided by its capacity). Stages preceding it will
+	// typically show growing SendBlocked values.
+	Bottleneck string
+}
+
+// StageMetrics represents point-in-time metrics for a single Pipeline stage.
+type StageMetrics struct {
+	Name     string           // The stage's registered name
+	Waypoint waypoint.Metrics // Metrics for the stage's Waypoint
+	Threads  *ThreadMetrics   // Thread pool metrics (nil if not in use)
+
+	In      uint64 // Items passed to the stage's StageFunc
+	Out     uint64 // Items successfully returned by the StageFunc
+	Errors  uint64 // Items for which the StageFunc returned an error
+	Skipped uint64 // Items dropped by the StageFunc or its ErrorPolicy
+	Expired uint64 // Items dropped since their deadline had passed
+
+	// ProcessingTime is the total time spent in the stage's StageFunc
+	// (including retries) and Latency is the average time per item.
+	ProcessingTime time.Duration
+	Latency        time.Duration
+
+	// SendBlocked is the total time the stage has spent sending its output
+	// downstream; a large value indicates backpressure from the stages that
+	// follow it (see OnBackpressure). Queued and QueueCap are the current
+	// length and capacity of the stage's input channel and QueuePeak is the
+	// greatest length observed as the stage received items from it. The
+	// latter three are zero for a stage fused into the stage preceding it.
+	SendBlocked time.Duration
+	Queued      int
+	QueueCap    int
+	QueuePeak   int
+
+	// Spilled is the number of items written to disk by the stage's
+	// spill buffer (see WithSpill).
+	Spilled uint64
+}
+
+// Metrics returns a point-in-time Metrics value for the receiver. Note that
+// the returned metrics will be mostly empty until its Run method has been
+// called. Since a stage that is fused into the stage preceding it (see
+// WithInline) has no Waypoint of its own, its Waypoint metrics are empty.
+func (p *Pipeline) Metrics() Metrics {
+	if p == nil {
+		return Metrics{}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	m := Metrics{
+		Name:      p.name,
+		Timestamp: p.clock.Now(),
+		Stages:    make([]StageMetrics, len(p.stages)),
+	}
+
+	for i := range p.stages {
+		m.Stages[i] = p.stages[i].metrics()
+	}
+
+	m.Bottleneck = bottleneck(m.Stages)
+
+	return m
+}
+
+// StageMetrics returns a point-in-time StageMetrics value for the stage
+// registered with the given name. If name is not a registered stage name
+// then ErrNameUnknown is returned. Note that the returned metrics will be
+// mostly empty until the receiver's Run method has been called.
+func (p *Pipeline) StageMetrics(name string) (StageMetrics, error) {
+	if p == nil {
+		return StageMetrics{}, ErrNilReceiver
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	ndx, ok := p.byname[name]
+	if !ok {
+		return StageMetrics{}, ErrNameUnknown
+	}
+
+	if ndx < 0 || ndx >= len(p.stages) {
+		return StageMetrics{}, ErrCorrupted
+	}
+
+	return p.stages[ndx].metrics(), nil
+}
+
+func (s *stage) metrics() StageMetrics {
+	sm := StageMetrics{
+		Name:     s.name,
+		Waypoint: s.waypt.Metrics(),
+		Threads:  s.pool.metrics(),
+
+		In:      s.stats.in.Load(),
+		Out:     s.stats.out.Load(),
+		Errors:  s.stats.errs.Load(),
+		Skipped: s.stats.skipped.Load(),
+		Expired: s.stats.expired.Load(),
+
+		ProcessingTime: time.Duration(s.stats.busy.Load()),
+		SendBlocked:    time.Duration(s.stats.blocked.Load()),
+		QueuePeak:      int(s.stats.peak.Load()),
+		Spilled:        s.stats.spilled.Load(),
+	}
+
+	if s.input != nil && s.waypt != nil {
+		sm.Queued, sm.QueueCap = len(s.input), cap(s.input)
+	}
+
+	if n := s.stats.done.Load(); n > 0 {
+		sm.Latency = sm.ProcessingTime / time.Duration(n)
+	}
+
+	return sm
+}
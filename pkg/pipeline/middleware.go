@@ -0,0 +1,56 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+// A Middleware wraps a StageFunc with additional behavior (e.g. logging,
+// metrics or authorization) and returns the resulting StageFunc, which
+// should usually call next. Middleware is applied once, when the Pipeline is
+// run (or a stage's StageFunc is replaced; see Replace), rather than for
+// each item.
+type Middleware func(next StageFunc) StageFunc
+
+// WithMiddleware returns an Option that wraps the StageFunc of each of the
+// Pipeline's stages with the given Middleware. The first Middleware is the
+// outermost and all are outside of any stage-specific Middleware (see
+// WithStageMiddleware). Stages without a StageFunc (see AddBatch and
+// AddReduce) are unaffected and, for stages added using AddFlatMap, the
+// output returned by next is an opaque value that should be returned as-is.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(p *Pipeline) {
+		p.middleware = append(p.middleware, mw...)
+	}
+}
+
+// WithStageMiddleware returns a StageOption that wraps the stage's StageFunc
+// with the given Middleware, the first of which is the outermost. Note that
+// each call to the StageFunc, including retries (see WithRetry) and timeouts
+// (see WithTimeout), passes through the Middleware.
+func WithStageMiddleware(mw ...Middleware) StageOption {
+	return func(s *stage) {
+		s.middleware = append(s.middleware, mw...)
+	}
+}
+
+// activate sets the StageFunc called by the receiver to its current
+// StageFunc (see Replace) wrapped with its Middleware and then with that of
+// its Pipeline (which must be locked).
+func (s *stage) activate(pmw []Middleware) {
+	f := s.sfunc
+	if sf := s.swapped.Load(); sf != nil {
+		f = *sf
+	}
+
+	if f == nil {
+		return
+	}
+
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		f = s.middleware[i](f)
+	}
+
+	for i := len(pmw) - 1; i >= 0; i-- {
+		f = pmw[i](f)
+	}
+
+	s.active.Store(&f)
+}
@@ -0,0 +1,145 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"time"
+
+	"github.com/go-sage/synctools/internal/options"
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// An Option alters the default behavior of a Pipeline. Options are passed
+// to New and are applied in the order provided.
+type Option = options.Option[Pipeline]
+
+// A StageOption alters the default behavior of a single Pipeline stage.
+// StageOptions are passed to Add and are applied in the order provided.
+type StageOption = options.Option[stage]
+
+// WithName returns an Option that sets the Pipeline's name. A Pipeline's
+// name is purely informational.
+func WithName(name string) Option {
+	return func(p *Pipeline) {
+		p.name = name
+	}
+}
+
+// Name returns the name assigned to the receiver using WithName.
+func (p *Pipeline) Name() string {
+	if p == nil {
+		return ""
+	}
+
+	return p.name
+}
+
+// WithBuffer returns an Option that sets the default buffer size for the
+// channels that carry items between the Pipeline's Feed method, its stages
+// and its Collect method. By default, these channels are unbuffered, which
+// causes adjacent stages to operate in lockstep; buffering allows a fast
+// stage to run ahead of a slower one that follows it. See also
+// WithStageBuffer.
+func WithBuffer(n int) Option {
+	return func(p *Pipeline) {
+		p.buffer = max(n, 0)
+	}
+}
+
+// WithStageBuffer returns a StageOption that sets the buffer size for the
+// channels from which the stage receives its input items (i.e. for each of
+// its inbound edges; see Connect), overriding the Pipeline's default (see
+// WithBuffer). It has no effect on an inline stage that is fused into the
+// stage preceding it.
+func WithStageBuffer(n int) StageOption {
+	return func(s *stage) {
+		s.buffer = max(n, 0)
+	}
+}
+
+// WithMaxInFlight returns an Option that limits the total number of items
+// in flight -- i.e. those received from Feed that have not yet reached
+// Collect (or been dropped or failed) -- across all of the Pipeline's stages
+// to n, which bounds the memory they use regardless of stage capacities and
+// channel buffers (see WithBuffer). Feed is blocked while the limit is
+// reached. A value for n less than 1 means no limit.
+//
+// Since items held by a stage added using AddBatch or AddReduce remain in
+// flight until their batch (or the reduction) is complete, n must be larger
+// than the number of items such a stage may hold or the Pipeline will
+// deadlock. See also WithMetadata for the cost of tracking each item.
+func WithMaxInFlight(n int) Option {
+	return func(p *Pipeline) {
+		p.maxInFlight = max(n, 0)
+	}
+}
+
+// FeedErrorPolicy determines how a Pipeline reacts when its Feed method
+// returns a non-nil error.
+type FeedErrorPolicy int
+
+const (
+	// AbortOnFeedError causes a Feed error to immediately cancel all other
+	// goroutines in the Pipeline; any items still in-flight are discarded.
+	// This is the default policy.
+	AbortOnFeedError FeedErrorPolicy = iota
+
+	// DrainOnFeedError allows items already sent by Feed to continue
+	// through all remaining stages (and on to Collect) before Run returns
+	// the Feed error. This is useful for sinks that must not lose items
+	// that have already been accepted.
+	DrainOnFeedError
+)
+
+// WithFeedErrorPolicy returns an Option that sets the Pipeline's policy
+// for handling errors returned by Feed.
+func WithFeedErrorPolicy(fep FeedErrorPolicy) Option {
+	return func(p *Pipeline) {
+		p.feedPolicy = fep
+	}
+}
+
+// WithInline returns a StageOption declaring that the stage's StageFunc is
+// lightweight (e.g. identity-like, a simple field transform, or a pure
+// filter) such that it does not warrant its own goroutines. An inline stage
+// is fused into the stage preceding it; it is executed synchronously, by
+// that stage's workers, immediately after their own StageFunc returns. This
+// avoids a channel hop and a Waypoint transition for each item.
+//
+// Since a fused stage has no Waypoint of its own, its capacity is ignored
+// and it cannot be resized. An inline stage is run as a normal stage if it
+// is registered first, if it follows a batch or reduce stage, if it is
+// itself a batch or reduce stage or if it also uses WithLockedThreads,
+// WithKey, WithRateLimit, WithHistograms, WithOrdered, WithAutoScale or
+// WithSpill.
+func WithInline() StageOption {
+	return func(s *stage) {
+		s.inline = true
+	}
+}
+
+// WithTimeout returns a StageOption that limits each call to the stage's
+// StageFunc to duration d (including any time spent waiting for a thread;
+// see WithLockedThreads). The StageFunc's Context is canceled once d has
+// elapsed and, if it then returns an error, that error is wrapped with
+// ErrTimeout and is subject to the stage's RetryPolicy and ErrorPolicy.
+// Note that d applies to each attempt separately.
+//
+// Since a StageFunc cannot be interrupted, it must honor its Context in
+// order for the timeout to be effective.
+func WithTimeout(d time.Duration) StageOption {
+	return func(s *stage) {
+		s.timeout = d
+	}
+}
+
+// WithRateLimit returns a StageOption that limits the rate at which the
+// stage begins processing items to perSecond items per second, with bursts
+// of up to burst items, in addition to its concurrency limit (i.e. its
+// capacity). See waypoint.WithRateLimit for details. Note that retries
+// (see WithRetry) are not subject to the rate limit.
+func WithRateLimit(perSecond float64, burst int) StageOption {
+	return func(s *stage) {
+		s.wpopts = append(s.wpopts, waypoint.WithRateLimit(perSecond, burst))
+	}
+}
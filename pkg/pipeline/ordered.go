@@ -0,0 +1,96 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// WithOrdered returns a StageOption that causes the stage to send its output
+// items on to the next stage in the same order it received the corresponding
+// input items, even though they are still processed concurrently. An item
+// that finishes early is held until all of the items received before it have
+// been sent; the number of items held (or being processed) is limited to the
+// stage's initial capacity.
+//
+// WithOrdered has no effect on a stage using WithKey, nor on one without a
+// StageFunc (e.g. AddBatch), since those already preserve order.
+func WithOrdered() StageOption {
+	return func(s *stage) {
+		s.ordered = true
+	}
+}
+
+// An orderedQueue holds, in the order they were received, the items being
+// processed by a stage using WithOrdered.
+type orderedQueue struct {
+	items chan *orderedItem
+}
+
+type orderedItem struct {
+	in   any
+	out  any
+	err  error
+	done chan struct{}
+}
+
+func newOrderedQueue(size int) *orderedQueue {
+	return &orderedQueue{items: make(chan *orderedItem, max(size, 1))}
+}
+
+// submit queues in and then processes it (using stage s) in a new goroutine
+// in eg, releasing w once it is done. Its result is sent by the emitter.
+func (oq *orderedQueue) submit(ctx context.Context, eg *errgroupx.Group, s *stage, in any, w *waypoint.Worker) error {
+	oi := &orderedItem{in: in, done: make(chan struct{})}
+
+	select {
+	case <-ctx.Done():
+		w.Done()
+		return ctx.Err()
+	case oq.items <- oi:
+	}
+
+	eg.Go(func() error {
+		defer w.Done()
+		defer close(oi.done)
+
+		w.Do(ctx, func(ctx context.Context) {
+			oi.out, oi.err = s.process(withWorker(ctx, w, in), in)
+		})
+
+		return nil
+	})
+
+	return nil
+}
+
+// close indicates no more items will be submitted. It is a no-op for a nil
+// receiver.
+func (oq *orderedQueue) close() {
+	if oq != nil {
+		close(oq.items)
+	}
+}
+
+// emitter returns an errgroupx.ContextFunc that waits for each queued item,
+// in order, and sends its result to outch (see emit).
+func (oq *orderedQueue) emitter(s *stage, outch chan<- any) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		for oi := range oq.items {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-oi.done:
+			}
+
+			if err := s.emit(ctx, oi.in, oi.out, oi.err, outch); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
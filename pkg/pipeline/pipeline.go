@@ -9,17 +9,50 @@ package pipeline
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/go-sage/synctools/internal/options"
 	"github.com/go-sage/synctools/pkg/errgroupx"
+	"github.com/go-sage/synctools/pkg/waypoint"
 )
 
 type (
 	Pipeline struct {
+		name    string
 		impl    Interface
 		stages  []stage
 		funcs   []errgroupx.ContextFunc
 		byname  map[string]int
+		groups  int
+		edges   []edge
 		started bool
+		feedErr error
+		stop    context.CancelCauseFunc // see Stop
+		done    chan struct{}           // closed when Run returns
+
+		buffer      int
+		feedPolicy  FeedErrorPolicy
+		deadLetter  func(context.Context, *StageError) error
+		tracer      Tracer
+		metadata    bool
+		metaFunc    func(any) Metadata
+		prioritize  bool
+		prioFunc    func(any) int
+		debug       *debugSampler
+		envelopes   *envelopePool
+		onEvent     func(LifecycleEvent)
+		events      *eventStream // for the current Run
+		deadlines   func(any) time.Time
+		store       StateStore
+		exportEvery time.Duration
+		progress    *progress
+		middleware  []Middleware
+		report      *errorCollector
+		maxInFlight int
+		blocking    *backpressure
+		clock       waypoint.Clock
+		checkpoints *checkpoints
 
 		mutex
 	}
@@ -44,16 +77,50 @@ type Interface interface {
 	Collect(ctx context.Context, rchan <-chan any) error
 }
 
-// New creates and returns a new Pipeline using the provided Interface.
-func New(impl Interface) *Pipeline {
-	return &Pipeline{
+// New creates and returns a new Pipeline using the provided Interface. Any
+// provided Options are applied, in order, before New returns.
+func New(impl Interface, opts ...Option) *Pipeline {
+	p := &Pipeline{
 		impl:   impl,
 		byname: make(map[string]int),
+		clock:  waypoint.RealClock{},
 	}
+
+	return options.Apply(p, opts)
+}
+
+// FeedFunc and CollectFunc are function types matching the Feed and Collect
+// methods of Interface; see NewFromFuncs.
+type (
+	FeedFunc    func(ctx context.Context, wchan chan<- any) error
+	CollectFunc func(ctx context.Context, rchan <-chan any) error
+)
+
+// NewFromFuncs is similar to New but uses the provided functions, rather
+// than an Interface implementation, as the Pipeline's data source and sink.
+// This avoids the need to define a type for small, ad-hoc Pipelines.
+func NewFromFuncs(feed FeedFunc, collect CollectFunc, opts ...Option) *Pipeline {
+	return New(funcImpl{feed, collect}, opts...)
+}
+
+// funcImpl is the Interface used by NewFromFuncs.
+type funcImpl struct {
+	feed    FeedFunc
+	collect CollectFunc
+}
+
+func (fi funcImpl) Feed(ctx context.Context, ch chan<- any) error {
+	return fi.feed(ctx, ch)
+}
+
+func (fi funcImpl) Collect(ctx context.Context, ch <-chan any) error {
+	return fi.collect(ctx, ch)
 }
 
 // A StageFunc is the function called to process each piece of data
-// for a stage registered using the (*Pipeline).Add method.
+// for a stage registered using the (*Pipeline).Add method. A StageFunc may
+// return ErrDrop (or an error wrapping it) to drop its input without any
+// output; this is not considered an error (see also AddFilter).
 type StageFunc func(ctx context.Context, input any) (any, error)
 
 // Add registers a named Pipeline stage that will execute the provided
@@ -72,7 +139,9 @@ type StageFunc func(ctx context.Context, input any) (any, error)
 // The name parameter may be used with the Resize method in order to alter
 // the capacity of this particular stage. For more details, see this
 // module's [waypoint] package.
-func (p *Pipeline) Add(name string, capacity int, pfunc StageFunc) error {
+//
+// Any provided StageOptions are applied to the new stage, in order.
+func (p *Pipeline) Add(name string, capacity int, pfunc StageFunc, opts ...StageOption) error {
 	if p == nil {
 		return ErrNilReceiver
 	}
@@ -88,22 +157,58 @@ func (p *Pipeline) Add(name string, capacity int, pfunc StageFunc) error {
 		return ErrNameConflict
 	}
 
-	idx := len(p.stages)
-	p.stages = append(p.stages, stage{
-		name:     name,
-		capacity: capacity,
-		sfunc:    pfunc,
-	})
-
-	p.byname[name] = idx
+	p._register(stageDef{name, capacity, pfunc, opts}, 0, 0)
 
 	return nil
 }
 
+// AddFilter registers a named Pipeline stage, as described for Add, that
+// passes each of its input items through unchanged if keep returns true and
+// drops it otherwise. For a Pipeline created by NewAck, dropped items are
+// Ack'ed. An error returned by keep is handled like any StageFunc error.
+func (p *Pipeline) AddFilter(name string, capacity int, keep func(ctx context.Context, input any) (bool, error), opts ...StageOption) error {
+	return p.Add(name, capacity, func(ctx context.Context, in any) (any, error) {
+		switch ok, err := keep(ctx, in); {
+		case err != nil:
+			return nil, err
+		case !ok:
+			return nil, ErrDrop
+		}
+
+		return in, nil
+	}, opts...)
+}
+
+// _register appends a new stage, as defined by sd, to the receiver's list
+// of stages as a member of the given branch group and lane (see AddBranch).
+func (p *Pipeline) _register(sd stageDef, group, lane int) {
+	s := options.Apply(&stage{
+		name:     sd.name,
+		capacity: sd.capacity,
+		sfunc:    sd.sfunc,
+		opts:     sd.opts,
+		stats:    new(stageStats),
+		swapped:  new(atomic.Pointer[StageFunc]),
+		active:   new(atomic.Pointer[StageFunc]),
+		buffer:   -1,
+		group:    group,
+		lane:     lane,
+	}, sd.opts)
+
+	idx := len(p.stages)
+	p.stages = append(p.stages, *s)
+
+	p.byname[sd.name] = idx
+}
+
 // Resize updates the capacity of the pipeline stage with the given name to the
 // provided newcap value and returns that stage's previous capacity value.  If
 // name is not a registered stage name then zero and ErrNameUnknown will be
-// returned.
+// returned. Errors from the stage's underlying Waypoint (e.g.
+// waypoint.ErrInvalidCapacity) are returned as-is.
+//
+// If the receiver has not yet been started, the capacity with which the
+// stage will be started is updated instead.
 func (p *Pipeline) Resize(name string, newcap int) (int, error) {
 	if p == nil {
 		return 0, ErrNilReceiver
@@ -121,8 +226,59 @@ func (p *Pipeline) Resize(name string, newcap int) (int, error) {
 		return 0, ErrCorrupted
 	}
 
-	return p.stages[ndx].waypt.Resize(newcap), nil
+	s := &p.stages[ndx]
+
+	if s.waypt == nil {
+		if newcap < 0 {
+			return 0, waypoint.ErrInvalidCapacity
+		}
 
+		oldcap := s.capacity
+		s.capacity = newcap
+
+		return oldcap, nil
+	}
+
+	return s.waypt.Resize(newcap)
+}
+
+// Replace atomically replaces the StageFunc of the stage registered with the
+// given name by f, which is used for all items subsequently passed to that
+// stage (whether or not the receiver is running); items already being
+// processed are unaffected. Note that f replaces the StageFunc as if it were
+// originally passed to Add, so replacing the StageFunc of a stage added by
+// AddFilter or AddFlatMap changes that stage's behavior accordingly.
+//
+// If name is not a registered stage name then ErrNameUnknown is returned. If
+// f is nil or the stage has no StageFunc of its own (see AddBatch and
+// AddReduce) then ErrNoStageFunc is returned.
+func (p *Pipeline) Replace(name string, f StageFunc) error {
+	if p == nil {
+		return ErrNilReceiver
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	ndx, ok := p.byname[name]
+	if !ok {
+		return ErrNameUnknown
+	}
+
+	if ndx < 0 || ndx >= len(p.stages) {
+		return ErrCorrupted
+	}
+
+	s := &p.stages[ndx]
+
+	if f == nil || s.sfunc == nil {
+		return ErrNoStageFunc
+	}
+
+	s.swapped.Store(&f)
+	s.activate(p.middleware)
+
+	return nil
 }
 
 // GoContext adds cfunc to the list of ContextFuncs that will be executed
@@ -3,60 +3,3550 @@
 package pipeline
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/go-sage/synctools/pkg/waypoint"
 )
 
-func TestPipeline(t *testing.T) {
-	ctx := context.Background()
-	tt := mkTestThing(25, t)
-	p := New(tt)
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	tt := mkTestThing(25, t)
+	p := New(tt)
+
+	p.Add("stage1", 50, tt.stage1)
+
+	if err := p.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var smin, smax, emin, emax time.Time
+
+	for i, r := range tt.output {
+		if got, want := r.value, i+1; got != want {
+			t.Errorf("record %d beget %d; wanted %d", i, got, want)
+		}
+
+		if smin.IsZero() || r.start.Before(smin) {
+			smin = r.start
+		}
+
+		if emin.IsZero() || r.stop.Before(emin) {
+			emin = r.stop
+		}
+
+		if smax.IsZero() || r.start.After(smax) {
+			smax = r.start
+		}
+
+		if emax.IsZero() || r.stop.After(emax) {
+			emax = r.stop
+		}
+	}
+
+	want := tt.tolerance(12.5)
+
+	if srange := smax.Sub(smin); srange > want {
+		t.Errorf("Start range too wide: got %v; wanted %v", srange, want)
+	} else {
+		t.Logf("All workers began between %s and %s: %v", smin.Format(tf), smax.Format(tf), srange)
+	}
+
+	if erange := emax.Sub(emin); erange > want {
+		t.Errorf("End range too wide: got %v; wanted %v", erange, want)
+	} else {
+		t.Logf("All workers ended between %s and %s: %v", emin.Format(tf), emax.Format(tf), erange)
+	}
+}
+
+func TestDrainOnFeedError(t *testing.T) {
+	errFeed := errors.New("feed failure")
+	ft := &failThing{count: 10, err: errFeed}
+
+	p := New(ft, WithFeedErrorPolicy(DrainOnFeedError))
+	p.Add("double", 3, func(ctx context.Context, in any) (any, error) {
+		return in.(int) * 2, nil
+	})
+
+	if err := p.Run(context.Background()); err != errFeed {
+		t.Fatalf("Run: got %v; wanted %v", err, errFeed)
+	}
+
+	if got, want := len(ft.collected), ft.count; got != want {
+		t.Errorf("collected %d items; wanted %d", got, want)
+	}
+}
+
+func TestLockedThreads(t *testing.T) {
+	ft := &failThing{count: 20}
+
+	p := New(ft)
+	p.Add("locked", 5, func(ctx context.Context, in any) (any, error) {
+		return in, nil
+	}, WithLockedThreads(2))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := p.StageMetrics("locked")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sm.Threads == nil || sm.Threads.Threads != 2 || sm.Threads.Completed != 20 {
+		t.Errorf("thread metrics: got %+v; wanted 2 threads with 20 completed", sm.Threads)
+	}
+
+	if got, want := len(ft.collected), ft.count; got != want {
+		t.Errorf("collected %d items; wanted %d", got, want)
+	}
+}
+
+func TestInlineStages(t *testing.T) {
+	ft := &failThing{count: 20}
+	add := func(ctx context.Context, in any) (any, error) { return in.(int) + 1, nil }
+
+	p := New(ft)
+	p.Add("first", 4, add)
+	p.Add("second", 4, add, WithInline())
+	p.Add("third", 4, add, WithInline())
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := 0
+	for _, v := range ft.collected {
+		sum += v
+	}
+
+	// Each of 0..19 is incremented 3 times
+	if want := 190 + 3*ft.count; sum != want {
+		t.Errorf("sum of collected items: got %d; wanted %d", sum, want)
+	}
+
+	if sm, _ := p.StageMetrics("second"); sm.Waypoint.Finished != 0 {
+		t.Errorf("fused stage used its own Waypoint: %+v", sm.Waypoint)
+	}
+}
+
+type stateRecorder []State
+
+func (sr *stateRecorder) Export(ctx context.Context, st State) error {
+	*sr = append(*sr, st)
+	return nil
+}
+
+func TestStateExport(t *testing.T) {
+	ft := &failThing{count: 10}
+	sr := &stateRecorder{}
+
+	p := New(ft, WithStateExport(sr, time.Millisecond))
+	p.Add("slow", 2, func(ctx context.Context, in any) (any, error) {
+		time.Sleep(time.Millisecond)
+		return in, nil
+	})
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	states := *sr
+	if len(states) < 2 {
+		t.Fatalf("got %d exported states; wanted at least 2", len(states))
+	}
+
+	final := states[len(states)-1]
+	if !final.Final || final.Fed != 10 || final.Emitted != 10 || final.Stages[0].Name != "slow" {
+		t.Errorf("final state: got %+v", final)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	setup := func(p *Pipeline) error {
+		return p.Add("square", 3, func(ctx context.Context, in any) (any, error) {
+			return in.(int) * in.(int), nil
+		})
+	}
+
+	var got []int
+	for v, err := range Iterate[int, int](context.Background(), slices.Values([]int{1, 2, 3}), setup) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+
+	slices.Sort(got)
+	if want := []int{1, 4, 9}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+}
+
+func TestTypedStages(t *testing.T) {
+	double := NewStage("double", 2, func(_ context.Context, in int) (int, error) {
+		return 2 * in, nil
+	})
+
+	format := NewStage("format", 2, func(_ context.Context, in int) (string, error) {
+		return strconv.Itoa(in), nil
+	})
+
+	s := Then(double, format)
+
+	feed := func(ctx context.Context, ch chan<- int) error {
+		for i := range 3 {
+			ch <- i + 1
+		}
+		return nil
+	}
+
+	var got []string
+	collect := func(ctx context.Context, ch <-chan string) error {
+		for v := range ch {
+			got = append(got, v)
+		}
+		return nil
+	}
+
+	if err := Run(context.Background(), s, feed, collect); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(got)
+	if want := []string{"2", "4", "6"}; !slices.Equal(got, want) {
+		t.Errorf("Run: got %v; wanted %v", got, want)
+	}
+
+	got = got[:0]
+	for v, err := range s.Iterate(context.Background(), slices.Values([]int{5})) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+
+	if want := []string{"10"}; !slices.Equal(got, want) {
+		t.Errorf("Iterate: got %v; wanted %v", got, want)
+	}
+}
+
+func TestRecvWrongType(t *testing.T) {
+	ch := make(chan any, 1)
+	ch <- "nope"
+
+	if _, ok, err := Recv[int](context.Background(), ch); !ok || !errors.Is(err, ErrWrongType) {
+		t.Errorf("Recv: got (%v, %v); wanted (true, %v)", ok, err, ErrWrongType)
+	}
+
+	// The wanted type is named even if it is an interface type...
+	ch <- 42
+	if _, _, err := Recv[fmt.Stringer](context.Background(), ch); err == nil || !strings.Contains(err.Error(), "fmt.Stringer") {
+		t.Errorf("Recv: got error %v; wanted one naming fmt.Stringer", err)
+	}
+
+	// ...for which nil is a valid value.
+	ch <- nil
+	if v, ok, err := Recv[error](context.Background(), ch); !ok || err != nil || v != nil {
+		t.Errorf("Recv: got (%v, %v, %v); wanted (<nil>, true, <nil>)", v, ok, err)
+	}
+
+	var got []error
+	p := NewFromFuncs(FromSlice([]error{nil, io.EOF}), ToSlice(&got))
+	NewStage("errs", 1, func(ctx context.Context, in error) (error, error) { return in, nil }).AddTo(p)
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Errorf("got %v; wanted [<nil> EOF]", got)
+	}
+}
+
+func TestNewFromFuncs(t *testing.T) {
+	var sum int
+
+	p := NewFromFuncs(
+		FeedSeq(slices.Values([]int{1, 2, 3})),
+		func(ctx context.Context, ch <-chan any) error {
+			for v := range ch {
+				sum += v.(int)
+			}
+			return nil
+		},
+	)
+
+	p.Add("square", 2, func(ctx context.Context, in any) (any, error) {
+		return in.(int) * in.(int), nil
+	})
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if sum != 14 {
+		t.Errorf("sum: got %d; wanted 14", sum)
+	}
+}
+
+func TestSlices(t *testing.T) {
+	var got []int
+
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got))
+
+	p.Add("square", 1, func(ctx context.Context, in any) (any, error) {
+		return in.(int) * in.(int), nil
+	})
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{1, 4, 9, 16}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+}
+
+func TestChans(t *testing.T) {
+	in := make(chan int)
+	out := make(chan int)
+
+	go func() {
+		defer close(in)
+		for i := range 3 {
+			in <- i + 1
+		}
+	}()
+
+	p := NewFromFuncs(FromChan(in), ToChan(out))
+
+	p.Add("negate", 2, func(ctx context.Context, in any) (any, error) {
+		return -in.(int), nil
+	})
+
+	errc := make(chan error, 1)
+	go func() { errc <- p.Run(context.Background()) }()
+
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(got)
+	if want := []int{-3, -2, -1}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+}
+
+func TestReaderWriter(t *testing.T) {
+	var out strings.Builder
+
+	p := NewFromFuncs(FromReader(strings.NewReader("a\nbb\nccc\n"), nil), ToWriter(&out))
+
+	p.Add("len", 1, func(ctx context.Context, in any) (any, error) {
+		return len(in.(string)), nil
+	})
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.String(), "1\n2\n3\n"; got != want {
+		t.Errorf("got %q; wanted %q", got, want)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	type rec struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	var out strings.Builder
+
+	in := csv.NewReader(strings.NewReader("a,1\nb,2\n"))
+	decode := func(r []string) (rec, error) {
+		n, err := strconv.Atoi(r[1])
+		return rec{r[0], n}, err
+	}
+
+	p := NewFromFuncs(FromCSV(in, decode), ToJSONLines(&out))
+	p.Add("double", 1, func(ctx context.Context, in any) (any, error) {
+		r := in.(rec)
+		r.Count *= 2
+		return r, nil
+	})
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "{\"name\":\"a\",\"count\":2}\n{\"name\":\"b\",\"count\":4}\n"
+	if got := out.String(); got != want {
+		t.Fatalf("JSON lines: got %q; wanted %q", got, want)
+	}
+
+	var csvout strings.Builder
+	encode := func(r rec) ([]string, error) {
+		return []string{r.Name, strconv.Itoa(r.Count)}, nil
+	}
+
+	p = NewFromFuncs(FromJSONLines[rec](strings.NewReader(want)), ToCSV(csv.NewWriter(&csvout), encode))
+	p.Add("noop", 1, func(ctx context.Context, in any) (any, error) {
+		return in, nil
+	})
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := csvout.String(), "a,2\nb,4\n"; got != want {
+		t.Errorf("CSV: got %q; wanted %q", got, want)
+	}
+}
+
+func TestFromQuery(t *testing.T) {
+	db := sql.OpenDB(fakeDriver{})
+	defer db.Close()
+
+	scan := func(rows *sql.Rows) (int, error) {
+		var n int
+		err := rows.Scan(&n)
+		return n, err
+	}
+
+	var got []int
+	p := NewFromFuncs(FromQuery(db, scan, "SELECT n"), ToSlice(&got))
+	p.Add("inc", 1, func(ctx context.Context, in any) (any, error) {
+		return in.(int) + 1, nil
+	})
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{2, 3, 4}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	double := func(ctx context.Context, in any) (any, error) {
+		return in.(int) * 2, nil
+	}
+
+	t.Run("ProducerConsumer", func(t *testing.T) {
+		p := &sliceStream{in: []int{1, 2, 3}}
+
+		pl := NewFromFuncs(FromProducer[int](p), ToConsumer[int](p))
+		pl.Add("double", 1, double)
+
+		if err := pl.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int{2, 4, 6}; !slices.Equal(p.out, want) {
+			t.Errorf("got %v; wanted %v", p.out, want)
+		}
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pl := NewFromFuncs(FromRequest[int](r), ToResponse(w))
+			pl.Add("double", 1, double)
+
+			if err := pl.Run(r.Context()); err != nil {
+				t.Error(err)
+			}
+		})
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader("1\n2\n3\n"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got, want := rec.Header().Get("Content-Type"), "application/x-ndjson"; got != want {
+			t.Errorf("Content-Type: got %q; wanted %q", got, want)
+		}
+
+		if got, want := rec.Body.String(), "2\n4\n6\n"; got != want {
+			t.Errorf("got %q; wanted %q", got, want)
+		}
+
+		if !rec.Flushed {
+			t.Error("response was not flushed")
+		}
+	})
+}
+
+// sliceStream is a Producer and Consumer of ints backed by slices.
+type sliceStream struct {
+	in  []int
+	out []int
+}
+
+func (s *sliceStream) Recv() (int, error) {
+	if len(s.in) == 0 {
+		return 0, io.EOF
+	}
+
+	v := s.in[0]
+	s.in = s.in[1:]
+
+	return v, nil
+}
+
+func (s *sliceStream) Send(v int) error {
+	s.out = append(s.out, v)
+	return nil
+}
+
+func TestAck(t *testing.T) {
+	errFail := errors.New("stage failure")
+
+	double := func(ctx context.Context, in any) (any, error) {
+		if in.(int) == 3 {
+			return nil, errFail
+		}
+		return in.(int) * 2, nil
+	}
+
+	for _, tc := range []struct {
+		name   string
+		values []int
+		acked  []int
+		nacked []int
+		err    error
+	}{
+		{"Success", []int{1, 2, 4}, []int{1, 2, 4}, nil, nil},
+		{"Failure", []int{3}, nil, []int{3}, errFail},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := &ackQueue{values: tc.values}
+			var sink ackSinkFunc = func(ctx context.Context, v any) error {
+				src.Lock()
+				defer src.Unlock()
+				src.written = append(src.written, v.(int))
+				return nil
+			}
+
+			p := NewAck(src, sink)
+			p.Add("double", 2, double)
+
+			if err := p.Run(context.Background()); !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v; wanted %v", err, tc.err)
+			}
+
+			slices.Sort(src.acked)
+			slices.Sort(src.written)
+
+			if !slices.Equal(src.acked, tc.acked) {
+				t.Errorf("acked: got %v; wanted %v", src.acked, tc.acked)
+			}
+
+			if !slices.Equal(src.nacked, tc.nacked) {
+				t.Errorf("nacked: got %v; wanted %v", src.nacked, tc.nacked)
+			}
+
+			if len(src.written) != len(tc.acked) {
+				t.Errorf("written: got %v; wanted %d values", src.written, len(tc.acked))
+			}
+		})
+	}
+}
+
+// ackQueue is an AckSource that records which of its values are acked.
+type ackQueue struct {
+	values  []int
+	acked   []int
+	nacked  []int
+	written []int
+	ctx     context.Context // for each AckItem
+	sync.Mutex
+}
+
+func (q *ackQueue) Receive(ctx context.Context) (AckItem, error) {
+	if len(q.values) == 0 {
+		return AckItem{}, io.EOF
+	}
+
+	v := q.values[0]
+	q.values = q.values[1:]
+
+	return AckItem{
+		Value:   v,
+		Context: q.ctx,
+		Ack: func() {
+			q.Lock()
+			defer q.Unlock()
+			q.acked = append(q.acked, v)
+		},
+		Nack: func(error) {
+			q.Lock()
+			defer q.Unlock()
+			q.nacked = append(q.nacked, v)
+		},
+	}, nil
+}
+
+type ackSinkFunc func(context.Context, any) error
+
+func (f ackSinkFunc) Write(ctx context.Context, v any) error { return f(ctx, v) }
+
+func TestErrorPolicy(t *testing.T) {
+	errOdd := errors.New("odd value")
+
+	evens := func(ctx context.Context, in any) (any, error) {
+		if in.(int)%2 != 0 {
+			return nil, errOdd
+		}
+		return in, nil
+	}
+
+	run := func(t *testing.T, sfunc StageFunc, ep ErrorPolicy) ([]int, error) {
+		t.Helper()
+		var got []int
+
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got))
+		p.Add("stage", 1, sfunc, OnError(ep))
+
+		err := p.Run(context.Background())
+		slices.Sort(got)
+
+		return got, err
+	}
+
+	t.Run("Fail", func(t *testing.T) {
+		if _, err := run(t, evens, Fail); !errors.Is(err, errOdd) {
+			t.Errorf("got error %v; wanted %v", err, errOdd)
+		}
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		got, err := run(t, evens, Skip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int{2, 4}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		var calls atomic.Int32
+		flaky := func(ctx context.Context, in any) (any, error) {
+			if calls.Add(1)%3 != 0 {
+				return nil, errOdd
+			}
+			return in, nil
+		}
+
+		got, err := run(t, flaky, Retry(2, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int{1, 2, 3, 4}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		if _, err := run(t, evens, Retry(2, 0)); !errors.Is(err, errOdd) {
+			t.Errorf("got error %v; wanted %v", err, errOdd)
+		}
+	})
+
+	t.Run("DeadLetter", func(t *testing.T) {
+		var dead []any
+		dlfunc := func(ctx context.Context, se *StageError) error {
+			if se.Stage != "stage" || !errors.Is(se, errOdd) {
+				t.Errorf("unexpected StageError: %v", se)
+			}
+			dead = append(dead, se.Input)
+			return nil
+		}
+
+		got, err := run(t, evens, DeadLetter(dlfunc))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int{2, 4}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		if want := []any{1, 3}; !slices.Equal(dead, want) {
+			t.Errorf("dead letters: got %v; wanted %v", dead, want)
+		}
+	})
+
+	t.Run("WithDeadLetters", func(t *testing.T) {
+		dlch := make(chan *StageError, 4)
+		var got []int
+
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got), WithDeadLetters(DeadLetterChan(dlch)))
+		p.Add("evens", 1, evens, OnError(Skip))
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		close(dlch)
+
+		var dead []any
+		for se := range dlch {
+			if se.Stage != "evens" || !errors.Is(se, errOdd) {
+				t.Errorf("unexpected StageError: %v", se)
+			}
+			dead = append(dead, se.Input)
+		}
+
+		if want := []any{1, 3}; !slices.Equal(dead, want) {
+			t.Errorf("dead letters: got %v; wanted %v", dead, want)
+		}
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var (
+		errTransient = errors.New("transient")
+		errPermanent = errors.New("permanent")
+		calls        = make(map[int]int)
+	)
+
+	// Even values fail twice with a transient error then succeed; odd
+	// values always fail with a permanent error.
+	sfunc := func(ctx context.Context, in any) (any, error) {
+		v := in.(int)
+		calls[v]++
+
+		switch {
+		case v%2 != 0:
+			return nil, errPermanent
+		case calls[v] < 3:
+			return nil, errTransient
+		}
+
+		return v, nil
+	}
+
+	rp := RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     ExponentialBackoff(time.Microsecond, time.Millisecond),
+		Retryable:   func(err error) bool { return errors.Is(err, errTransient) },
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got))
+	p.Add("stage", 1, sfunc, WithRetry(rp), OnError(Skip))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{2, 4}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	if want := map[int]int{1: 1, 2: 3, 3: 1, 4: 3}; !maps.Equal(calls, want) {
+		t.Errorf("calls: got %v; wanted %v", calls, want)
+	}
+
+	backoff := ExponentialBackoff(time.Second, 5*time.Second)
+	for attempt, want := range []time.Duration{0, 1, 2, 4, 5, 5} {
+		if attempt == 0 {
+			continue
+		}
+
+		if got := backoff(attempt); got != want*time.Second {
+			t.Errorf("backoff(%d): got %v; wanted %v", attempt, got, want*time.Second)
+		}
+	}
+
+	unlimited := ExponentialBackoff(time.Second, 0)
+	for _, attempt := range []int{63, 64, 100, math.MaxInt} {
+		if got, want := unlimited(attempt), time.Duration(math.MaxInt64); got != want {
+			t.Errorf("unlimited(%d): got %v; wanted %v", attempt, got, want)
+		}
+	}
+}
+
+func TestStagePanic(t *testing.T) {
+	sfunc := func(ctx context.Context, in any) (any, error) {
+		if in.(int) == 3 {
+			panic("three")
+		}
+		return in, nil
+	}
+
+	for _, threads := range []int{0, 1} {
+		t.Run(fmt.Sprintf("threads=%d", threads), func(t *testing.T) {
+			var opts []StageOption
+			if threads > 0 {
+				opts = append(opts, WithLockedThreads(threads))
+			}
+
+			var got []int
+			p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got))
+			p.Add("panicky", 1, sfunc, opts...)
+
+			var pe *PanicError
+			if err := p.Run(context.Background()); !errors.As(err, &pe) {
+				t.Fatalf("got error %v; wanted a *PanicError", err)
+			}
+
+			if pe.Stage != "panicky" || pe.Value != "three" || !strings.Contains(string(pe.Stack), "TestStagePanic") {
+				t.Errorf("unexpected PanicError: %v\n%s", pe, pe.Stack)
+			}
+		})
+	}
+
+	t.Run("Skip", func(t *testing.T) {
+		var got []int
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got))
+		p.Add("panicky", 1, sfunc, OnError(Skip))
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int{1, 2, 4}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
+func TestStageTimeout(t *testing.T) {
+	// Odd values hang until their Context is canceled.
+	sfunc := func(ctx context.Context, in any) (any, error) {
+		if in.(int)%2 != 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return in, nil
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got))
+	p.Add("stage", 1, sfunc, WithTimeout(time.Millisecond))
+
+	err := p.Run(context.Background())
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v; wanted %v", err, ErrTimeout)
+	}
+
+	got = nil
+	p = NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got))
+	p.Add("stage", 1, sfunc, WithTimeout(time.Millisecond), OnError(Skip))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{2, 4}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+}
+
+func TestKeyedStage(t *testing.T) {
+	const nkeys = 3
+
+	var (
+		mu     sync.Mutex
+		active = make(map[int]bool)
+		seen   = make(map[int][]int)
+	)
+
+	sfunc := func(ctx context.Context, in any) (any, error) {
+		v := in.(int)
+		k := v % nkeys
+
+		mu.Lock()
+		if active[k] {
+			t.Errorf("key %d: concurrent processing of %d", k, v)
+		}
+		active[k] = true
+		seen[k] = append(seen[k], v)
+		mu.Unlock()
+
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+
+		mu.Lock()
+		active[k] = false
+		mu.Unlock()
+
+		return v, nil
+	}
+
+	in := make([]int, 100)
+	for i := range in {
+		in[i] = i
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice(in), ToSlice(&got))
+	p.Add("keyed", 8, sfunc, WithKey(func(in any) any { return in.(int) % nkeys }))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(in) {
+		t.Errorf("got %d values; wanted %d", len(got), len(in))
+	}
+
+	for k, vals := range seen {
+		if !slices.IsSorted(vals) {
+			t.Errorf("key %d: processed out of order: %v", k, vals)
+		}
+	}
+
+	// Outputs for each key must also emerge in order.
+	last := make(map[int]int)
+	for _, v := range got {
+		if prev, ok := last[v%nkeys]; ok && v < prev {
+			t.Errorf("key %d: %d emitted after %d", v%nkeys, v, prev)
+		}
+		last[v%nkeys] = v
+	}
+}
+
+func TestAddBranch(t *testing.T) {
+	square := func(ctx context.Context, in any) (any, error) {
+		return in.(int) * in.(int), nil
+	}
+
+	negate := func(ctx context.Context, in any) (any, error) {
+		return -in.(int), nil
+	}
+
+	inc := func(ctx context.Context, in any) (any, error) {
+		return in.(int) + 1, nil
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(&got))
+	p.Add("first", 2, inc)
+
+	err := p.AddBranch(
+		NewBranch().Add("square", 2, square).Add("negate", 1, negate, WithInline()),
+		NewStage("inc", 1, func(ctx context.Context, in int) (int, error) { return in + 1, nil }).Branch(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Add("last", 2, inc)
+
+	if err := p.AddBranch(NewBranch().Add("first", 1, inc)); err != ErrNameConflict {
+		t.Errorf("got error %v; wanted %v", err, ErrNameConflict)
+	}
+
+	if err := p.AddBranch(NewBranch()); err != ErrNoStages {
+		t.Errorf("got error %v; wanted %v", err, ErrNoStages)
+	}
+
+	if _, err := p.Resize("square", 3); err != nil {
+		t.Errorf("Resize: %v", err)
+	}
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(got)
+
+	// Inputs 2, 3, 4 become -4, -9, -16 and 3, 4, 5; each plus one.
+	if want := []int{-15, -8, -3, 4, 5, 6}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	if st := p.state(true); st.Fed != 3 || st.Emitted != 6 {
+		t.Errorf("state: got fed=%d emitted=%d; wanted 3 and 6", st.Fed, st.Emitted)
+	}
+
+	t.Run("Ack", func(t *testing.T) {
+		src := &ackQueue{values: []int{1, 2}}
+		var sink ackSinkFunc = func(ctx context.Context, v any) error { return nil }
+
+		p := NewAck(src, sink)
+		p.AddBranch(NewBranch().Add("a", 1, inc), NewBranch().Add("b", 1, inc))
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(src.acked)
+		if want := []int{1, 2}; !slices.Equal(src.acked, want) {
+			t.Errorf("acked: got %v; wanted %v", src.acked, want)
+		}
+	})
+}
+
+func TestGraph(t *testing.T) {
+	add := func(n int) StageFunc {
+		return func(ctx context.Context, in any) (any, error) {
+			return in.(int) + n, nil
+		}
+	}
+
+	// A diamond: src -> {left, right} -> join
+	var got []int
+	p := NewFromFuncs(FromSlice([]int{1, 2}), ToSlice(&got))
+	p.Add("src", 1, add(0))
+	p.Add("left", 2, add(10))
+	p.Add("right", 2, add(20))
+	p.Add("join", 2, add(100), WithInline())
+
+	for _, e := range [][2]string{{"src", "left"}, {"src", "right"}, {"left", "join"}, {"right", "join"}, {"src", "left"}} {
+		if err := p.Connect(e[0], e[1]); err != nil {
+			t.Fatalf("Connect(%q, %q): %v", e[0], e[1], err)
+		}
+	}
+
+	if err := p.Connect("src", "nope"); err != ErrNameUnknown {
+		t.Errorf("got error %v; wanted %v", err, ErrNameUnknown)
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(got)
+	if want := []int{111, 112, 121, 122}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	if st := p.state(true); st.Fed != 2 || st.Emitted != 4 {
+		t.Errorf("state: got fed=%d emitted=%d; wanted 2 and 4", st.Fed, st.Emitted)
+	}
+
+	t.Run("MultipleRootsAndSinks", func(t *testing.T) {
+		var got []int
+		p := NewFromFuncs(FromSlice([]int{1}), ToSlice(&got))
+		p.Add("a", 1, add(1))
+		p.Add("b", 1, add(2))
+		p.Add("c", 1, add(3))
+		p.Connect("a", "c")
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(got)
+		if want := []int{3, 5}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		p := NewFromFuncs(FromSlice([]int{1}), ToSlice(new([]int)))
+		p.Add("a", 1, add(1))
+		p.Add("b", 1, add(2))
+		p.Connect("a", "b")
+		p.Connect("b", "a")
+
+		if err := p.Run(context.Background()); err != ErrCycle {
+			t.Errorf("got error %v; wanted %v", err, ErrCycle)
+		}
+	})
+}
+
+func TestAddRouter(t *testing.T) {
+	tag := func(prefix string) StageFunc {
+		return func(ctx context.Context, in any) (any, error) {
+			return fmt.Sprintf("%s:%d", prefix, in), nil
+		}
+	}
+
+	var got []string
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4, 5, 6}), ToSlice(&got))
+	p.Add("fizz", 1, tag("fizz"))
+	p.Add("even", 1, tag("even"))
+	p.Add("other", 1, tag("other"))
+
+	err := p.AddRouter("router", "other",
+		Route{To: "fizz", When: func(v any) bool { return v.(int)%3 == 0 }},
+		Route{To: "even", When: func(v any) bool { return v.(int)%2 == 0 }},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddRouter("bad", "nope"); err != ErrNameUnknown {
+		t.Errorf("got error %v; wanted %v", err, ErrNameUnknown)
+	}
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(got)
+	if want := []string{"even:2", "even:4", "fizz:3", "fizz:6", "other:1", "other:5"}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	t.Run("NoDefault", func(t *testing.T) {
+		src := &ackQueue{values: []int{1, 2, 3, 4}}
+		var sink ackSinkFunc = func(ctx context.Context, v any) error {
+			src.Lock()
+			defer src.Unlock()
+			src.written = append(src.written, v.(int))
+			return nil
+		}
+
+		p := NewAck(src, sink)
+		p.Add("even", 1, func(ctx context.Context, in any) (any, error) { return in, nil })
+		p.AddRouter("router", "", Route{To: "even", When: func(v any) bool { return v.(int)%2 == 0 }})
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(src.acked)
+		slices.Sort(src.written)
+
+		if want := []int{1, 2, 3, 4}; !slices.Equal(src.acked, want) {
+			t.Errorf("acked: got %v; wanted %v", src.acked, want)
+		}
+
+		if want := []int{2, 4}; !slices.Equal(src.written, want) {
+			t.Errorf("written: got %v; wanted %v", src.written, want)
+		}
+	})
+}
+
+func TestFilters(t *testing.T) {
+	odd := func(ctx context.Context, in any) (bool, error) {
+		return in.(int)%2 != 0, nil
+	}
+
+	notThree := func(ctx context.Context, in any) (any, error) {
+		if in.(int) == 3 {
+			return nil, fmt.Errorf("three: %w", ErrDrop)
+		}
+		return in, nil
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4, 5}), ToSlice(&got))
+	p.AddFilter("odd", 1, odd)
+	p.Add("not-three", 1, notThree, WithInline(), WithRetry(RetryPolicy{MaxAttempts: 3}))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{1, 5}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	st := p.state(true)
+	for i, want := range []StageState{
+		{Name: "odd", In: 5, Out: 3, Skipped: 2},
+		{Name: "not-three", In: 3, Out: 2, Skipped: 1},
+	} {
+		if st.Stages[i] != want {
+			t.Errorf("stage %d: got %+v; wanted %+v", i, st.Stages[i], want)
+		}
+	}
+}
+
+func TestFlatMap(t *testing.T) {
+	split := func(ctx context.Context, in any, emit func(any)) error {
+		for _, f := range strings.Fields(in.(string)) {
+			emit(f)
+		}
+		return nil
+	}
+
+	notB := func(ctx context.Context, in any) (bool, error) {
+		return in.(string) != "b", nil
+	}
+
+	var got []string
+	p := NewFromFuncs(FromSlice([]string{"a b c", "", "d"}), ToSlice(&got))
+	p.AddFlatMap("split", 1, split)
+	p.AddFilter("not-b", 1, notB, WithInline())
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	t.Run("Ack", func(t *testing.T) {
+		src := &ackQueue{values: []int{0, 1, 3}}
+		var sink ackSinkFunc = func(ctx context.Context, v any) error { return nil }
+
+		p := NewAck(src, sink)
+		p.AddFlatMap("repeat", 1, func(ctx context.Context, in any, emit func(any)) error {
+			for i := 0; i < in.(int); i++ {
+				emit(i)
+			}
+			return nil
+		})
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(src.acked)
+		if want := []int{0, 1, 3}; !slices.Equal(src.acked, want) {
+			t.Errorf("acked: got %v; wanted %v", src.acked, want)
+		}
+	})
+}
+
+func TestBatching(t *testing.T) {
+	t.Run("Size", func(t *testing.T) {
+		var got [][]any
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4, 5, 6, 7}), ToSlice(&got))
+		p.AddBatch("batch", 3, 0, WithInline())
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		want := [][]any{{1, 2, 3}, {4, 5, 6}, {7}}
+		if !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("Window", func(t *testing.T) {
+		in := make(chan int)
+		var got [][]any
+
+		p := NewFromFuncs(FromChan(in), ToSlice(&got))
+		p.AddBatch("batch", 10, 10*time.Millisecond)
+
+		go func() {
+			defer close(in)
+			in <- 1
+			in <- 2
+			time.Sleep(50 * time.Millisecond)
+			in <- 3
+		}()
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		want := [][]any{{1, 2}, {3}}
+		if !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("Unbatch", func(t *testing.T) {
+		src := &ackQueue{values: []int{1, 2, 3, 4, 5}}
+		var sink ackSinkFunc = func(ctx context.Context, v any) error {
+			src.Lock()
+			defer src.Unlock()
+			src.written = append(src.written, v.(int))
+			return nil
+		}
+
+		p := NewAck(src, sink)
+		p.AddBatch("batch", 2, 0)
+		p.AddUnbatch("unbatch", 1)
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []int{1, 2, 3, 4, 5}
+
+		if !slices.Equal(src.written, want) {
+			t.Errorf("written: got %v; wanted %v", src.written, want)
+		}
+
+		slices.Sort(src.acked)
+		if !slices.Equal(src.acked, want) {
+			t.Errorf("acked: got %v; wanted %v", src.acked, want)
+		}
+	})
+}
+
+func TestReduce(t *testing.T) {
+	sum := func(ctx context.Context, acc, item any) (any, error) {
+		return acc.(int) + item.(int), nil
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got))
+	p.Add("double", 2, func(ctx context.Context, in any) (any, error) { return in.(int) * 2, nil })
+	p.AddReduce("sum", 0, sum)
+	p.Add("inc", 1, func(ctx context.Context, in any) (any, error) { return in.(int) + 1, nil }, WithInline())
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{21}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	t.Run("Typed", func(t *testing.T) {
+		concat := Reduce("concat", "", func(ctx context.Context, acc string, item int) (string, error) {
+			return acc + strconv.Itoa(item), nil
+		})
+
+		var got []string
+		err := Run(context.Background(), concat,
+			func(ctx context.Context, ch chan<- int) error {
+				for i := range 4 {
+					ch <- i
+				}
+				return nil
+			},
+			func(ctx context.Context, ch <-chan string) error {
+				for v := range ch {
+					got = append(got, v)
+				}
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"0123"}; !slices.Equal(got, want) {
+			t.Errorf("got %q; wanted %q", got, want)
+		}
+	})
+
+	t.Run("NilSeed", func(t *testing.T) {
+		total := Reduce("total", any(nil), func(ctx context.Context, acc any, item int) (any, error) {
+			if acc == nil {
+				return item, nil
+			}
+			return acc.(int) + item, nil
+		})
+
+		var got []any
+		err := Run(context.Background(), total,
+			func(ctx context.Context, ch chan<- int) error {
+				for i := range 4 {
+					ch <- i + 1
+				}
+				return nil
+			},
+			func(ctx context.Context, ch <-chan any) error {
+				for v := range ch {
+					got = append(got, v)
+				}
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []any{10}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		var got []int
+		p := NewFromFuncs(FromSlice([]int{}), ToSlice(&got))
+		p.AddReduce("sum", 100, sum)
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int{100}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
+func TestStageRateLimit(t *testing.T) {
+	var got []int
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4, 5}), ToSlice(&got))
+	p.Add("limited", 5, func(ctx context.Context, in any) (any, error) {
+		return in, nil
+	}, WithRateLimit(200, 1))
+
+	start := time.Now()
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// With a burst of one, 5 items at 200/s take at least 4 * 5ms.
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("run took %v; wanted at least 20ms", elapsed)
+	}
+
+	if len(got) != 5 {
+		t.Errorf("got %d items; wanted 5", len(got))
+	}
+}
+
+func TestBuffers(t *testing.T) {
+	var sent atomic.Int32
+	release := make(chan struct{})
+
+	feed := func(ctx context.Context, ch chan<- any) error {
+		for i := range 10 {
+			if err := Send(ctx, i, ch); err != nil {
+				return err
+			}
+			sent.Add(1)
+		}
+		return nil
+	}
+
+	slow := func(ctx context.Context, in any) (any, error) {
+		<-release
+		return in, nil
+	}
+
+	p := NewFromFuncs(feed, ToSlice(new([]int)), WithBuffer(1))
+	p.Add("slow", 1, slow, WithStageBuffer(4))
+
+	errch := make(chan error)
+	go func() { errch <- p.Run(context.Background()) }()
+
+	// One item is being processed, one awaits a Worker and four are
+	// buffered; i.e. Feed should be able to send 6 items.
+	deadline := time.Now().Add(time.Second)
+	for sent.Load() < 6 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if got := sent.Load(); got != 6 {
+		t.Errorf("sent %d items before blocking; wanted 6", got)
+	}
+
+	close(release)
+
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+
+	if st := p.state(true); st.Emitted != 10 {
+		t.Errorf("emitted %d items; wanted 10", st.Emitted)
+	}
+}
+
+func TestOrdered(t *testing.T) {
+	jitter := func(ctx context.Context, in any) (any, error) {
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		if in.(int)%10 == 0 {
+			return nil, ErrDrop
+		}
+		return in, nil
+	}
+
+	in := make([]int, 100)
+	for i := range in {
+		in[i] = i
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice(in), ToSlice(&got))
+	p.Add("first", 8, jitter, WithOrdered())
+	p.Add("second", 8, jitter, WithOrdered(), WithLockedThreads(4))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 90 || !slices.IsSorted(got) {
+		t.Errorf("got %d items in order %v; wanted 90 sorted items", len(got), got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	sleepy := func(ctx context.Context, in any) (any, error) {
+		time.Sleep(time.Millisecond)
+		if in.(int) == 2 {
+			return nil, ErrDrop
+		}
+		return in, nil
+	}
+
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(new([]int)), WithName("metrics"))
+	p.Add("sleepy", 2, sleepy)
+	p.Add("inline", 1, func(ctx context.Context, in any) (any, error) { return in, nil }, WithInline())
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m := p.Metrics()
+
+	if m.Name != "metrics" || len(m.Stages) != 2 {
+		t.Fatalf("unexpected Metrics: %+v", m)
+	}
+
+	s := m.Stages[0]
+	if s.Name != "sleepy" || s.In != 3 || s.Out != 2 || s.Skipped != 1 || s.Errors != 0 {
+		t.Errorf("unexpected StageMetrics: %+v", s)
+	}
+
+	if s.Latency < time.Millisecond || s.ProcessingTime < 3*time.Millisecond {
+		t.Errorf("latency %v (total %v); wanted at least 1ms (3ms)", s.Latency, s.ProcessingTime)
+	}
+
+	if s.Waypoint.Finished != 3 {
+		t.Errorf("waypoint finished %d; wanted 3", s.Waypoint.Finished)
+	}
+
+	if s := m.Stages[1]; s.In != 2 || s.Out != 2 {
+		t.Errorf("unexpected inline StageMetrics: %+v", s)
+	}
+
+	if sm, err := p.StageMetrics("inline"); err != nil || sm.Out != 2 {
+		t.Errorf("StageMetrics: got %+v, %v", sm, err)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(new([]int)), WithName(`my "pipe"`))
+	p.Add("stage", 2, func(ctx context.Context, in any) (any, error) {
+		return in, nil
+	}, WithHistograms(time.Millisecond, time.Second))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	PrometheusHandler(p).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+
+	labels := `{pipeline="my \"pipe\"",stage="stage"}`
+
+	for _, want := range []string{
+		"# TYPE pipeline_stage_items_in_total counter\n",
+		"pipeline_stage_items_in_total" + labels + " 3\n",
+		"pipeline_stage_items_out_total" + labels + " 3\n",
+		"pipeline_stage_capacity" + labels + " 2\n",
+		"# TYPE pipeline_stage_active_seconds histogram\n",
+		`pipeline_stage_active_seconds_bucket{pipeline="my \"pipe\"",stage="stage",le="+Inf"} 3` + "\n",
+		"pipeline_stage_active_seconds_count" + labels + " 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	errBad := errors.New("bad value")
+
+	double := func(ctx context.Context, in any) (any, error) {
+		switch in.(int) {
+		case 2:
+			return nil, ErrDrop
+		case 3:
+			return nil, errBad
+		}
+		return in.(int) * 2, nil
+	}
+
+	check := func(ctx context.Context, in any) (any, error) {
+		if span, _ := ctx.Value(fakeSpanKey{}).(*fakeSpan); span == nil || span.name != "check" {
+			return nil, fmt.Errorf("StageFunc has wrong span: %+v", span)
+		}
+		return in, nil
+	}
+
+	validate := func(t *testing.T, ft *fakeTracer, items, doubles, checks int) {
+		t.Helper()
+
+		if n := ft.count(ItemSpanName); n != items {
+			t.Errorf("got %d item spans; wanted %d", n, items)
+		}
+
+		if n := ft.count("double"); n != doubles {
+			t.Errorf("got %d double spans; wanted %d", n, doubles)
+		}
+
+		if n := ft.count("check"); n != checks {
+			t.Errorf("got %d check spans; wanted %d", n, checks)
+		}
+
+		for _, s := range ft.spans {
+			if s.ended != 1 {
+				t.Errorf("span %q ended %d times; wanted 1", s.name, s.ended)
+			}
+
+			if s.name != ItemSpanName && (s.parent == nil || s.parent.name != ItemSpanName) {
+				t.Errorf("span %q has parent %+v; wanted %q", s.name, s.parent, ItemSpanName)
+			}
+		}
+	}
+
+	t.Run("funcs", func(t *testing.T) {
+		ft := new(fakeTracer)
+
+		var got []int
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), ToSlice(&got), WithTracer(ft))
+		p.Add("double", 2, double, OnError(Skip))
+		p.Add("check", 1, check, WithInline())
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(got)
+		if !slices.Equal(got, []int{2, 8}) {
+			t.Errorf("got %v; wanted [2 8]", got)
+		}
+
+		validate(t, ft, 4, 4, 2)
+
+		for _, s := range ft.spans {
+			if s.err != nil {
+				t.Errorf("span %q ended with error: %v", s.name, s.err)
+			}
+		}
+	})
+
+	t.Run("ack", func(t *testing.T) {
+		ft := new(fakeTracer)
+		pctx, producer := ft.Start(context.Background(), "producer")
+
+		src := &ackQueue{values: []int{1, 3}, ctx: pctx}
+		var sink ackSinkFunc = func(ctx context.Context, v any) error { return nil }
+
+		p := NewAck(src, sink, WithTracer(ft))
+		p.Add("double", 2, double)
+		p.Add("check", 1, check)
+
+		if err := p.Run(context.Background()); !errors.Is(err, errBad) {
+			t.Fatalf("got error %v; wanted %v", err, errBad)
+		}
+
+		producer.End(nil)
+
+		for _, s := range ft.spans {
+			switch {
+			case s.name == ItemSpanName && s.parent != producer:
+				t.Errorf("item span has parent %+v; wanted producer", s.parent)
+			case s.name == "double" && s.err != nil && !errors.Is(s.err, errBad):
+				t.Errorf("double span ended with %v; wanted %v", s.err, errBad)
+			}
+		}
+
+		if !slices.Contains(src.nacked, 3) {
+			t.Errorf("got nacked=%v; wanted 3 to be nacked", src.nacked)
+		}
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	errFlaky := errors.New("flaky")
+
+	tenant := func(v any) Metadata {
+		if v.(int)%2 == 0 {
+			return Metadata{"tenant": "even"}
+		}
+		return Metadata{"tenant": "odd"}
+	}
+
+	flaky := func(ctx context.Context, in any) (any, error) {
+		info := ItemFrom(ctx)
+		if info.Attempt == 1 {
+			return nil, errFlaky
+		}
+
+		SetMetadata(ctx, "attempts", strconv.Itoa(info.Attempt))
+		return in, nil
+	}
+
+	describe := func(ctx context.Context, in any) (any, error) {
+		info := ItemFrom(ctx)
+		if info.Enqueued.IsZero() {
+			return nil, errors.New("missing Enqueued time")
+		}
+		return fmt.Sprintf("%d:%s:%s", in, info.Metadata["tenant"], info.Metadata["attempts"]), nil
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		var got []string
+		p := NewFromFuncs(FromSlice([]int{1, 2}), ToSlice(&got), WithMetadata(tenant))
+		p.Add("flaky", 1, flaky, WithRetry(RetryPolicy{MaxAttempts: 2}))
+		p.Add("describe", 1, describe)
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"1:odd:2", "2:even:2"}; !slices.Equal(got, want) {
+			t.Errorf("got %q; wanted %q", got, want)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var got []ItemInfo
+		p := NewFromFuncs(FromSlice([]int{1}), ToSlice(&got))
+		p.Add("info", 1, func(ctx context.Context, in any) (any, error) {
+			SetMetadata(ctx, "ignored", "value")
+			return ItemFrom(ctx), nil
+		})
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 1 || got[0].Attempt != 1 || !got[0].Enqueued.IsZero() || got[0].Metadata != nil {
+			t.Errorf("got %+v; wanted a single ItemInfo with only Attempt=1", got)
+		}
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+		for _, opt := range []Option{WithClock(fixedClock(now)), WithPooling(nil)} {
+			var got []ItemInfo
+			p := NewFromFuncs(FromSlice([]int{1}), ToSlice(&got), WithMetadata(tenant), WithClock(fixedClock(now)), opt)
+			p.Add("info", 1, func(ctx context.Context, in any) (any, error) {
+				return ItemFrom(ctx), nil
+			})
+
+			if err := p.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != 1 || !got[0].Enqueued.Equal(now) {
+				t.Errorf("got %+v; wanted Enqueued=%v", got, now)
+			}
+		}
+	})
+
+	t.Run("aggregated", func(t *testing.T) {
+		tagged := func(ctx context.Context, in any) (any, error) {
+			return fmt.Sprintf("%v:%s", in, ItemFrom(ctx).Metadata["tenant"]), nil
+		}
+
+		sum := func(ctx context.Context, acc, item any) (any, error) {
+			return acc.(int) + item.(int), nil
+		}
+
+		var batched, reduced []string
+
+		p := NewFromFuncs(FromSlice([]int{2, 1, 3}), ToSlice(&batched), WithMetadata(tenant))
+		p.AddBatch("batch", 2, 0)
+		p.Add("tagged", 1, tagged)
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"[2 1]:even", "[3]:odd"}; !slices.Equal(batched, want) {
+			t.Errorf("batched: got %q; wanted %q", batched, want)
+		}
+
+		p = NewFromFuncs(FromSlice([]int{2, 1, 3}), ToSlice(&reduced), WithMetadata(tenant))
+		p.AddReduce("sum", 0, sum)
+		p.Add("tagged", 1, tagged)
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"6:even"}; !slices.Equal(reduced, want) {
+			t.Errorf("reduced: got %q; wanted %q", reduced, want)
+		}
+	})
+}
+
+func TestOnProgress(t *testing.T) {
+	in := make([]int, 10)
+	for i := range in {
+		in[i] = i
+	}
+
+	var reports []ProgressReport
+	record := func(pr ProgressReport) {
+		reports = append(reports, pr)
+	}
+
+	p := NewFromFuncs(FromSlice(in), ToSlice(new([]int)), OnProgress(record, 3, 0))
+	p.Add("first", 2, func(ctx context.Context, in any) (any, error) { return in, nil })
+	p.Add("second", 2, func(ctx context.Context, in any) (any, error) { return in, nil })
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var fed []uint64
+	for _, pr := range reports {
+		fed = append(fed, pr.Fed)
+	}
+
+	if want := []uint64{3, 6, 9, 10}; !slices.Equal(fed, want) {
+		t.Fatalf("got reports for %v items; wanted %v", fed, want)
+	}
+
+	final := reports[len(reports)-1]
+	if !final.Final || final.Elapsed <= 0 || len(final.Stages) != 2 {
+		t.Fatalf("unexpected final report: %+v", final)
+	}
+
+	for _, ss := range final.Stages {
+		if ss.In != 10 || ss.Out != 10 || ss.InFlight != 0 {
+			t.Errorf("unexpected final StageState: %+v", ss)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	var fed atomic.Int64
+	feed := func(ctx context.Context, ch chan<- any) error {
+		for i := 0; ; i++ {
+			if err := Send(ctx, any(i), ch); err != nil {
+				return err
+			}
+			fed.Add(1)
+		}
+	}
+
+	var got []int
+	p := NewFromFuncs(feed, ToSlice(&got))
+	p.Add("slow", 4, func(ctx context.Context, in any) (any, error) {
+		time.Sleep(time.Millisecond)
+		return in, ctx.Err()
+	})
+
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop before Run: %v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- p.Run(context.Background()) }()
+
+	for fed.Load() < 20 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if n := fed.Load(); int64(len(got)) != n {
+		t.Errorf("collected %d items; wanted all %d fed items", len(got), n)
+	}
+}
+
+func TestStart(t *testing.T) {
+	t.Run("stop", func(t *testing.T) {
+		var fed atomic.Int64
+		feed := func(ctx context.Context, ch chan<- any) error {
+			for i := 0; ; i++ {
+				if err := Send(ctx, any(i), ch); err != nil {
+					return err
+				}
+				fed.Add(1)
+			}
+		}
+
+		p := NewFromFuncs(feed, ToSlice(new([]int)))
+		p.Add("identity", 2, func(ctx context.Context, in any) (any, error) { return in, nil })
+
+		h, err := p.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for fed.Load() < 10 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if err := h.Err(); err != nil {
+			t.Errorf("Err while running: %v", err)
+		}
+
+		if m := h.Metrics(); len(m.Stages) != 1 || m.Stages[0].In == 0 {
+			t.Errorf("unexpected Metrics: %+v", m)
+		}
+
+		if err := h.Stop(context.Background()); err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+
+		<-h.Done()
+
+		if err := h.Wait(); err != nil {
+			t.Errorf("Wait: %v", err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		errBoom := errors.New("boom")
+
+		p := NewFromFuncs(FromSlice([]int{1}), ToSlice(new([]int)))
+		p.Add("boom", 1, func(ctx context.Context, in any) (any, error) { return nil, errBoom })
+
+		h, err := p.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := h.Wait(); !errors.Is(err, errBoom) {
+			t.Errorf("got Wait error %v; wanted %v", err, errBoom)
+		}
+
+		if err := h.Err(); !errors.Is(err, errBoom) {
+			t.Errorf("got Err %v; wanted %v", err, errBoom)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		p := NewFromFuncs(FromSlice([]int{1}), ToSlice(new([]int)))
+		if _, err := p.Start(context.Background()); err != ErrNoStages {
+			t.Errorf("got error %v; wanted %v", err, ErrNoStages)
+		}
+	})
+}
+
+func TestReplace(t *testing.T) {
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(new([]int)))
+	p.Add("double", 1, func(ctx context.Context, in any) (any, error) { return in.(int) * 2, nil })
+	p.AddBatch("batch", 2, 0)
+
+	triple := func(ctx context.Context, in any) (any, error) { return in.(int) * 3, nil }
+
+	if err := p.Replace("unknown", triple); err != ErrNameUnknown {
+		t.Errorf("got error %v; wanted %v", err, ErrNameUnknown)
+	}
+
+	if err := p.Replace("batch", triple); err != ErrNoStageFunc {
+		t.Errorf("got error %v; wanted %v", err, ErrNoStageFunc)
+	}
+
+	if err := p.Replace("double", nil); err != ErrNoStageFunc {
+		t.Errorf("got error %v; wanted %v", err, ErrNoStageFunc)
+	}
+
+	feed, out := make(chan int), make(chan int, 2)
+	q := NewFromFuncs(FromChan(feed), ToChan(out))
+	q.Add("xform", 1, func(ctx context.Context, in any) (any, error) { return in.(int) * 2, nil })
+
+	h, err := q.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed <- 1
+	if v := <-out; v != 2 {
+		t.Errorf("got %d; wanted 2", v)
+	}
+
+	if err := q.Replace("xform", triple); err != nil {
+		t.Fatal(err)
+	}
+
+	feed <- 3
+	close(feed)
+
+	if v := <-out; v != 9 {
+		t.Errorf("got %d; wanted 9", v)
+	}
+
+	if err := h.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	var (
+		events []string
+		mu     sync.Mutex
+	)
+
+	hook := func(event string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+			return err
+		}
+	}
+
+	identity := func(ctx context.Context, in any) (any, error) { return in, nil }
+
+	t.Run("success", func(t *testing.T) {
+		events = nil
+
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(new([]int)))
+		p.Add("first", 2, identity, WithSetup(hook("setup first", nil)), WithTeardown(hook("teardown first", nil)))
+		p.Add("second", 1, identity, WithInline(), WithSetup(hook("setup second", nil)), WithTeardown(hook("teardown second", nil)))
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"setup first", "setup second", "teardown second", "teardown first"}
+		if !slices.Equal(events, want) {
+			t.Errorf("got events %q; wanted %q", events, want)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		events = nil
+		errSetup := errors.New("setup failed")
+		errTeardown := errors.New("teardown failed")
+
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(new([]int)))
+		p.Add("first", 2, identity, WithSetup(hook("setup first", nil)), WithTeardown(hook("teardown first", errTeardown)))
+		p.Add("second", 1, identity, WithInline(), WithSetup(hook("setup second", errSetup)), WithTeardown(hook("teardown second", nil)))
+
+		err := p.Run(context.Background())
+		if !errors.Is(err, errSetup) || !errors.Is(err, errTeardown) {
+			t.Fatalf("got error %v; wanted %v and %v", err, errSetup, errTeardown)
+		}
+
+		want := []string{"setup first", "setup second", "teardown first"}
+		if !slices.Equal(events, want) {
+			t.Errorf("got events %q; wanted %q", events, want)
+		}
+	})
+}
+
+func TestStateful(t *testing.T) {
+	type scratch struct {
+		busy atomic.Bool
+		seen int
+	}
+
+	var created atomic.Int64
+	newScratch := func() *scratch {
+		created.Add(1)
+		return new(scratch)
+	}
+
+	use := func(ctx context.Context, s *scratch, in int) (int, error) {
+		if !s.busy.CompareAndSwap(false, true) {
+			return 0, errors.New("state shared by concurrent workers")
+		}
+		defer s.busy.Store(false)
+
+		s.seen++
+		time.Sleep(100 * time.Microsecond)
+		return in, nil
+	}
+
+	in := make([]int, 50)
+	for i := range in {
+		in[i] = i
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice(in), ToSlice(&got))
+	err := AddStateful(p, "untyped", 3, newScratch, func(ctx context.Context, s *scratch, in any) (any, error) {
+		return use(ctx, s, in.(int))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStatefulStage("typed", 3, newScratch, use).AddTo(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(in) {
+		t.Errorf("got %d items; wanted %d", len(got), len(in))
+	}
+
+	if n := created.Load(); n < 2 || n > 6 {
+		t.Errorf("created %d states; wanted between 2 and 6", n)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tag := func(name string) Middleware {
+		return func(next StageFunc) StageFunc {
+			return func(ctx context.Context, in any) (any, error) {
+				out, err := next(ctx, in)
+				if err != nil {
+					return nil, err
+				}
+				return out.(string) + " " + name, nil
+			}
+		}
+	}
+
+	var got []string
+	p := NewFromFuncs(FromSlice([]string{"a"}), ToSlice(&got), WithMiddleware(tag("p1"), tag("p2")))
+	p.Add("first", 1, func(ctx context.Context, in any) (any, error) { return in, nil }, WithStageMiddleware(tag("s1"), tag("s2")))
+	p.Add("second", 1, func(ctx context.Context, in any) (any, error) { return in, nil })
+
+	if err := p.Replace("first", func(ctx context.Context, in any) (any, error) { return in.(string) + "!", nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a! s2 s1 p2 p1 p2 p1"}; !slices.Equal(got, want) {
+		t.Errorf("got %q; wanted %q", got, want)
+	}
+}
+
+func TestStageError(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(new([]int)))
+	p.Add("ok", 2, func(ctx context.Context, in any) (any, error) { return in, nil })
+	p.Add("boom", 2, func(ctx context.Context, in any) (any, error) {
+		if in.(int) == 2 {
+			return nil, errBoom
+		}
+		return in, nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 2}))
+
+	err := p.Run(context.Background())
+
+	var se *StageError
+	if !errors.As(err, &se) || !errors.Is(err, errBoom) {
+		t.Fatalf("got error %v; wanted a *StageError wrapping %v", err, errBoom)
+	}
+
+	if se.Stage != "boom" || se.Input != 2 || se.Attempt != 2 || se.Worker == 0 {
+		t.Errorf("unexpected StageError: %+v", se)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	errOdd := errors.New("odd value")
+
+	in := make([]int, 10)
+	for i := range in {
+		in[i] = i
+	}
+
+	var got []int
+	p := NewFromFuncs(FromSlice(in), ToSlice(&got), ContinueOnError(2))
+	p.Add("evens", 2, func(ctx context.Context, in any) (any, error) {
+		if in.(int)%2 != 0 {
+			return nil, errOdd
+		}
+		return in, nil
+	})
+	p.Add("small", 2, func(ctx context.Context, in any) (any, error) {
+		if in.(int) > 6 {
+			return nil, fmt.Errorf("too big: %d", in)
+		}
+		return in, nil
+	})
+
+	for range 2 {
+		got = nil
+		err := p.Run(context.Background())
+
+		var er *ErrorReport
+		if !errors.As(err, &er) {
+			t.Fatalf("got error %v; wanted an *ErrorReport", err)
+		}
+
+		if er.Total != 6 || er.Counts["evens"] != 5 || er.Counts["small"] != 1 || len(er.Samples) != 2 {
+			t.Errorf("unexpected ErrorReport: %+v", er)
+		}
+
+		if !errors.Is(err, errOdd) {
+			t.Errorf("ErrorReport %v does not wrap %v", err, errOdd)
+		}
+
+		slices.Sort(got)
+		if want := []int{0, 2, 4, 6}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	}
+
+	t.Run("Ack", func(t *testing.T) {
+		src := &ackQueue{values: []int{1, 2, 3, 4}}
+		var sink ackSinkFunc = func(ctx context.Context, v any) error {
+			src.Lock()
+			defer src.Unlock()
+			src.written = append(src.written, v.(int))
+			return nil
+		}
+
+		p := NewAck(src, sink, ContinueOnError(1))
+		p.Add("evens", 2, func(ctx context.Context, in any) (any, error) {
+			if in.(int)%2 != 0 {
+				return nil, errOdd
+			}
+			return in, nil
+		})
+
+		var er *ErrorReport
+		if err := p.Run(context.Background()); !errors.As(err, &er) || er.Total != 2 {
+			t.Fatalf("got error %v; wanted an *ErrorReport with 2 failures", err)
+		}
+
+		slices.Sort(src.acked)
+		slices.Sort(src.nacked)
+
+		if want := []int{2, 4}; !slices.Equal(src.acked, want) {
+			t.Errorf("acked: got %v; wanted %v", src.acked, want)
+		}
+
+		if want := []int{1, 3}; !slices.Equal(src.nacked, want) {
+			t.Errorf("nacked: got %v; wanted %v", src.nacked, want)
+		}
+	})
+}
+
+func TestSinks(t *testing.T) {
+	identity := func(ctx context.Context, in any) (any, error) { return in, nil }
+
+	t.Run("tee", func(t *testing.T) {
+		var primary, audit []int
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3}), Tee(ToSlice(&primary), ToSlice(&audit)))
+		p.Add("identity", 1, identity)
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []int{1, 2, 3}; !slices.Equal(primary, want) || !slices.Equal(audit, want) {
+			t.Errorf("got primary=%v audit=%v; wanted %v for both", primary, audit, want)
+		}
+	})
+
+	t.Run("route", func(t *testing.T) {
+		var evens, rest []int
+		isEven := func(v any) bool { return v.(int)%2 == 0 }
+
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), RouteSinks(ToSlice(&rest), SinkRoute{ToSlice(&evens), isEven}))
+		p.Add("identity", 1, identity)
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(evens, []int{2, 4}) || !slices.Equal(rest, []int{1, 3}) {
+			t.Errorf("got evens=%v rest=%v; wanted [2 4] and [1 3]", evens, rest)
+		}
+
+		// A nil When matches every item.
+		evens, rest = nil, nil
+		var all []int
+
+		p = NewFromFuncs(FromSlice([]int{1, 2, 3, 4}), RouteSinks(ToSlice(&rest), SinkRoute{ToSlice(&evens), isEven}, SinkRoute{To: ToSlice(&all)}))
+		p.Add("identity", 1, identity)
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(evens, []int{2, 4}) || !slices.Equal(all, []int{1, 3}) || rest != nil {
+			t.Errorf("got evens=%v all=%v rest=%v; wanted [2 4], [1 3] and none", evens, all, rest)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		errSink := errors.New("sink failed")
+		failing := func(ctx context.Context, ch <-chan any) error { return errSink }
+
+		var got []int
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3}), Tee(ToSlice(&got), failing))
+		p.Add("identity", 1, identity)
+
+		if err := p.Run(context.Background()); !errors.Is(err, errSink) {
+			t.Errorf("got error %v; wanted %v", err, errSink)
+		}
+	})
+}
+
+func TestMerge(t *testing.T) {
+	live := make(chan int)
+	go func() {
+		defer close(live)
+		for i := 100; i < 103; i++ {
+			live <- i
+		}
+	}()
+
+	var got []int
+	p := NewFromFuncs(Merge(FromSlice([]int{1, 2, 3}), FromChan(live)), ToSlice(&got))
+	p.Add("identity", 2, func(ctx context.Context, in any) (any, error) { return in, nil })
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(got)
+	if want := []int{1, 2, 3, 100, 101, 102}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	errFeed := errors.New("feed failed")
+	blocked := func(ctx context.Context, ch chan<- any) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	p = NewFromFuncs(Merge(blocked, func(context.Context, chan<- any) error { return errFeed }), ToSlice(new([]int)))
+	p.Add("identity", 1, func(ctx context.Context, in any) (any, error) { return in, nil })
+
+	if err := p.Run(context.Background()); !errors.Is(err, errFeed) {
+		t.Errorf("got error %v; wanted %v", err, errFeed)
+	}
+}
+
+func TestAddPipeline(t *testing.T) {
+	errNeg := errors.New("negative value")
+
+	segment := NewFromFuncs(nil, nil)
+	segment.Add("check", 2, func(ctx context.Context, in any) (any, error) {
+		if in.(int) < 0 {
+			return nil, errNeg
+		}
+		return in, nil
+	})
+	segment.Add("double", 2, func(ctx context.Context, in any) (any, error) { return in.(int) * 2, nil })
+	segment.AddBranch(NewBranch().Add("a", 1, func(ctx context.Context, in any) (any, error) { return in, nil }),
+		NewBranch().Add("b", 1, func(ctx context.Context, in any) (any, error) { return in.(int) + 1, nil }))
+
+	var got []int
+	p := NewFromFuncs(FromSlice([]int{1, 2}), ToSlice(&got))
+	p.Add("first", 1, func(ctx context.Context, in any) (any, error) { return in, nil })
+
+	if err := p.AddPipeline("seg", segment); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddPipeline("seg", segment); err != ErrNameConflict {
+		t.Errorf("got error %v; wanted %v", err, ErrNameConflict)
+	}
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(got)
+	if want := []int{2, 3, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	if _, err := p.StageMetrics("seg/double"); err != nil {
+		t.Errorf("StageMetrics(seg/double): %v", err)
+	}
+
+	q := NewFromFuncs(FromSlice([]int{-1}), ToSlice(new([]int)))
+	if err := q.AddPipeline("seg", segment); err != nil {
+		t.Fatal(err)
+	}
+
+	var se *StageError
+	if err := q.Run(context.Background()); !errors.As(err, &se) || se.Stage != "seg/check" {
+		t.Errorf("got error %v; wanted a *StageError from %q", err, "seg/check")
+	}
+
+	if err := q.AddPipeline("empty", NewFromFuncs(nil, nil)); err != ErrIsStarted {
+		t.Errorf("got error %v; wanted %v", err, ErrIsStarted)
+	}
+}
+
+func TestSharedCapacity(t *testing.T) {
+	var active, peak atomic.Int64
+
+	query := func(ctx context.Context, in any) (any, error) {
+		n := active.Add(1)
+		defer active.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		time.Sleep(200 * time.Microsecond)
+		return in, nil
+	}
+
+	in := make([]int, 50)
+	for i := range in {
+		in[i] = i
+	}
+
+	db := waypoint.New(3)
+
+	var got []int
+	p := NewFromFuncs(FromSlice(in), ToSlice(&got))
+	p.Add("first", 4, query, WithSharedCapacity(db))
+	p.Add("second", 4, query, WithSharedCapacity(db))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(in) {
+		t.Errorf("got %d items; wanted %d", len(got), len(in))
+	}
+
+	if n := peak.Load(); n > 3 {
+		t.Errorf("peak concurrency was %d; wanted at most 3", n)
+	}
+
+	if m := db.Metrics(); m.Finished != 2*len(in) {
+		t.Errorf("shared Waypoint finished %d Workers; wanted %d", m.Finished, 2*len(in))
+	}
+}
+
+func TestAutoScale(t *testing.T) {
+	in := make([]int, 100)
+	for i := range in {
+		in[i] = i
+	}
+
+	var (
+		p    *Pipeline
+		peak atomic.Int64
+	)
+
+	slow := func(ctx context.Context, in any) (any, error) {
+		if sm, err := p.StageMetrics("slow"); err == nil && int64(sm.Waypoint.Capacity) > peak.Load() {
+			peak.Store(int64(sm.Waypoint.Capacity))
+		}
+		time.Sleep(2 * time.Millisecond)
+		return in, nil
+	}
+
+	policy := waypoint.ScalePolicy{
+		TargetWait: 100 * time.Microsecond,
+		Min:        1,
+		Max:        4,
+		Interval:   5 * time.Millisecond,
+	}
+
+	p = NewFromFuncs(FromSlice(in), ToSlice(new([]int)))
+	p.Add("slow", 1, slow, WithAutoScale(policy))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := peak.Load(); n < 2 || n > 4 {
+		t.Errorf("peak capacity was %d; wanted between 2 and 4", n)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var inflight, peak atomic.Int64
+
+	enter := func(ctx context.Context, in any) (any, error) {
+		n := inflight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		return in, nil
+	}
+
+	exit := func(ctx context.Context, in any) (any, error) {
+		time.Sleep(200 * time.Microsecond)
+		inflight.Add(-1)
+		return in, nil
+	}
+
+	var got int
+	collect := func(ctx context.Context, ch <-chan any) error {
+		for range ch {
+			got++
+		}
+		return nil
+	}
+
+	in := make([]int, 30)
+	p := NewFromFuncs(FromSlice(in), collect, WithBuffer(10), WithMaxInFlight(3))
+	p.Add("enter", 4, enter)
+	p.Add("exit", 4, exit)
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got != len(in) {
+		t.Errorf("collected %d items; wanted %d", got, len(in))
+	}
+
+	if n := peak.Load(); n > 3 {
+		t.Errorf("peak in-flight items was %d; wanted at most 3", n)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	var (
+		events []BackpressureEvent
+		mu     sync.Mutex
+	)
+
+	onEvent := func(ev BackpressureEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	}
+
+	fast := func(ctx context.Context, in any) (any, error) {
+		return in, nil
+	}
+
+	slow := func(ctx context.Context, in any) (any, error) {
+		time.Sleep(5 * time.Millisecond)
+		return in, nil
+	}
+
+	discard := func(ctx context.Context, ch <-chan any) error {
+		for range ch {
+		}
+		return nil
+	}
+
+	in := make([]int, 20)
+	p := NewFromFuncs(FromSlice(in), discard, WithBuffer(4), OnBackpressure(onEvent, time.Millisecond))
+	p.Add("fast", 1, fast)
+	p.Add("slow", 1, slow)
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m := p.Metrics()
+
+	if m.Bottleneck != "slow" {
+		t.Errorf("Bottleneck: got %q; wanted %q", m.Bottleneck, "slow")
+	}
+
+	fm, sm := m.Stages[0], m.Stages[1]
+
+	if fm.SendBlocked <= sm.SendBlocked {
+		t.Errorf("SendBlocked: fast stage %v <= slow stage %v", fm.SendBlocked, sm.SendBlocked)
+	}
+
+	if sm.QueueCap != 4 || sm.QueuePeak == 0 || sm.QueuePeak > 4 {
+		t.Errorf("slow stage queue: cap=%d peak=%d; wanted cap=4 and 0 < peak <= 4", sm.QueueCap, sm.QueuePeak)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var fromFast bool
+	for _, ev := range events {
+		fromFast = fromFast || ev.Stage == "fast"
+		if ev.Blocked < time.Millisecond {
+			t.Errorf("event below threshold: %+v", ev)
+		}
+	}
+
+	if !fromFast {
+		t.Errorf("no BackpressureEvents reported for fast stage: %+v", events)
+	}
+}
+
+func TestRunSequential(t *testing.T) {
+	ctx := context.Background()
+
+	// n.b. Since all stages run in a single goroutine, trace needs no lock.
+	var trace []string
+
+	record := func(name string, fn func(int) int) StageFunc {
+		return func(ctx context.Context, in any) (any, error) {
+			trace = append(trace, fmt.Sprintf("%s%d", name, in))
+			return fn(in.(int)), nil
+		}
+	}
+
+	t.Run("linear", func(t *testing.T) {
+		trace = nil
+
+		var got []any
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4, 5}), ToSlice(&got))
+		p.Add("a", 4, record("a", func(v int) int { return v }))
+		p.AddFlatMap("dup", 4, func(ctx context.Context, in any, emit func(any)) error {
+			emit(in)
+			emit(in.(int) + 100)
+			return nil
+		})
+		p.AddBatch("batch", 3, time.Hour)
+
+		if err := p.RunSequential(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		wantTrace := []string{"a1", "a2", "a3", "a4", "a5"}
+		if !slices.Equal(trace, wantTrace) {
+			t.Errorf("trace: got %v; wanted %v", trace, wantTrace)
+		}
+
+		want := []any{[]any{1, 101, 2}, []any{102, 3, 103}, []any{4, 104, 5}, []any{105}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("branch", func(t *testing.T) {
+		trace = nil
+
+		var got []int
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(&got))
+		p.AddBranch(
+			NewBranch().Add("x", 4, record("x", func(v int) int { return v * 2 })),
+			NewBranch().Add("y", 4, record("y", func(v int) int { return v * 3 })),
+		)
+		p.AddReduce("sum", 0, func(ctx context.Context, acc, in any) (any, error) {
+			return acc.(int) + in.(int), nil
+		})
+
+		if err := p.RunSequential(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		wantTrace := []string{"x1", "y1", "x2", "y2", "x3", "y3"}
+		if !slices.Equal(trace, wantTrace) {
+			t.Errorf("trace: got %v; wanted %v", trace, wantTrace)
+		}
+
+		if want := []int{30}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("router", func(t *testing.T) {
+		trace = nil
+
+		var got []int
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3, 4, 5, 6}), ToSlice(&got))
+		p.Add("even", 4, record("even", func(v int) int { return v * 10 }))
+		p.Add("odd", 4, record("odd", func(v int) int { return -v }))
+		p.AddRouter("route", "odd", Route{"even", func(v any) bool { return v.(int)%2 == 0 }})
+
+		if err := p.RunSequential(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		wantTrace := []string{"odd1", "even2", "odd3", "even4", "odd5", "even6"}
+		if !slices.Equal(trace, wantTrace) {
+			t.Errorf("trace: got %v; wanted %v", trace, wantTrace)
+		}
+
+		if want := []int{-1, 20, -3, 40, -5, 60}; !slices.Equal(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		errBoom := errors.New("boom")
+
+		p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(new([]int)))
+		p.Add("fail", 1, func(ctx context.Context, in any) (any, error) {
+			if in.(int) == 2 {
+				return nil, errBoom
+			}
+			return in, nil
+		})
+
+		if err := p.RunSequential(ctx); !errors.Is(err, errBoom) {
+			t.Errorf("got error %v; wanted %v", err, errBoom)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+
+	itoa := NewStage("itoa", 1, func(ctx context.Context, in int) (string, error) {
+		return strconv.Itoa(in), nil
+	})
+
+	double := NewStage("double", 1, func(ctx context.Context, in int) (int, error) {
+		return in * 2, nil
+	})
+
+	describe := NewStage("describe", 1, func(ctx context.Context, in fmt.Stringer) (string, error) {
+		return in.String(), nil
+	})
+
+	newPipeline := func() *Pipeline {
+		return NewFromFuncs(FromSlice([]int{1}), ToSlice(new([]string)))
+	}
+
+	t.Run("types", func(t *testing.T) {
+		p := newPipeline()
+		itoa.AddTo(p)
+		double.AddTo(p)
+
+		if err := p.Validate(); !errors.Is(err, ErrWrongType) {
+			t.Errorf("got error %v; wanted %v", err, ErrWrongType)
+		}
+
+		// An interface output may hold a compatible value...
+		p = newPipeline()
+		p.Add("any", 1, func(ctx context.Context, in any) (any, error) { return in, nil })
+		double.AddTo(p)
+		if err := p.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		// ...but an int can never be a fmt.Stringer.
+		p = newPipeline()
+		double.AddTo(p)
+		describe.AddTo(p)
+		if err := p.Validate(); !errors.Is(err, ErrWrongType) {
+			t.Errorf("got error %v; wanted %v", err, ErrWrongType)
+		}
+	})
+
+	t.Run("stages", func(t *testing.T) {
+		p := newPipeline()
+		p.Add("zero", 0, func(ctx context.Context, in any) (any, error) { return in, nil })
+
+		// n.b. A stage added with zero capacity may be resized once running.
+		if err := p.Validate(); err != nil {
+			t.Errorf("zero capacity: unexpected error: %v", err)
+		}
+
+		p = newPipeline()
+		p.Add("negative", -1, func(ctx context.Context, in any) (any, error) { return in, nil })
+		p.Add("nil", 1, nil)
+
+		err := p.Validate()
+		if !errors.Is(err, waypoint.ErrInvalidCapacity) || !errors.Is(err, ErrNoStageFunc) {
+			t.Errorf("got error %v; wanted both %v and %v", err, waypoint.ErrInvalidCapacity, ErrNoStageFunc)
+		}
+
+		if err := p.Run(ctx); !errors.Is(err, ErrNoStageFunc) {
+			t.Errorf("Run: got error %v; wanted %v", err, ErrNoStageFunc)
+		}
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		var fed, collected bool
+
+		feed := func(ctx context.Context, ch chan<- any) error {
+			fed = true
+			return nil
+		}
+
+		collect := func(ctx context.Context, ch <-chan any) error {
+			collected = true
+			for range ch {
+			}
+			return nil
+		}
+
+		p := NewFromFuncs(feed, collect)
+		double.AddTo(p)
+
+		out, err := p.DryRun(ctx, 21)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []any{42}; !slices.Equal(out, want) {
+			t.Errorf("got %v; wanted %v", out, want)
+		}
+
+		if fed || collected {
+			t.Errorf("Feed called: %t, Collect called: %t; wanted neither", fed, collected)
+		}
+
+		// More stages may be added after a dry run.
+		if err := itoa.AddTo(p); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := p.DryRun(ctx, "oops"); !errors.Is(err, ErrWrongType) {
+			t.Errorf("got error %v; wanted %v", err, ErrWrongType)
+		}
+
+		if err := p.Run(ctx); err != nil || !fed || !collected {
+			t.Errorf("Run: err=%v fed=%t collected=%t", err, fed, collected)
+		}
+	})
+}
+
+func TestDot(t *testing.T) {
+	identity := func(ctx context.Context, in any) (any, error) { return in, nil }
+
+	p := NewFromFuncs(FromSlice([]int{1}), ToSlice(new([]any)), WithName("demo"))
+	p.Add("parse", 2, identity)
+	p.AddBranch(
+		NewBranch().Add("x", 1, identity),
+		NewBranch().Add("y", 3, identity).Add(`y"2`, 1, identity),
+	)
+	p.AddBatch("batch", 10, 0)
+
+	want := `digraph "demo" {
+	rankdir=LR;
+	node [shape=box];
+	feed [label="Feed" shape=oval];
+	collect [label="Collect" shape=oval];
+	s0 [label="parse\ncapacity 2"];
+	subgraph cluster_1 {
+		label="branch";
+		s1 [label="x\ncapacity 1"];
+		s2 [label="y\ncapacity 3"];
+		s3 [label="y\"2\ncapacity 1"];
+	}
+	s4 [label="batch\nbatch of 10"];
+	s0 -> s1;
+	s0 -> s2;
+	s2 -> s3;
+	s1 -> s4;
+	s3 -> s4;
+	feed -> s0;
+	s4 -> collect;
+}
+`
+
+	if got := p.Dot(); got != want {
+		t.Errorf("got:\n%s\nwanted:\n%s", got, want)
+	}
+
+	r := NewFromFuncs(FromSlice([]int{1}), ToSlice(new([]any)))
+	r.Add("even", 1, identity)
+	r.Add("odd", 1, identity)
+	r.AddRouter("route", "odd", Route{"even", func(v any) bool { return v.(int)%2 == 0 }})
+
+	got := r.Dot()
+	for _, line := range []string{
+		`s2 [label="route\ncapacity 1" shape=diamond];`,
+		"s2 -> s0;", "s2 -> s1;", "feed -> s2;", "s0 -> collect;", "s1 -> collect;",
+	} {
+		if !strings.Contains(got, "\t"+line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, got)
+		}
+	}
+}
+
+func TestCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+
+	var (
+		cp   = new(memCheckpointer)
+		seen []int
+		fail = true
+	)
+
+	p := NewFromFuncs(FromSlice([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}), ToSlice(new([]any)), WithCheckpoints(cp, time.Hour))
+	p.Add("check", 1, func(ctx context.Context, in any) (any, error) {
+		if in.(int) == 7 && fail {
+			return nil, errBoom
+		}
+		seen = append(seen, in.(int))
+		return in, nil
+	})
+	p.AddFilter("evens", 1, func(ctx context.Context, in any) (bool, error) {
+		return in.(int)%2 == 0, nil
+	})
+
+	if _, err := p.DryRun(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if saves := cp.saves(); len(saves) != 0 {
+		t.Fatalf("DryRun saved %v; wanted nothing", saves)
+	}
+
+	seen = nil
+
+	// n.b. RunSequential ensures items are completed in order, but item 6
+	//      may still be on its way to Collect when item 7 fails.
+	if err := p.RunSequential(ctx); !errors.Is(err, errBoom) {
+		t.Fatalf("got error %v; wanted %v", err, errBoom)
+	}
+
+	saves := cp.saves()
+	if len(saves) != 1 || saves[0] < 6 || saves[0] > 7 {
+		t.Fatalf("first run saved %v; wanted [6] or [7]", saves)
+	}
+
+	first := int(saves[0])
+	seen, fail = nil, false
+
+	if err := p.RunSequential(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []int
+	for i := first; i < 10; i++ {
+		want = append(want, i)
+	}
+
+	if !slices.Equal(seen, want) {
+		t.Errorf("resumed run processed %v; wanted %v", seen, want)
+	}
+
+	if got := cp.saves(); !slices.Equal(got, []uint64{uint64(first), 10}) {
+		t.Errorf("saved %v; wanted [%d 10]", got, first)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+
+	in := make([]int, 50)
+	for i := range in {
+		in[i] = i
+	}
+
+	var out []any
+
+	slow := func(ctx context.Context, in any) (any, error) {
+		time.Sleep(time.Millisecond)
+		return in, nil
+	}
+
+	p := NewFromFuncs(FromSlice(in), ToSlice(&out))
+	p.Add("fast", 1, func(ctx context.Context, in any) (any, error) { return in, nil })
+	p.Add("slow", 1, slow, WithSpill(2, dir, JSONCodec[int]()))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != len(in) {
+		t.Fatalf("got %d items; wanted %d", len(out), len(in))
+	}
+
+	for i, v := range out {
+		if v != i {
+			t.Fatalf("item %d: got %v; wanted %d", i, v, i)
+		}
+	}
+
+	if n := p.Metrics().Stages[1].Spilled; n == 0 {
+		t.Errorf("Spilled: got 0; wanted > 0")
+	}
+
+	if ents, err := os.ReadDir(dir); err != nil || len(ents) != 0 {
+		t.Errorf("spill directory: got %d entries (%v); wanted none", len(ents), err)
+	}
+}
+
+func TestItemDeadline(t *testing.T) {
+	var (
+		out     []any
+		letters []*StageError
+		mu      sync.Mutex
+	)
+
+	deadline := func(v any) time.Time {
+		switch v.(int) % 3 {
+		case 0:
+			return time.Now().Add(-time.Second) // already expired
+		case 1:
+			return time.Now().Add(20 * time.Millisecond) // expires while waiting
+		}
+		return time.Time{}
+	}
+
+	deadLetters := func(ctx context.Context, se *StageError) error {
+		mu.Lock()
+		defer mu.Unlock()
+		letters = append(letters, se)
+		return nil
+	}
+
+	wait := func(ctx context.Context, in any) (any, error) {
+		if in.(int)%3 == 0 {
+			t.Errorf("StageFunc called for expired item %v", in)
+		}
+
+		if _, ok := ctx.Deadline(); !ok {
+			return in, nil
+		}
+
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	p := NewFromFuncs(FromSlice([]int{0, 1, 2, 3, 4, 5}), ToSlice(&out),
+		WithItemDeadline(deadline), WithDeadLetters(deadLetters))
+
+	p.Add("wait", 6, wait)
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.SortFunc(out, func(a, b any) int { return a.(int) - b.(int) })
+	if want := []any{2, 5}; !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v; wanted %v", out, want)
+	}
+
+	sm := p.Metrics().Stages[0]
+	if sm.Expired != 4 || sm.Skipped != 4 || sm.Errors != 0 {
+		t.Errorf("metrics: Expired=%d Skipped=%d Errors=%d; wanted 4, 4 and 0", sm.Expired, sm.Skipped, sm.Errors)
+	}
+
+	if len(letters) != 4 {
+		t.Fatalf("got %d dead letters; wanted 4", len(letters))
+	}
 
-	p.Add("stage1", 50, tt.stage1)
+	for _, se := range letters {
+		if !errors.Is(se, ErrExpired) {
+			t.Errorf("dead letter for %v: got %v; wanted ErrExpired", se.Input, se.Err)
+		}
+	}
+}
 
-	if err := p.Run(ctx); err != nil {
+func TestPriority(t *testing.T) {
+	var (
+		out     []any
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+
+	feed := func(ctx context.Context, ch chan<- any) error {
+		for _, v := range []int{0, 1, 2, 3, 4, 100, 101, 5, 102} {
+			if err := Send(ctx, v, ch); err != nil {
+				return err
+			}
+
+			if v == 0 {
+				<-started
+			}
+		}
+
+		// n.b. Give the stage time to receive what we've sent.
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+
+		return nil
+	}
+
+	gate := func(ctx context.Context, in any) (any, error) {
+		if in == 0 {
+			close(started)
+			<-release
+		}
+
+		if want := in.(int) / 100; ItemFrom(ctx).Priority != want {
+			t.Errorf("item %v: got priority %d; wanted %d", in, ItemFrom(ctx).Priority, want)
+		}
+
+		return in, nil
+	}
+
+	p := NewFromFuncs(feed, ToSlice(&out), WithBuffer(16), WithPriority(func(v any) int { return v.(int) / 100 }))
+	p.Add("gate", 1, gate)
+
+	if err := p.Run(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
-	var smin, smax, emin, emax time.Time
+	// n.b. While item 0 is processed, the stage chooses the next item (and
+	//      waits for capacity) as soon as one arrives, so that item might be
+	//      either 1 or 100; all others are dispatched by priority.
+	want := [][]any{
+		{0, 1, 100, 101, 102, 2, 3, 4, 5},
+		{0, 100, 101, 102, 1, 2, 3, 4, 5},
+	}
 
-	for i, r := range tt.output {
-		if got, want := r.value, i+1; got != want {
-			t.Errorf("record %d beget %d; wanted %d", i, got, want)
+	if !reflect.DeepEqual(out, want[0]) && !reflect.DeepEqual(out, want[1]) {
+		t.Errorf("got %v; wanted %v or %v", out, want[0], want[1])
+	}
+}
+
+func TestSampling(t *testing.T) {
+	in := make([]int, 10)
+	for i := range in {
+		in[i] = i
+	}
+
+	var (
+		out []any
+		buf bytes.Buffer
+		mu  sync.Mutex
+	)
+
+	logger := slog.New(slog.NewJSONHandler(&lockedWriter{&buf, &mu}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	p := NewFromFuncs(FromSlice(in), ToSlice(&out), WithDebugSampling(logger, func(v any) bool { return v == 3 }))
+	p.Add("double", 2, func(ctx context.Context, in any) (any, error) { return in.(int) * 2, nil })
+	p.AddSample("sample", SampleEvery(3))
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.SortFunc(out, func(a, b any) int { return a.(int) - b.(int) })
+	if len(out) != 4 {
+		t.Errorf("got %d sampled items (%v); wanted 4", len(out), out)
+	}
+
+	if sm := p.Metrics().Stages[1]; sm.Skipped != 6 {
+		t.Errorf("sample stage: got %d skipped; wanted 6", sm.Skipped)
+	}
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("bad log record %q: %v", line, err)
 		}
+		records = append(records, rec)
+	}
 
-		if smin.IsZero() || r.start.Before(smin) {
-			smin = r.start
+	if len(records) != 2 {
+		t.Fatalf("got %d log records; wanted 2:\n%s", len(records), buf.String())
+	}
+
+	if r := records[0]; r["stage"] != "double" || r["input"] != 3.0 || r["output"] != 6.0 {
+		t.Errorf("first record: got %v", r)
+	}
+
+	if r := records[1]; r["stage"] != "sample" || r["input"] != 6.0 {
+		t.Errorf("second record: got %v", r)
+	}
+
+	for _, rate := range []float64{0, 1} {
+		keep := SampleRate(rate)
+		for i := range 100 {
+			if got := keep(i); got != (rate == 1) {
+				t.Fatalf("SampleRate(%v): got %v for item %d", rate, got, i)
+			}
 		}
+	}
+}
 
-		if emin.IsZero() || r.stop.Before(emin) {
-			emin = r.stop
+func TestRunDirect(t *testing.T) {
+	type point struct{ X, Y int }
+
+	scale := NewStage("scale", 4, func(ctx context.Context, p point) (point, error) {
+		if p.X%5 == 0 {
+			return p, ErrDrop
 		}
+		return point{p.X * 2, p.Y * 2}, nil
+	})
 
-		if smax.IsZero() || r.start.After(smax) {
-			smax = r.start
+	var states atomic.Int32
+	norm := NewStatefulStage("norm", 2, func() *int { states.Add(1); return new(int) },
+		func(ctx context.Context, n *int, p point) (int, error) {
+			*n++
+			return p.X + p.Y, nil
+		})
+
+	sum := Reduce("sum", 0, func(ctx context.Context, acc, v int) (int, error) {
+		return acc + v, nil
+	})
+
+	stages := Then(Then(scale, norm), sum)
+
+	feed := func(ctx context.Context, ch chan<- point) error {
+		for i := range 10 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- point{i, 1}:
+			}
+		}
+		return nil
+	}
+
+	var got []int
+	collect := func(ctx context.Context, ch <-chan int) error {
+		for v := range ch {
+			got = append(got, v)
 		}
+		return nil
+	}
 
-		if emax.IsZero() || r.stop.After(emax) {
-			emax = r.stop
+	if err := RunDirect(context.Background(), stages, feed, collect); err != nil {
+		t.Fatal(err)
+	}
+
+	// n.b. Items 0 and 5 are dropped; the rest are (2i + 2).
+	if want := []int{2*(1+2+3+4+6+7+8+9) + 2*8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+
+	if n := states.Load(); n != 2 {
+		t.Errorf("got %d states; wanted 2", n)
+	}
+
+	boom := errors.New("boom")
+	failing := Then(scale, NewStage("fail", 1, func(ctx context.Context, p point) (point, error) {
+		if p.X == 6 {
+			return p, boom
 		}
+		return p, nil
+	}))
+
+	err := RunDirect(context.Background(), failing, feed, func(ctx context.Context, ch <-chan point) error {
+		for range ch {
+		}
+		return nil
+	})
+
+	var se *StageError
+	if !errors.As(err, &se) || se.Stage != "fail" || !errors.Is(err, boom) || se.Input != (point{6, 2}) {
+		t.Errorf("got error %v; wanted a *StageError from stage \"fail\" for {6 2}", err)
 	}
+}
 
-	want := tt.tolerance(12.5)
+type benchPoint struct{ X, Y, Z float64 }
 
-	if srange := smax.Sub(smin); srange > want {
-		t.Errorf("Start range too wide: got %v; wanted %v", srange, want)
-	} else {
-		t.Logf("All workers began between %s and %s: %v", smin.Format(tf), smax.Format(tf), srange)
+// benchStages returns the Stage used by BenchmarkRun and BenchmarkRunDirect:
+// a chain of small transformations on a small struct.
+func benchStages() Stage[benchPoint, benchPoint] {
+	move := NewStage("move", 1, func(ctx context.Context, p benchPoint) (benchPoint, error) {
+		return benchPoint{p.X + 1, p.Y + 1, p.Z + 1}, nil
+	})
+
+	scale := NewStage("scale", 1, func(ctx context.Context, p benchPoint) (benchPoint, error) {
+		return benchPoint{p.X * 2, p.Y * 2, p.Z * 2}, nil
+	})
+
+	shift := NewStage("shift", 1, func(ctx context.Context, p benchPoint) (benchPoint, error) {
+		return benchPoint{p.Y, p.Z, p.X}, nil
+	})
+
+	return Then(Then(move, scale), shift)
+}
+
+func benchFeed(n int) func(context.Context, chan<- benchPoint) error {
+	return func(ctx context.Context, ch chan<- benchPoint) error {
+		for i := range n {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- benchPoint{float64(i), 0, 0}:
+			}
+		}
+		return nil
 	}
+}
 
-	if erange := emax.Sub(emin); erange > want {
-		t.Errorf("End range too wide: got %v; wanted %v", erange, want)
-	} else {
-		t.Logf("All workers ended between %s and %s: %v", emin.Format(tf), emax.Format(tf), erange)
+func benchCollect(ctx context.Context, ch <-chan benchPoint) error {
+	for range ch {
+	}
+	return nil
+}
+
+func BenchmarkRun(b *testing.B) {
+	b.ReportAllocs()
+
+	if err := Run(context.Background(), benchStages(), benchFeed(b.N), benchCollect); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkRunDirect(b *testing.B) {
+	b.ReportAllocs()
+
+	if err := RunDirect(context.Background(), benchStages(), benchFeed(b.N), benchCollect); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkPooling(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"default", []Option{WithMetadata(nil)}},
+		{"pooled", []Option{WithMetadata(nil), WithPooling(nil)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			if err := Run(context.Background(), benchStages(), benchFeed(b.N), benchCollect, bc.opts...); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPooling(t *testing.T) {
+	in := make([]int, 200)
+	for i := range in {
+		in[i] = i
+	}
+
+	var (
+		out      []any
+		released []any
+		mu       sync.Mutex
+	)
+
+	release := func(v any) {
+		mu.Lock()
+		defer mu.Unlock()
+		released = append(released, v)
+	}
+
+	metaFunc := func(v any) Metadata {
+		return Metadata{"item": strconv.Itoa(v.(int))}
+	}
+
+	check := func(ctx context.Context, in any) (any, error) {
+		info := ItemFrom(ctx)
+		if got, want := info.Metadata["item"], strconv.Itoa(in.(int)); got != want {
+			t.Errorf("item %v: got metadata %q; wanted %q", in, got, want)
+		}
+
+		if info.Worker == 0 {
+			t.Errorf("item %v: got no Worker", in)
+		}
+
+		SetMetadata(ctx, "checked", "yes")
+		return in, nil
+	}
+
+	p := NewFromFuncs(FromSlice(in), ToSlice(&out), WithMetadata(metaFunc), WithPooling(release))
+	p.Add("check", 4, check)
+	p.AddFilter("even", 4, func(ctx context.Context, in any) (bool, error) {
+		if ItemFrom(ctx).Metadata["checked"] != "yes" {
+			t.Errorf("item %v: metadata not carried between stages", in)
+		}
+		return in.(int)%2 == 0, nil
+	})
+	p.Add("again", 4, check)
+
+	for range 2 {
+		out, released = nil, nil
+
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(out) != 100 || len(released) != 100 {
+			t.Fatalf("got %d items and %d released; wanted 100 of each", len(out), len(released))
+		}
+
+		for _, v := range released {
+			if v.(int)%2 == 0 {
+				t.Errorf("released item %v was not dropped", v)
+			}
+		}
+	}
+}
+
+func TestPoolingFailed(t *testing.T) {
+	errOdd := errors.New("odd value")
+
+	in := make([]int, 10)
+	for i := range in {
+		in[i] = i
+	}
+
+	var (
+		out      []any
+		released []any
+		mu       sync.Mutex
+	)
+
+	release := func(v any) {
+		mu.Lock()
+		defer mu.Unlock()
+		released = append(released, v)
+	}
+
+	p := NewFromFuncs(FromSlice(in), ToSlice(&out), WithMetadata(nil), WithPooling(release), ContinueOnError(10))
+	p.Add("evens", 2, func(ctx context.Context, in any) (any, error) {
+		if in.(int)%2 != 0 {
+			return nil, errOdd
+		}
+		return in, nil
+	})
+
+	var er *ErrorReport
+	if err := p.Run(context.Background()); !errors.As(err, &er) || er.Total != 5 {
+		t.Fatalf("got error %v; wanted an *ErrorReport with 5 failures", err)
+	}
+
+	if len(out) != 5 {
+		t.Errorf("got %d items; wanted 5", len(out))
+	}
+
+	// n.b. Failed items are still referenced by their StageErrors.
+	if len(released) != 0 {
+		t.Errorf("got %v released; wanted none", released)
+	}
+
+	for _, se := range er.Samples {
+		if v, ok := se.Input.(int); !ok || v%2 == 0 {
+			t.Errorf("got StageError input %v; wanted an odd int", se.Input)
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	var events []LifecycleEvent
+
+	identity := func(ctx context.Context, in any) (any, error) { return in, nil }
+
+	record := func(ev LifecycleEvent) {
+		events = append(events, ev)
+	}
+
+	kinds := func() map[string]LifecycleEventType {
+		got := make(map[string]LifecycleEventType)
+		for _, ev := range events {
+			if ev.Stage != "" && ev.Type != StageStarted {
+				got[ev.Stage] = ev.Type
+			}
+		}
+		return got
+	}
+
+	check := func(wantErr error) {
+		t.Helper()
+
+		if len(events) < 2 || events[0].Type != PipelineStarted || events[len(events)-1].Type != PipelineFinished {
+			t.Fatalf("got events %v; wanted PipelineStarted ... PipelineFinished", events)
+		}
+
+		if err := events[len(events)-1].Err; !errors.Is(err, wantErr) || (wantErr == nil) != (err == nil) {
+			t.Errorf("PipelineFinished: got error %v; wanted %v", err, wantErr)
+		}
+
+		for _, ev := range events {
+			if ev.Pipeline != "life" {
+				t.Errorf("%s event: got pipeline %q; wanted %q", ev.Type, ev.Pipeline, "life")
+			}
+		}
+	}
+
+	var out []any
+	p := NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(&out), WithName("life"), OnLifecycle(record))
+	p.Add("first", 2, identity)
+	p.Add("second", 2, identity)
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	check(nil)
+
+	if len(events) != 6 {
+		t.Errorf("got %d events; wanted 6: %v", len(events), events)
+	}
+
+	if got, want := kinds(), map[string]LifecycleEventType{"first": StageDrained, "second": StageDrained}; !maps.Equal(got, want) {
+		t.Errorf("got stage outcomes %v; wanted %v", got, want)
+	}
+
+	errFail := errors.New("fail")
+
+	events = nil
+	ch := make(chan LifecycleEvent, 10)
+
+	p = NewFromFuncs(FromSlice([]int{1, 2, 3}), ToSlice(&out), WithName("life"), OnLifecycle(LifecycleChan(ch)))
+	p.Add("first", 1, identity)
+	p.Add("broken", 1, func(context.Context, any) (any, error) {
+		return nil, errFail
+	})
+	p.Add("last", 1, identity)
+
+	if err := p.Run(context.Background()); !errors.Is(err, errFail) {
+		t.Fatalf("got error %v; wanted %v", err, errFail)
+	}
+
+	close(ch)
+	for ev := range ch {
+		events = append(events, ev)
+	}
+
+	check(errFail)
+
+	got := kinds()
+	if got["broken"] != StageFailed {
+		t.Errorf("stage broken: got %v; wanted %v", got["broken"], StageFailed)
+	}
+
+	for _, name := range []string{"first", "last"} {
+		if got[name] != StageCanceled && got[name] != StageDrained {
+			t.Errorf("stage %s: got %v; wanted %v or %v", name, got[name], StageCanceled, StageDrained)
+		}
+	}
+
+	for _, ev := range events {
+		if ev.Type == StageFailed && !errors.Is(ev.Err, errFail) {
+			t.Errorf("StageFailed: got error %v; wanted %v", ev.Err, errFail)
+		}
+	}
+}
+
+//╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴╶╴
+
+// failThing feeds count ints and then returns err.
+type failThing struct {
+	count     int
+	err       error
+	collected []int
+}
+
+func (ft *failThing) Feed(ctx context.Context, ch chan<- any) error {
+	for i := 0; i < ft.count; i++ {
+		if err := Send(ctx, i, ch); err != nil {
+			return err
+		}
+	}
+
+	return ft.err
+}
+
+func (ft *failThing) Collect(ctx context.Context, ch <-chan any) error {
+	for {
+		v, ok, err := Recv[int](ctx, ch)
+		if err != nil || !ok {
+			return err
+		}
+
+		ft.collected = append(ft.collected, v)
 	}
 }
 
@@ -96,8 +3586,6 @@ func (tt *testThing) tolerance(pct float64) time.Duration {
 // · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · · ·
 
 func (tt *testThing) Feed(ctx context.Context, ch chan<- any) error {
-	defer close(ch)
-
 	for _, v := range tt.input {
 		if err := Send[int](ctx, v, ch); err != nil {
 			return err
@@ -160,3 +3648,133 @@ const tf = "15:04:05.000000"
 func (r record) String() string {
 	return fmt.Sprintf("%s %3d - %-3d %v", r.start.Format(tf), r.orig, r.value, r.stop.Sub(r.start).Round(time.Microsecond))
 }
+
+// fakeDriver is a database/sql driver whose every query returns the rows
+// 1, 2 and 3 in a single column.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error)             { return fakeConn{}, nil }
+func (fakeDriver) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (d fakeDriver) Driver() driver.Driver                      { return d }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("unsupported") }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return nil, errors.New("unsupported") }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return &fakeRows{}, nil }
+
+type fakeRows struct{ n int64 }
+
+func (*fakeRows) Columns() []string { return []string{"n"} }
+func (*fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n == 3 {
+		return io.EOF
+	}
+	r.n++
+	dest[0] = r.n
+	return nil
+}
+
+// fakeTracer is a Tracer that records every Span it creates.
+type fakeTracer struct {
+	spans []*fakeSpan
+	sync.Mutex
+}
+
+type fakeSpan struct {
+	name   string
+	parent *fakeSpan
+	ended  int
+	err    error
+	tracer *fakeTracer
+}
+
+type fakeSpanKey struct{}
+
+func (ft *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(fakeSpanKey{}).(*fakeSpan)
+	span := &fakeSpan{name: name, parent: parent, tracer: ft}
+
+	ft.Lock()
+	defer ft.Unlock()
+	ft.spans = append(ft.spans, span)
+
+	return context.WithValue(ctx, fakeSpanKey{}, span), span
+}
+
+func (fs *fakeSpan) End(err error) {
+	fs.tracer.Lock()
+	defer fs.tracer.Unlock()
+	fs.ended++
+	fs.err = err
+}
+
+// count returns the number of recorded Spans with the given name.
+func (ft *fakeTracer) count(name string) int {
+	n := 0
+	for _, s := range ft.spans {
+		if s.name == name {
+			n++
+		}
+	}
+	return n
+}
+
+// memCheckpointer is an in-memory Checkpointer that records each Save.
+// fixedClock is a waypoint.Clock that always returns the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time                  { return time.Time(c) }
+func (c fixedClock) Since(t time.Time) time.Duration { return time.Time(c).Sub(t) }
+
+type memCheckpointer struct {
+	saved []uint64
+	mu    sync.Mutex
+}
+
+func (c *memCheckpointer) Load(ctx context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.saved) == 0 {
+		return 0, nil
+	}
+
+	return c.saved[len(c.saved)-1], nil
+}
+
+func (c *memCheckpointer) Save(ctx context.Context, n uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.saved = append(c.saved, n)
+	return nil
+}
+
+func (c *memCheckpointer) saves() []uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.saved)
+}
+
+// lockedWriter serializes writes to an underlying io.Writer.
+type lockedWriter struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (lw *lockedWriter) Write(b []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(b)
+}
@@ -0,0 +1,58 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipelinetest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/pipeline"
+)
+
+// A Clock is a fake waypoint.Clock whose time changes only when it is
+// advanced. Passing a Clock to pipeline.WithClock allows tests to make exact
+// assertions about the timings reported by a Pipeline's Metrics.
+type Clock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+// NewClock returns a new Clock whose current time is start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the receiver's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Since returns the time elapsed on the receiver since t.
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Advance moves the receiver's current time forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Delay returns a StageOption that advances c by d each time the stage's
+// StageFunc is called, simulating a StageFunc that takes d to execute. The
+// stage's reported timings are exact only if it has no concurrency (e.g.
+// using RunSequential).
+func Delay(c *Clock, d time.Duration) pipeline.StageOption {
+	return pipeline.WithStageMiddleware(func(next pipeline.StageFunc) pipeline.StageFunc {
+		return func(ctx context.Context, in any) (any, error) {
+			c.Advance(d)
+			return next(ctx, in)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipelinetest
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/go-sage/synctools/pkg/pipeline"
+)
+
+// FailOn returns a StageOption that causes the stage to fail with err, rather
+// than calling its StageFunc, for each input item for which match returns
+// true. The resulting error is handled according to the stage's RetryPolicy
+// and ErrorPolicy just like one returned by the StageFunc itself.
+func FailOn(match func(item any) bool, err error) pipeline.StageOption {
+	return pipeline.WithStageMiddleware(func(next pipeline.StageFunc) pipeline.StageFunc {
+		return func(ctx context.Context, in any) (any, error) {
+			if match(in) {
+				return nil, err
+			}
+
+			return next(ctx, in)
+		}
+	})
+}
+
+// FailAt returns a StageOption that causes the nth call (counting from one,
+// and including retries) to the stage's StageFunc to fail with err instead.
+// Calls are counted over the life of the stage, across all Runs. Since the
+// items processed by a stage are not otherwise ordered, FailAt is best used
+// with RunSequential or a stage having a capacity of one.
+func FailAt(n int, err error) pipeline.StageOption {
+	var calls atomic.Int64
+
+	return pipeline.WithStageMiddleware(func(next pipeline.StageFunc) pipeline.StageFunc {
+		return func(ctx context.Context, in any) (any, error) {
+			if calls.Add(1) == int64(n) {
+				return nil, err
+			}
+
+			return next(ctx, in)
+		}
+	})
+}
+
+// Equal returns a function, suitable for use with FailOn, that reports
+// whether an item is deeply equal to v (see reflect.DeepEqual).
+func Equal(v any) func(item any) bool {
+	return func(item any) bool {
+		return reflect.DeepEqual(item, v)
+	}
+}
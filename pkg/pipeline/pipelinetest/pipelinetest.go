@@ -0,0 +1,90 @@
+// Copyright © 2024 Timothy E. Peoples
+
+// Package pipelinetest provides utilities for testing Pipelines and the
+// stages that make them up: a Harness that feeds a Pipeline from a fixture
+// slice and captures its output, StageOptions for injecting failures and a
+// Clock for controlling the passage of time.
+//
+// A typical test might look like:
+//
+//	h := pipelinetest.New[int, string](t, []int{1, 2, 3})
+//	h.Add("format", 1, format, pipelinetest.FailOn(pipelinetest.Equal(2), errBad))
+//
+//	out, err := h.RunSequential(ctx)
+//	// ...check out and err...
+//
+//	h.AssertCalls("format", 3)
+package pipelinetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-sage/synctools/pkg/pipeline"
+)
+
+// A Harness drives its embedded Pipeline using a fixture slice of input
+// items and captures the output items (of type Out) that reach Collect.
+// Stages are added to the Pipeline using its usual methods (e.g. Add).
+type Harness[In, Out any] struct {
+	*pipeline.Pipeline
+
+	tb     testing.TB
+	output []Out
+}
+
+// New returns a Harness whose Pipeline, created using the given Options,
+// is fed each of the items in input.
+func New[In, Out any](tb testing.TB, input []In, opts ...pipeline.Option) *Harness[In, Out] {
+	h := &Harness[In, Out]{tb: tb}
+	h.Pipeline = pipeline.NewFromFuncs(pipeline.FromSlice(input), h.collect, opts...)
+
+	return h
+}
+
+// collect is the Harness Pipeline's CollectFunc; it captures the items
+// emerging from a single Run, which must each be of type Out.
+func (h *Harness[In, Out]) collect(ctx context.Context, ch <-chan any) error {
+	h.output = nil
+	return pipeline.ToSlice(&h.output)(ctx, ch)
+}
+
+// Run runs the receiver's Pipeline (see pipeline.Pipeline.Run) and returns
+// the output items it collected along with any error. Note that output items
+// are returned in the order they emerged from the Pipeline, which may differ
+// from the order of their inputs; use RunSequential if this matters.
+func (h *Harness[In, Out]) Run(ctx context.Context) ([]Out, error) {
+	err := h.Pipeline.Run(ctx)
+	return h.output, err
+}
+
+// RunSequential is like Run but uses pipeline.Pipeline.RunSequential to run
+// the receiver's Pipeline deterministically.
+func (h *Harness[In, Out]) RunSequential(ctx context.Context) ([]Out, error) {
+	err := h.Pipeline.RunSequential(ctx)
+	return h.output, err
+}
+
+// Calls returns the number of input items passed to the StageFunc of the
+// receiver's stage with the given name (not counting retries), failing the
+// test if there is no such stage.
+func (h *Harness[In, Out]) Calls(stage string) uint64 {
+	h.tb.Helper()
+
+	sm, err := h.StageMetrics(stage)
+	if err != nil {
+		h.tb.Fatalf("stage %q: %v", stage, err)
+	}
+
+	return sm.In
+}
+
+// AssertCalls reports a test error unless the receiver's stage with the
+// given name has been passed exactly want input items (see Calls).
+func (h *Harness[In, Out]) AssertCalls(stage string, want uint64) {
+	h.tb.Helper()
+
+	if got := h.Calls(stage); got != want {
+		h.tb.Errorf("stage %q: called for %d items; wanted %d", stage, got, want)
+	}
+}
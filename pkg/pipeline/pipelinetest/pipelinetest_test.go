@@ -0,0 +1,112 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipelinetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/pipeline"
+)
+
+func TestHarness(t *testing.T) {
+	ctx := context.Background()
+
+	format := func(ctx context.Context, in any) (any, error) {
+		return strconv.Itoa(in.(int)), nil
+	}
+
+	t.Run("output", func(t *testing.T) {
+		h := New[int, string](t, []int{1, 2, 3})
+		h.AddFilter("odd", 1, func(ctx context.Context, in any) (bool, error) {
+			return in.(int)%2 == 1, nil
+		})
+		h.Add("format", 1, format)
+
+		// n.b. Each Run must capture only its own output.
+		for range 2 {
+			got, err := h.RunSequential(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := []string{"1", "3"}; !slices.Equal(got, want) {
+				t.Errorf("got %q; wanted %q", got, want)
+			}
+		}
+
+		h.AssertCalls("odd", 6)
+		h.AssertCalls("format", 4)
+	})
+
+	t.Run("FailOn", func(t *testing.T) {
+		errBad := errors.New("bad item")
+
+		h := New[int, string](t, []int{1, 2, 3})
+		h.Add("format", 1, format, FailOn(Equal(2), errBad), pipeline.OnError(pipeline.Skip))
+
+		got, err := h.RunSequential(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"1", "3"}; !slices.Equal(got, want) {
+			t.Errorf("got %q; wanted %q", got, want)
+		}
+
+		if sm, _ := h.StageMetrics("format"); sm.Errors != 1 {
+			t.Errorf("stage errors: got %d; wanted 1", sm.Errors)
+		}
+	})
+
+	t.Run("FailAt", func(t *testing.T) {
+		errFlaky := errors.New("flaky")
+
+		h := New[int, string](t, []int{1, 2, 3})
+		h.Add("format", 1, format, FailAt(2, errFlaky), pipeline.WithRetry(pipeline.RetryPolicy{MaxAttempts: 2}))
+
+		got, err := h.RunSequential(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"1", "2", "3"}; !slices.Equal(got, want) {
+			t.Errorf("got %q; wanted %q", got, want)
+		}
+
+		h.AssertCalls("format", 3)
+
+		// Without a retry, the failure fails the Pipeline.
+		h = New[int, string](t, []int{1, 2, 3})
+		h.Add("format", 1, format, FailAt(3, errFlaky))
+
+		if _, err := h.RunSequential(ctx); !errors.Is(err, errFlaky) {
+			t.Errorf("got error %v; wanted %v", err, errFlaky)
+		}
+	})
+
+	t.Run("Clock", func(t *testing.T) {
+		start := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+		clock := NewClock(start)
+
+		h := New[int, string](t, []int{1, 2, 3, 4}, pipeline.WithClock(clock))
+		h.Add("format", 1, format, Delay(clock, time.Second))
+
+		if _, err := h.RunSequential(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		m := h.Metrics()
+		if !m.Timestamp.Equal(start.Add(4 * time.Second)) {
+			t.Errorf("Timestamp: got %v; wanted %v", m.Timestamp, start.Add(4*time.Second))
+		}
+
+		if sm := m.Stages[0]; sm.ProcessingTime != 4*time.Second || sm.Latency != time.Second {
+			t.Errorf("got ProcessingTime=%v Latency=%v; wanted 4s and 1s", sm.ProcessingTime, sm.Latency)
+		}
+	})
+}
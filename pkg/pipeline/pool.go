@@ -0,0 +1,155 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// WithPooling returns an Option that reduces the number of allocations made
+// for each item by a Pipeline whose items are wrapped in an envelope (i.e.
+// one created using NewAck or an Option such as WithMetadata). Each envelope,
+// along with the per-item state it carries (see ItemFrom), is recycled using
+// a sync.Pool once its item has been sent to Collect (for NewAck, once it has
+// been written to the AckSink and Ack'ed) or it is dropped by (or fails in) a
+// stage. The Context used to carry an item's state (and Worker) to each
+// StageFunc is likewise reused.
+//
+// If release is not nil, it is called with each item dropped by a stage (see
+// ErrDrop, Skip and WithItemDeadline) so that resources it holds (e.g. a
+// buffer taken from a pool of the caller's) may be reclaimed. It is not
+// called for items that reach Collect, which are the responsibility of
+// Collect, nor for items that fail under ContinueOnError, since each remains
+// referenced (as its Input) by the StageError recorded for it.
+//
+// Pooling trades safety for speed: the Context passed to a StageFunc for a
+// pooled item is part of the item's envelope and is overwritten by each
+// stage (and again once the envelope is reused), so a StageFunc must not
+// retain that Context (e.g. for use by a goroutine, or within its result)
+// after it returns. Doing so is a data race. Also note that stages added by
+// AddBatch, AddReduce or AddFlatMap, and those of a Branch, create envelopes
+// of their own, which are not recycled.
+func WithPooling(release func(v any)) Option {
+	return func(p *Pipeline) {
+		p.envelopes = &envelopePool{release: release}
+	}
+}
+
+// An envelopePool recycles the envelopes (and itemStates) created by
+// wrapItems and NewAck; see WithPooling. A nil *envelopePool allocates a new
+// envelope for each item and recycles nothing.
+type envelopePool struct {
+	pool    sync.Pool
+	release func(any)
+}
+
+// get returns an envelope carrying item, received at time now, along with a
+// new itemState.
+func (ep *envelopePool) get(item AckItem, now time.Time) *envelope {
+	if ep == nil {
+		return &envelope{AckItem: item, state: newItemState(item, now)}
+	}
+
+	m, _ := ep.pool.Get().(*envelope)
+	if m == nil {
+		m = &envelope{state: new(itemState), pool: ep}
+	}
+
+	m.AckItem = item
+	m.state.reset(item, now)
+
+	return m
+}
+
+// put returns m to the receiver if it was taken from it.
+func (ep *envelopePool) put(m *envelope) {
+	if ep == nil || m.pool != ep {
+		return
+	}
+
+	state := m.state
+	*m = envelope{state: state, pool: ep}
+
+	ep.pool.Put(m)
+}
+
+// drop is called for each item dropped by a stage, after it has been Ack'ed,
+// to pass its value to the receiver's release function and recycle its
+// envelope (if it is one).
+func (ep *envelopePool) drop(in any) {
+	if ep == nil {
+		return
+	}
+
+	m, ok := in.(*envelope)
+	if ok {
+		in = m.Value
+	}
+
+	if ep.release != nil {
+		ep.release(in)
+	}
+
+	if ok {
+		ep.put(m)
+	}
+}
+
+// discard is called for each item that failed in a stage, after it has been
+// Nack'ed, to recycle its envelope (if it is one). Unlike drop, its value is
+// not passed to the receiver's release function since it is still referred
+// to by the item's StageError.
+func (ep *envelopePool) discard(in any) {
+	if m, ok := in.(*envelope); ok {
+		ep.put(m)
+	}
+}
+
+// reset prepares the receiver, taken from an envelopePool, for a new item
+// received at time now; the storage for its Metadata is retained.
+func (is *itemState) reset(item AckItem, now time.Time) {
+	md := is.md
+	clear(md)
+
+	*is = itemState{
+		enqueued: now,
+		deadline: item.Deadline,
+		priority: item.Priority,
+		md:       md,
+	}
+
+	if len(item.Metadata) > 0 {
+		is.merge(item.Metadata)
+	}
+}
+
+// A stateCtx is the Context used to process the value of a pooled envelope
+// (see context); it is reused by each stage since an envelope is processed
+// by only one stage at a time.
+type stateCtx struct {
+	context.Context
+	state  *itemState
+	values context.Context  // see AckItem.Context
+	worker *waypoint.Worker // see withWorker
+}
+
+func (c *stateCtx) Value(key any) any {
+	switch {
+	case key == (itemKey{}):
+		return c.state
+	case key == (workerKey{}) && c.worker != nil:
+		return c.worker
+	}
+
+	if c.values != nil {
+		if v := c.values.Value(key); v != nil {
+			return v
+		}
+	}
+
+	return c.Context.Value(key)
+}
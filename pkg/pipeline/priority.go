@@ -0,0 +1,134 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+)
+
+// WithPriority returns an Option that causes each stage to dispatch the items
+// waiting on its input channel in order of priority (highest first) rather
+// than in the order they arrived; items having the same priority are
+// dispatched in order. This allows, for example, interactive requests to
+// overtake a backlog of batch work flowing through the same Pipeline.
+//
+// If fn is not nil, it is called with each item received from Feed to provide
+// its priority. Otherwise, for a Pipeline created using NewAck, each item has
+// the Priority given by its AckItem; all other items have a priority of zero.
+// An item's priority is available to each StageFunc using ItemFrom.
+//
+// Note that a stage chooses only among the items already waiting for it, so
+// WithPriority has little effect unless the Pipeline's channels are buffered
+// (see WithBuffer); a stage holds up to as many items as its buffer size
+// while choosing. The chosen item then waits for the stage's capacity even
+// if an item of higher priority arrives meanwhile. Stages added by AddBatch
+// or AddReduce receive items in the order they arrive, as do all stages when
+// using RunSequential. This Option has the same per-item overhead as
+// WithMetadata.
+func WithPriority(fn func(v any) int) Option {
+	return func(p *Pipeline) {
+		p.prioritize = true
+		p.prioFunc = fn
+	}
+}
+
+// priorityOf returns the priority of item v (see WithPriority).
+func priorityOf(v any) int {
+	if m, ok := v.(*envelope); ok && m.state != nil {
+		return m.state.priority
+	}
+
+	return 0
+}
+
+// A priorityQueue holds the items received by a stage, from its input channel,
+// that have yet to be dispatched (see WithPriority). It implements
+// heap.Interface; its methods should not be called directly.
+type priorityQueue struct {
+	items []prioritized
+	limit int
+	seq   uint64
+}
+
+type prioritized struct {
+	value    any
+	priority int
+	seq      uint64 // n.b. preserves arrival order for equal priorities
+}
+
+// newPriorityQueue returns a priorityQueue holding up to limit items (or at
+// least one).
+func newPriorityQueue(limit int) *priorityQueue {
+	return &priorityQueue{limit: max(limit, 1)}
+}
+
+// recv returns the item of highest priority from among those held by the
+// receiver and any that are immediately available from in. It blocks only
+// when the receiver is empty, returning false once in is closed. A nil
+// receiver simply receives the next item from in.
+func (pq *priorityQueue) recv(ctx context.Context, in <-chan any) (any, bool, error) {
+	if pq == nil {
+		return Recv[any](ctx, in)
+	}
+
+	if len(pq.items) == 0 {
+		v, ok, err := Recv[any](ctx, in)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+
+		pq.add(v)
+	}
+
+drain:
+	for len(pq.items) < pq.limit {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				break drain
+			}
+			pq.add(v)
+
+		default:
+			break drain
+		}
+	}
+
+	return heap.Pop(pq).(prioritized).value, true, nil
+}
+
+func (pq *priorityQueue) add(v any) {
+	pq.seq++
+	heap.Push(pq, prioritized{v, priorityOf(v), pq.seq})
+}
+
+func (pq *priorityQueue) Len() int {
+	return len(pq.items)
+}
+
+func (pq *priorityQueue) Less(i, j int) bool {
+	a, b := &pq.items[i], &pq.items[j]
+
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+
+	return a.seq < b.seq
+}
+
+func (pq *priorityQueue) Swap(i, j int) {
+	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+}
+
+func (pq *priorityQueue) Push(x any) {
+	pq.items = append(pq.items, x.(prioritized))
+}
+
+func (pq *priorityQueue) Pop() any {
+	n := len(pq.items) - 1
+	x := pq.items[n]
+	pq.items[n] = prioritized{}
+	pq.items = pq.items[:n]
+	return x
+}
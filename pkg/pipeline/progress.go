@@ -0,0 +1,102 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// A ProgressReport is passed to the function registered using OnProgress.
+type ProgressReport struct {
+	State                 // Item counts for the Pipeline and its stages
+	Elapsed time.Duration // Time since the Pipeline started running
+}
+
+// OnProgress returns an Option that causes fn to be called with a
+// ProgressReport each time another `every` items have been received from
+// Feed and/or every interval while the Pipeline is running; a zero value
+// for either disables that trigger (if both are zero, an interval of one
+// second is used). A final report (with its Final field set) is made once
+// Collect returns successfully.
+//
+// Note that fn is called by the goroutine relaying items from Feed to the
+// Pipeline's first stage and should therefore return quickly.
+func OnProgress(fn func(ProgressReport), every int, interval time.Duration) Option {
+	if every <= 0 && interval <= 0 {
+		interval = time.Second
+	}
+
+	return func(p *Pipeline) {
+		p.progress = &progress{fn, every, interval}
+	}
+}
+
+type progress struct {
+	fn       func(ProgressReport)
+	every    int
+	interval time.Duration
+}
+
+// progressFunc returns an errgroupx.ContextFunc that relays items from in
+// to out while reporting the receiver's progress (see OnProgress) until
+// either ctx is canceled or the collected channel is closed (which triggers
+// a final report).
+func (p *Pipeline) progressFunc(in <-chan any, out chan<- any, collected <-chan struct{}) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer func() {
+			if in != nil {
+				close(out)
+			}
+		}()
+
+		var tick <-chan time.Time
+		if p.progress.interval > 0 {
+			ticker := time.NewTicker(p.progress.interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		var (
+			start = p.clock.Now()
+			fed   uint64
+		)
+
+		report := func(final bool) {
+			st := p.state(final)
+			st.Fed = fed
+			p.progress.fn(ProgressReport{st, p.clock.Since(start)})
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+
+			case <-collected:
+				report(true)
+				return nil
+
+			case <-tick:
+				report(false)
+
+			case v, ok := <-in:
+				if !ok {
+					close(out)
+					in = nil
+					continue
+				}
+
+				if err := Send(ctx, v, out); err != nil {
+					return err
+				}
+
+				if fed++; p.progress.every > 0 && fed%uint64(p.progress.every) == 0 {
+					report(false)
+				}
+			}
+		}
+	}
+}
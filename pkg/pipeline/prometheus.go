@@ -0,0 +1,153 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// WithHistograms returns a StageOption that causes the stage's Waypoint to
+// track the distribution of item wait and active durations using the given
+// bucket boundaries (or waypoint.DefaultBuckets if none are provided). The
+// resulting Histograms are reported by Metrics and WritePrometheus. See
+// waypoint.WithHistograms for details.
+func WithHistograms(bounds ...time.Duration) StageOption {
+	return func(s *stage) {
+		s.wpopts = append(s.wpopts, waypoint.WithHistograms(bounds...))
+	}
+}
+
+// WritePrometheus writes the current Metrics for each of the provided
+// Pipelines to w using the Prometheus text exposition format. Each metric
+// is labeled with the names of the Pipeline (see WithName) and stage. This
+// package intentionally avoids depending upon the Prometheus client library;
+// instead, the output of WritePrometheus may be served directly (see
+// PrometheusHandler) or the values reported by Metrics may be exported using
+// a custom prometheus.Collector.
+//
+// The following metrics are written:
+//
+//	pipeline_stage_items_in_total             counter
+//	pipeline_stage_items_out_total            counter
+//	pipeline_stage_errors_total               counter
+//	pipeline_stage_skipped_total              counter
+//	pipeline_stage_expired_total              counter
+//	pipeline_stage_capacity                   gauge
+//	pipeline_stage_in_flight                  gauge
+//	pipeline_stage_waiting                    gauge
+//	pipeline_stage_wait_seconds_total         counter
+//	pipeline_stage_processing_seconds_total   counter
+//	pipeline_stage_send_blocked_seconds_total counter
+//	pipeline_stage_queue_length               gauge
+//	pipeline_stage_wait_seconds               histogram (see WithHistograms)
+//	pipeline_stage_active_seconds             histogram (see WithHistograms)
+func WritePrometheus(w io.Writer, pipelines ...*Pipeline) error {
+	snaps := make([]Metrics, len(pipelines))
+	for i, p := range pipelines {
+		snaps[i] = p.Metrics()
+	}
+
+	var buf bytes.Buffer
+
+	for _, f := range promFamilies {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+		for _, m := range snaps {
+			for _, sm := range m.Stages {
+				labels := fmt.Sprintf(`pipeline="%s",stage="%s"`, promEscaper.Replace(m.Name), promEscaper.Replace(sm.Name))
+
+				if f.value != nil {
+					fmt.Fprintf(&buf, "%s{%s} %s\n", f.name, labels, promFloat(f.value(sm)))
+				} else if h := f.hist(sm); h != nil {
+					writePromHistogram(&buf, f.name, labels, h)
+				}
+			}
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// PrometheusHandler returns an http.Handler that serves the output of
+// WritePrometheus for the provided Pipelines.
+func PrometheusHandler(pipelines ...*Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		if err := WritePrometheus(w, pipelines...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// promFamily describes a single Prometheus metric family; exactly one of
+// value or hist is non-nil.
+type promFamily struct {
+	name  string
+	help  string
+	kind  string
+	value func(StageMetrics) float64
+	hist  func(StageMetrics) *waypoint.Histogram
+}
+
+var promFamilies = []promFamily{
+	{"pipeline_stage_items_in_total", "Items passed to the stage's StageFunc.", "counter",
+		func(sm StageMetrics) float64 { return float64(sm.In) }, nil},
+	{"pipeline_stage_items_out_total", "Items successfully returned by the stage's StageFunc.", "counter",
+		func(sm StageMetrics) float64 { return float64(sm.Out) }, nil},
+	{"pipeline_stage_errors_total", "Items for which the stage's StageFunc returned an error.", "counter",
+		func(sm StageMetrics) float64 { return float64(sm.Errors) }, nil},
+	{"pipeline_stage_skipped_total", "Items dropped by the stage.", "counter",
+		func(sm StageMetrics) float64 { return float64(sm.Skipped) }, nil},
+	{"pipeline_stage_expired_total", "Items dropped by the stage since their deadline had passed.", "counter",
+		func(sm StageMetrics) float64 { return float64(sm.Expired) }, nil},
+	{"pipeline_stage_capacity", "Current capacity of the stage.", "gauge",
+		func(sm StageMetrics) float64 { return float64(sm.Waypoint.Capacity) }, nil},
+	{"pipeline_stage_in_flight", "Items currently being processed by the stage.", "gauge",
+		func(sm StageMetrics) float64 { return float64(sm.Waypoint.Active) }, nil},
+	{"pipeline_stage_waiting", "Items currently waiting for stage capacity.", "gauge",
+		func(sm StageMetrics) float64 { return float64(sm.Waypoint.Waiting) }, nil},
+	{"pipeline_stage_wait_seconds_total", "Total time items spent waiting for stage capacity.", "counter",
+		func(sm StageMetrics) float64 { return sm.Waypoint.WaitTime.Seconds() }, nil},
+	{"pipeline_stage_processing_seconds_total", "Total time spent in the stage's StageFunc.", "counter",
+		func(sm StageMetrics) float64 { return sm.ProcessingTime.Seconds() }, nil},
+	{"pipeline_stage_send_blocked_seconds_total", "Total time the stage spent sending its output downstream.", "counter",
+		func(sm StageMetrics) float64 { return sm.SendBlocked.Seconds() }, nil},
+	{"pipeline_stage_queue_length", "Items currently queued on the stage's input channel.", "gauge",
+		func(sm StageMetrics) float64 { return float64(sm.Queued) }, nil},
+	{"pipeline_stage_wait_seconds", "Distribution of time items spent waiting for stage capacity.", "histogram",
+		nil, func(sm StageMetrics) *waypoint.Histogram { return sm.Waypoint.WaitHistogram }},
+	{"pipeline_stage_active_seconds", "Distribution of time items spent being processed by the stage.", "histogram",
+		nil, func(sm StageMetrics) *waypoint.Histogram { return sm.Waypoint.ActiveHistogram }},
+}
+
+// writePromHistogram writes h, which has per-bucket counts, to buf using
+// cumulative Prometheus buckets.
+func writePromHistogram(buf *bytes.Buffer, name, labels string, h *waypoint.Histogram) {
+	var cum uint64
+
+	for i, b := range h.Bounds {
+		cum += h.Counts[i]
+		fmt.Fprintf(buf, "%s_bucket{%s,le=%q} %d\n", name, labels, promFloat(b.Seconds()), cum)
+	}
+
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count)
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, promFloat(h.Sum.Seconds()))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.Count)
+}
+
+func promFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// promEscaper escapes label values as required by the exposition format.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
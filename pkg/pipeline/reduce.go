@@ -0,0 +1,114 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// A ReduceFunc combines an accumulated value with the next input item and
+// returns the new accumulated value; see AddReduce.
+type ReduceFunc func(ctx context.Context, acc, item any) (any, error)
+
+// reducer holds the configuration for a stage added using AddReduce.
+type reducer struct {
+	seed any
+	fn   ReduceFunc
+}
+
+// AddReduce registers a named Pipeline stage that folds all of its input
+// items into a single value, starting with seed, by calling fn for each of
+// them in turn. That value is sent on to the next stage (or to Collect) once
+// no more input is forthcoming; if there was no input, seed is sent. Since
+// items are folded one at a time, a reducing stage has no concurrency and
+// it cannot be resized. An error returned by fn fails the Pipeline.
+//
+// Note that seed is reused each time the Pipeline is Run; if it is a
+// reference type (e.g. a map), fn should not modify it directly. For a
+// Pipeline created by NewAck, all input items are Ack'ed (or Nack'ed) along
+// with the reduced value. If items carry state (see ItemFrom), the reduced
+// value takes that of the first input item.
+func (p *Pipeline) AddReduce(name string, seed any, fn ReduceFunc, opts ...StageOption) error {
+	return p.Add(name, 1, nil, append(opts, withReducer(seed, fn))...)
+}
+
+func withReducer(seed any, fn ReduceFunc) StageOption {
+	return func(s *stage) {
+		s.reduce = &reducer{seed, fn}
+	}
+}
+
+// Reduce returns a Stage that folds all of its input values into a single
+// value of type A as described for AddReduce.
+func Reduce[T, A any](name string, seed A, fn func(ctx context.Context, acc A, item T) (A, error), opts ...StageOption) Stage[T, A] {
+	rfunc := func(ctx context.Context, acc, item any) (any, error) {
+		in, ok := as[T](item)
+		if !ok {
+			return nil, wrongType[T](item)
+		}
+
+		// n.b. If A is an interface type, acc may be nil; its zero value.
+		a, _ := acc.(A)
+
+		return fn(ctx, a, in)
+	}
+
+	opts = append(opts, withReducer(seed, rfunc), withTypes[T, A]())
+
+	return Stage[T, A]{
+		defs:   []stageDef{{name, 1, nil, opts}},
+		direct: []directFunc{directReduce(name, seed, fn)},
+	}
+}
+
+// reduceRunner returns an errgroupx.ContextFunc, used in place of runner for
+// a stage added using AddReduce, that folds the items received from inch
+// and sends the result to outch.
+func (s *stage) reduceRunner(inch <-chan any, outch chan<- any) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer close(outch)
+
+		var (
+			acc  = s.reduce.seed
+			msgs []*envelope
+		)
+
+		for {
+			in, ok, err := Recv[any](ctx, inch)
+			if err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+
+			s.stats.in.Add(1)
+			s.stats.queued(len(inch))
+
+			if m, ok := in.(*envelope); ok {
+				msgs = append(msgs, m)
+				in = m.Value
+			}
+
+			if acc, err = s.reduce.fn(ctx, acc, in); err != nil {
+				s.stats.errs.Add(1)
+				err = &StageError{Stage: s.name, Input: in, Err: err}
+
+				for _, m := range msgs {
+					m.nack(err)
+				}
+
+				return err
+			}
+		}
+
+		s.stats.out.Add(1)
+
+		if msgs != nil {
+			return s.send(ctx, combineAcks(msgs, acc), outch)
+		}
+
+		return s.send(ctx, acc, outch)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ContinueOnError returns an Option that prevents item-level errors from
+// failing the Pipeline. Instead, items that would have failed the Pipeline
+// (i.e. those from stages using the Fail ErrorPolicy) are dropped, as with
+// Skip, and recorded; for a Pipeline created by NewAck, they are Nack'ed
+// rather than Ack'ed. Once all remaining items have been processed, Run
+// returns an *ErrorReport summarizing those errors (or nil if there were
+// none). The first samples errors are retained in full (as *StageErrors);
+// the remainder are only counted.
+//
+// Errors that are not specific to an item (e.g. those from Feed, Collect or
+// a stage added using AddReduce) still fail the Pipeline as usual.
+func ContinueOnError(samples int) Option {
+	return func(p *Pipeline) {
+		p.report = &errorCollector{limit: max(samples, 0)}
+	}
+}
+
+// An ErrorReport is returned by Run for a Pipeline created using
+// ContinueOnError when any of its items failed.
+type ErrorReport struct {
+	Total   int            // Total number of failed items
+	Counts  map[string]int // Number of failed items, by stage name
+	Samples []*StageError  // The first of the errors (see ContinueOnError)
+}
+
+func (er *ErrorReport) Error() string {
+	stages := make([]string, 0, len(er.Counts))
+	for name := range er.Counts {
+		stages = append(stages, name)
+	}
+
+	slices.Sort(stages)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d items failed (", er.Total)
+
+	for i, name := range stages {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%q: %d", name, er.Counts[name])
+	}
+
+	b.WriteString(")")
+
+	if len(er.Samples) > 0 {
+		fmt.Fprintf(&b, "; first error: %v", er.Samples[0])
+	}
+
+	return b.String()
+}
+
+// Unwrap returns the sampled errors, so that errors.Is and errors.As may be
+// used to inspect them.
+func (er *ErrorReport) Unwrap() []error {
+	errs := make([]error, len(er.Samples))
+	for i, se := range er.Samples {
+		errs[i] = se
+	}
+
+	return errs
+}
+
+// errorCollector accumulates the ErrorReport for a Pipeline created using
+// ContinueOnError.
+type errorCollector struct {
+	limit  int
+	report ErrorReport
+	mutex
+}
+
+// add records se in the receiver.
+func (ec *errorCollector) add(se *StageError) {
+	ec.Lock()
+	defer ec.Unlock()
+
+	er := &ec.report
+
+	if er.Counts == nil {
+		er.Counts = make(map[string]int)
+	}
+
+	er.Total++
+	er.Counts[se.Stage]++
+
+	if len(er.Samples) < ec.limit {
+		er.Samples = append(er.Samples, se)
+	}
+}
+
+// reset prepares the receiver for a new Run.
+func (ec *errorCollector) reset() {
+	ec.Lock()
+	defer ec.Unlock()
+
+	ec.report = ErrorReport{}
+}
+
+// result returns the receiver's ErrorReport if any items failed or else nil.
+func (ec *errorCollector) result() error {
+	ec.Lock()
+	defer ec.Unlock()
+
+	if ec.report.Total == 0 {
+		return nil
+	}
+
+	er := ec.report
+	return &er
+}
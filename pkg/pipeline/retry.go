@@ -0,0 +1,95 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// A RetryPolicy determines how many times, and how often, a stage's
+// StageFunc is called for an item before the item is considered to have
+// failed (at which point the stage's ErrorPolicy is applied); see
+// WithRetry.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the StageFunc is called
+	// for each item (including the first). Values less than 2 disable
+	// retries.
+	MaxAttempts int
+
+	// Backoff returns how long to wait before the given retry attempt
+	// (starting at 1). If nil, retries are attempted immediately.
+	Backoff func(attempt int) time.Duration
+
+	// Retryable reports whether an item returning err should be retried.
+	// If nil, all errors are retried.
+	Retryable func(err error) bool
+}
+
+// WithRetry returns a StageOption that sets the stage's RetryPolicy.
+func WithRetry(rp RetryPolicy) StageOption {
+	return func(s *stage) {
+		s.retry = rp
+	}
+}
+
+// ConstantBackoff returns a RetryPolicy Backoff function that always waits
+// for d.
+func ConstantBackoff(d time.Duration) func(int) time.Duration {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff returns a RetryPolicy Backoff function that waits for
+// base before the first retry and doubles that delay for each subsequent
+// retry, up to a maximum of limit (or, if limit is not positive, the largest
+// possible time.Duration).
+func ExponentialBackoff(base, limit time.Duration) func(int) time.Duration {
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d > 0 && d < limit; i++ {
+			// n.b. Doubling d beyond limit/2 could overflow.
+			if d > limit/2 {
+				return limit
+			}
+			d *= 2
+		}
+
+		return min(d, limit)
+	}
+}
+
+// again reports whether an item should be retried after the given attempt
+// (starting at 1) returned err.
+func (rp RetryPolicy) again(attempt int, err error) bool {
+	return err != nil && attempt < rp.MaxAttempts && (rp.Retryable == nil || rp.Retryable(err))
+}
+
+// pause waits before the given retry attempt or until ctx is canceled, in
+// which case ctx's error is returned.
+func (rp RetryPolicy) pause(ctx context.Context, attempt int) error {
+	var d time.Duration
+	if rp.Backoff != nil {
+		d = rp.Backoff(attempt)
+	}
+
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// A Route directs items for which When returns true (or every item, if When
+// is nil) to the stage named To; see AddRouter.
+type Route struct {
+	To   string
+	When func(item any) bool
+}
+
+// route is a resolved Route; a nil when func matches every item.
+type route struct {
+	to   int
+	when func(any) bool
+}
+
+// AddRouter registers a router stage with the given name which sends each
+// of its input items to exactly one downstream stage: the one named by the
+// first Route whose When function returns true or, if none do, the stage
+// named def. If def is empty, items matching no Route are dropped (and, for
+// a Pipeline created by NewAck, are Ack'ed).
+//
+// All stages named by routes (and def) must already be registered, or
+// ErrNameUnknown is returned. AddRouter adds the edges from the router to
+// each of those stages and therefore, as described for Connect, causes the
+// Pipeline to run as a graph; use Connect to send items to the router.
+// A router's capacity is one but it may be changed using Resize.
+func (p *Pipeline) AddRouter(name, def string, routes ...Route) error {
+	if p == nil {
+		return ErrNilReceiver
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.started {
+		return ErrIsStarted
+	}
+
+	if _, ok := p.byname[name]; ok {
+		return ErrNameConflict
+	}
+
+	if def != "" {
+		routes = append(routes, Route{To: def})
+	}
+
+	resolved := make([]route, len(routes))
+
+	for i, r := range routes {
+		ndx, ok := p.byname[r.To]
+		if !ok {
+			return ErrNameUnknown
+		}
+
+		resolved[i] = route{ndx, r.When}
+	}
+
+	identity := func(ctx context.Context, in any) (any, error) {
+		return in, nil
+	}
+
+	p._register(stageDef{name, 1, identity, nil}, 0, 0)
+
+	from := p.byname[name]
+	p.stages[from].routes = resolved
+
+	for _, r := range resolved {
+		p._connect(edge{from, r.to})
+	}
+
+	return nil
+}
+
+// routeOut returns an errgroupx.ContextFunc that sends each item received
+// from in to the channel for the first of routes that matches it. The map
+// chans holds the channel for each route's destination stage. All of outs
+// (which includes those channels) are closed once in is closed. Items that
+// match no route are dropped (see WithPooling).
+func routeOut(in <-chan any, routes []route, chans map[int]chan<- any, outs []chan<- any, pool *envelopePool) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer func() {
+			for _, ch := range outs {
+				close(ch)
+			}
+		}()
+
+		for {
+			v, ok, err := Recv[any](ctx, in)
+			if err != nil || !ok {
+				return err
+			}
+
+			item := v
+			if m, ok := v.(*envelope); ok {
+				item = m.Value
+			}
+
+			matched := false
+
+			for _, r := range routes {
+				if r.when == nil || r.when(item) {
+					if err := Send(ctx, v, chans[r.to]); err != nil {
+						return err
+					}
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				ackSkipped(v)
+				pool.drop(v)
+			}
+		}
+	}
+}
@@ -21,37 +21,124 @@ import (
 // that an err returned by a goroutine will cancel the context provided to all
 // of the others).
 //
-// If the receiver has no stages registered then ErrNoStages is returned.
+// If the receiver has no stages registered then ErrNoStages is returned
+// and, if its stages form a cycle (see Connect), ErrCycle is returned.
 // Otherwise, any error returned will be one returned from one of the
-// underlying goroutines.
+// underlying goroutines. Note that, if the receiver was created using the
+// DrainOnFeedError policy, an error returned by Feed is reported only after
+// all in-flight items have drained through to Collect and it takes
+// precedence over any other error.
+//
+// A running Pipeline may be shut down gracefully by calling its Stop method.
+// See Start for running a Pipeline without blocking and RunSequential for
+// running its stages deterministically.
 func (p *Pipeline) Run(ctx context.Context) error {
 	if p == nil {
 		return ErrNilReceiver
 	}
 
-	eg, cancel, err := p.run(ctx)
+	eg, cancel, err := p.run(ctx, false)
 	if err != nil {
 		return err
 	}
 
+	return p.wait(eg, cancel)
+}
+
+// wait waits for the goroutines started by the receiver's run method to
+// complete and returns the result of that Run (see Run).
+func (p *Pipeline) wait(eg *errgroupx.Group, cancel context.CancelFunc) error {
 	defer cancel()
 
-	return eg.Wait()
+	err := eg.Wait()
+
+	p.Lock()
+	err = p._finish(err)
+	events, now := p.events, p.clock.Now()
+	p.events = nil
+	p.Unlock()
+
+	// n.b. The receiver must not be locked while events are delivered.
+	events.finish(now, err)
+
+	return err
+}
+
+// _finish resets the receiver's state once a Run has completed and returns
+// the result of that Run given err, the error returned by its goroutines.
+func (p *Pipeline) _finish(err error) error {
+	p.stop(nil)
+	close(p.done)
+	p.stop, p.done = nil, nil
+
+	if p.feedErr != nil {
+		return p.feedErr
+	}
+
+	if err == nil && p.report != nil {
+		return p.report.result()
+	}
+
+	return err
+}
+
+// errStopped is the cause given when the Context passed to Feed is canceled
+// by a call to Stop.
+const errStopped = errstr("pipeline stopped")
+
+// Stop gracefully stops the receiver's current Run by canceling the Context
+// passed to its Feed method (with errStopped as its cause) and then waiting
+// for all in-flight items to drain through the remaining stages to Collect.
+// Any error returned by Feed after Stop is called is ignored. This differs
+// from canceling the Context passed to Run, which aborts in-flight items.
+//
+// Stop returns nil once Run has returned (whose return value reports any
+// error encountered while draining) or, if ctx is done first, ctx.Err().
+// Stop returns nil immediately if the receiver is not running.
+func (p *Pipeline) Stop(ctx context.Context) error {
+	if p == nil {
+		return ErrNilReceiver
+	}
+
+	p.Lock()
+	stop, done := p.stop, p.done
+	p.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	stop(errStopped)
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // run exists as a separate method so we can Lock the receiver, set things
 // up, Unlock the reciever, then return the *errgroupx.Group so that Run can
 // call its Wait method without holding the receiver's lock for way too long.
-func (p *Pipeline) run(ctx context.Context) (*errgroupx.Group, context.CancelFunc, error) {
+// If sequential is true, the receiver's stages are run by a single goroutine
+// (see RunSequential).
+func (p *Pipeline) run(ctx context.Context, sequential bool) (*errgroupx.Group, context.CancelFunc, error) {
 	p.Lock()
 	defer p.Unlock()
 
-	if len(p.stages) == 0 {
-		p.Unlock()
-		return nil, nil, ErrNoStages
+	if err := p._validate(); err != nil {
+		return nil, nil, err
 	}
 
 	p.started = true
+	p.feedErr = nil
+
+	if p.report != nil {
+		p.report.reset()
+	}
+
+	p._startEvents()
 
 	eg, ctx, cancel := errgroupx.WithCancel(ctx)
 	// n.b. We won't defer the call to 'cancel' here; instead, we'll
@@ -63,37 +150,212 @@ func (p *Pipeline) run(ctx context.Context) (*errgroupx.Group, context.CancelFun
 		eg.GoContext(ctx, cf)
 	}
 
-	inch := make(chan any)
-	eg.GoContext(ctx, p.feedFunc(inch))
+	inch := p.newChan(p._feedReader())
+	feedch := inch
+
+	collected := make(chan struct{})
+
+	if p.wrapsItems() {
+		var wm *watermark
+		if p.checkpoints != nil {
+			wm = new(watermark)
+			eg.GoContext(ctx, p.checkpointFunc(wm, collected))
+		}
+
+		feedch = p.newChan(nil)
+		eg.GoContext(ctx, p.wrapItems(feedch, inch, wm))
+	}
+
+	if p.progress != nil {
+		ch := p.newChan(nil)
+		eg.GoContext(ctx, p.progressFunc(ch, feedch, collected))
+		feedch = ch
+	}
+
+	fctx, stop := context.WithCancelCause(ctx)
+	p.stop, p.done = stop, make(chan struct{})
 
-	prev := inch
-	var last chan any
+	eg.GoContext(fctx, p.feedFunc(feedch))
+
+	var last <-chan any
+	switch {
+	case sequential:
+		last = p.sequence(ctx, eg, inch)
+	case len(p.edges) > 0:
+		last = p.graph(ctx, eg, inch)
+	default:
+		last = p.linear(ctx, eg, inch)
+	}
 
-	for _, s := range p.stages {
-		ch := make(chan any)
-		eg.GoContext(ctx, s.runner(prev, ch))
-		prev = ch
+	if _, ok := p.impl.(ackImpl); p.wrapsItems() && !ok {
+		ch := p.newChan(nil)
+		eg.GoContext(ctx, unwrapItems(last, ch, p.envelopes))
 		last = ch
 	}
 
-	eg.GoContext(ctx, p.collectFunc(last))
+	eg.GoContext(ctx, p.collectFunc(last, collected))
+
+	if p.store != nil {
+		eg.GoContext(ctx, p.exportFunc(collected))
+	}
 
 	return eg, cancel, nil
 }
 
+// newChan returns a new channel to be read by stage s (or by something other
+// than a stage, if s is nil) using the buffer size configured for s (see
+// WithStageBuffer) or, by default, that of the receiver (see WithBuffer).
+func (p *Pipeline) newChan(s *stage) chan any {
+	if s != nil && s.buffer >= 0 {
+		return make(chan any, s.buffer)
+	}
+
+	return make(chan any, p.buffer)
+}
+
+// _feedReader returns the stage that reads directly from the channel
+// written to by Feed, or nil if it is not read by a stage.
+func (p *Pipeline) _feedReader() *stage {
+	if len(p.edges) == 0 {
+		if p.stages[0].group == 0 {
+			return &p.stages[0]
+		}
+		return nil
+	}
+
+	indeg, _ := p._degrees()
+	var root *stage
+
+	for i, n := range indeg {
+		if n > 0 {
+			continue
+		}
+
+		if root != nil {
+			return nil
+		}
+
+		root = &p.stages[i]
+	}
+
+	return root
+}
+
+// linear starts runners for the receiver's stages in the order they were
+// registered, with items received from feed going to the first. Runs of
+// stages belonging to the same branch group (see AddBranch) are forked while
+// all others are simply chained together. The channel carrying the output
+// of the final stage (or branch group) is returned.
+func (p *Pipeline) linear(ctx context.Context, eg *errgroupx.Group, feed <-chan any) <-chan any {
+	last := feed
+
+	for i := 0; i < len(p.stages); {
+		j := i + 1
+		for j < len(p.stages) && p.stages[j].group == p.stages[i].group {
+			j++
+		}
+
+		if p.stages[i].group == 0 {
+			last = p.chain(ctx, eg, p.stages[i:j], last)
+		} else {
+			last = p.fork(ctx, eg, p.stages[i:j], last)
+		}
+
+		i = j
+	}
+
+	return last
+}
+
+// chain initializes (and fuses) the given stages, starts a runner for each
+// in eg (connected in order, starting with in) and returns the channel to
+// which the output of the last is sent.
+func (p *Pipeline) chain(ctx context.Context, eg *errgroupx.Group, stages []stage, in <-chan any) <-chan any {
+	// n.b. All stages must be initialized (and fused) before any of their
+	//      runners are started. Stages without a StageFunc (see AddBatch
+	//      and AddReduce) have runners of their own which cannot execute
+	//      fused stages.
+	var heads []*stage
+
+	for i := range stages {
+		s := &stages[i]
+		p.inherit(s)
+
+		if len(heads) > 0 && s.fusible() && heads[len(heads)-1].sfunc != nil {
+			head := heads[len(heads)-1]
+			head.fused = append(head.fused, s)
+			s.waypt = nil
+			continue
+		}
+
+		s.init()
+		heads = append(heads, s)
+	}
+
+	for i, s := range heads {
+		var next *stage
+		if i+1 < len(heads) {
+			next = heads[i+1]
+		}
+
+		ch := p.newChan(next)
+		eg.GoContext(ctx, s.runner(in, ch))
+		in = ch
+	}
+
+	return in
+}
+
+// inherit copies Pipeline-wide settings to stage s prior to running it.
+func (p *Pipeline) inherit(s *stage) {
+	s.deadLetter = p.deadLetter
+	s.tracer = p.tracer
+	s.report = p.report
+	s.blocking = p.blocking
+	s.clock = p.clock
+	s.prioritize = p.prioritize
+	s.debug = p.debug
+	s.envelopes = p.envelopes
+	s.events = p.events
+	s.activate(p.middleware)
+}
+
 // feedFunc returns an errgroupx.ContextFunc that executes the receiver's
-// Interface.Feed method in order to send data to the given channel.
+// Interface.Feed method in order to send data to the given channel. If the
+// receiver's FeedErrorPolicy is DrainOnFeedError, any error returned by Feed
+// is retained (for Run to report later) instead of being returned; this
+// prevents the error from canceling the remaining pipeline stages.
 func (p *Pipeline) feedFunc(ch chan<- any) errgroupx.ContextFunc {
 	return func(ctx context.Context) error {
 		defer close(ch)
-		return p.impl.Feed(ctx, ch)
+
+		err := p.impl.Feed(ctx, ch)
+		if context.Cause(ctx) == errStopped {
+			return nil
+		}
+
+		if err == nil || p.feedPolicy != DrainOnFeedError {
+			return err
+		}
+
+		p.Lock()
+		defer p.Unlock()
+		p.feedErr = err
+
+		return nil
 	}
 }
 
 // collectFunc returns an errgroupx.ContextFunc that executes the receiver's
 // Interface.Collect method in order to receive data from the given channel.
-func (p *Pipeline) collectFunc(ch <-chan any) errgroupx.ContextFunc {
+// The done channel is closed if, and only if, Collect returns successfully.
+func (p *Pipeline) collectFunc(ch <-chan any, done chan<- struct{}) errgroupx.ContextFunc {
 	return func(ctx context.Context) error {
-		return p.impl.Collect(ctx, ch)
+		if err := p.impl.Collect(ctx, ch); err != nil {
+			return err
+		}
+
+		close(done)
+		return nil
 	}
 }
@@ -0,0 +1,108 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// A Sampler reports whether item v should be included in a sample; see
+// AddSample and WithDebugSampling. A Sampler may be called concurrently.
+type Sampler func(v any) bool
+
+// SampleEvery returns a Sampler that includes the first item and every nth
+// item thereafter. If n is less than one, a value of one is used.
+func SampleEvery(n int) Sampler {
+	n = max(n, 1)
+
+	var count atomic.Uint64
+
+	return func(any) bool {
+		return (count.Add(1)-1)%uint64(n) == 0
+	}
+}
+
+// SampleRate returns a Sampler that includes each item, independently, with
+// probability p (e.g. 0.01 for one percent of items).
+func SampleRate(p float64) Sampler {
+	return func(any) bool {
+		return rand.Float64() < p
+	}
+}
+
+// AddSample registers a named Pipeline stage, as described for AddFilter,
+// that passes through only those of its input items selected by keep and
+// drops all others. This is useful for reducing high-volume traffic to a
+// representative subset (e.g. for a costly analysis). Since sampling is
+// cheap, the stage has a capacity of one; with a Sampler from SampleEvery,
+// this also ensures that items are counted in the order they arrive.
+func (p *Pipeline) AddSample(name string, keep Sampler, opts ...StageOption) error {
+	return p.AddFilter(name, 1, func(_ context.Context, in any) (bool, error) {
+		return keep(in), nil
+	}, opts...)
+}
+
+// WithDebugSampling returns an Option that logs, for a subset of the items
+// received from Feed, the full input and output of each stage through which
+// they pass. Each item is selected (or not) by keep when it is received so
+// that a selected item can be followed through the entire Pipeline. One
+// record is written to logger, at slog.LevelDebug, for each call to a
+// StageFunc (including retries; see WithRetry) with the attributes:
+//
+//	stage     the stage's registered name
+//	attempt   the attempt number (see ItemFrom)
+//	input     the value passed to the StageFunc
+//	output    the value it returned (omitted for an error)
+//	error     the error it returned (if any)
+//	elapsed   the time spent in the StageFunc
+//
+// A value of Sampler(nil) selects no items (but, as noted for WithMetadata,
+// items are still wrapped).
+func WithDebugSampling(logger *slog.Logger, keep Sampler) Option {
+	return func(p *Pipeline) {
+		p.debug = &debugSampler{logger, keep}
+	}
+}
+
+type debugSampler struct {
+	logger *slog.Logger
+	keep   Sampler
+}
+
+// sampled returns true if the receiver selects item v.
+func (ds *debugSampler) sampled(v any) bool {
+	return ds != nil && ds.keep != nil && ds.keep(v)
+}
+
+// debugLog writes a record describing a single call to the receiver's
+// StageFunc to its debug logger (see WithDebugSampling) if it has one and
+// the item being processed using ctx was selected for sampling.
+func (s *stage) debugLog(ctx context.Context, attempt int, in, out any, err error, elapsed time.Duration) {
+	if s.debug == nil {
+		return
+	}
+
+	if is, ok := ctx.Value(itemKey{}).(*itemState); !ok || !is.sampled {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("stage", s.name),
+		slog.Int("attempt", attempt),
+		slog.Any("input", in),
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	} else {
+		attrs = append(attrs, slog.Any("output", out))
+	}
+
+	attrs = append(attrs, slog.Duration("elapsed", elapsed))
+
+	s.debug.logger.LogAttrs(ctx, slog.LevelDebug, "pipeline stage", attrs...)
+}
@@ -0,0 +1,342 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// RunSequential executes the Pipeline defined for the receiver just like Run
+// except that all of its stages are run by a single goroutine, which passes
+// each item received from Feed through every stage (in turn) before it
+// receives the next. Stage logic and topology may therefore be tested
+// deterministically: each StageFunc is called for one item at a time, in the
+// order items were sent by Feed, and items reach Collect in that same order.
+// An item sent to multiple stages (see AddBranch and Connect) is passed to
+// each of them in the order they were registered.
+//
+// Since they communicate using channels, Feed and Collect still run in
+// goroutines of their own (as do any ContextFuncs added using GoContext),
+// but stage capacities and the WithThreads, WithAutoScale, WithKey and
+// WithOrdered StageOptions have no effect. A batch (see AddBatch) is sent
+// only once it is full or once Feed has returned; i.e. its window is
+// ignored.
+func (p *Pipeline) RunSequential(ctx context.Context) error {
+	if p == nil {
+		return ErrNilReceiver
+	}
+
+	eg, cancel, err := p.run(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	return p.wait(eg, cancel)
+}
+
+// sequence initializes the receiver's stages and starts a single goroutine
+// in eg that passes each item received from feed through all of them (see
+// RunSequential). The channel carrying the output of every stage having no
+// downstream stages is returned.
+func (p *Pipeline) sequence(ctx context.Context, eg *errgroupx.Group, feed <-chan any) <-chan any {
+	sq := &sequencer{
+		nodes: make([]seqNode, len(p.stages)),
+		out:   p.newChan(nil),
+	}
+
+	for i := range p.stages {
+		s := &p.stages[i]
+		p.inherit(s)
+		s.init()
+		s.pool = nil // n.b. StageFuncs are called by the sequencer itself
+
+		sq.nodes[i].s = s
+		if s.reduce != nil {
+			sq.nodes[i].acc = s.reduce.seed
+		}
+	}
+
+	for _, e := range p._flowEdges() {
+		sq.nodes[e.from].next = append(sq.nodes[e.from].next, e.to)
+		sq.nodes[e.to].waiting++
+	}
+
+	for i, n := range sq.nodes {
+		if n.waiting == 0 {
+			sq.roots = append(sq.roots, i)
+		}
+	}
+
+	run := sq.run(feed)
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		run = p.stages[i].lifecycle(run)
+	}
+
+	eg.GoContext(ctx, run)
+
+	return sq.out
+}
+
+// _flowEdges returns the edges along which items flow between the receiver's
+// stages: either those added using Connect or, if there are none, those
+// implied by the order in which stages (and branches) were registered.
+func (p *Pipeline) _flowEdges() []edge {
+	if len(p.edges) > 0 {
+		return p.edges
+	}
+
+	var (
+		edges []edge
+		tails []int // stages whose output goes to the next group of stages
+	)
+
+	link := func(to int) {
+		for _, from := range tails {
+			edges = append(edges, edge{from, to})
+		}
+	}
+
+	for i := 0; i < len(p.stages); {
+		if p.stages[i].group == 0 {
+			link(i)
+			tails = []int{i}
+			i++
+			continue
+		}
+
+		var (
+			group = p.stages[i].group
+			lanes []int // the last stage of each lane
+		)
+
+		for i < len(p.stages) && p.stages[i].group == group {
+			link(i)
+
+			j := i + 1
+			for j < len(p.stages) && p.stages[j].group == group && p.stages[j].lane == p.stages[i].lane {
+				edges = append(edges, edge{j - 1, j})
+				j++
+			}
+
+			lanes = append(lanes, j-1)
+			i = j
+		}
+
+		tails = lanes
+	}
+
+	return edges
+}
+
+// A sequencer passes items through a Pipeline's stages one at a time; see
+// RunSequential.
+type sequencer struct {
+	nodes []seqNode
+	roots []int // stages receiving items from Feed
+	out   chan any
+}
+
+// seqNode holds the sequencer's state for a single stage.
+type seqNode struct {
+	s       *stage
+	next    []int       // downstream stages
+	waiting int         // upstream stages yet to finish
+	batch   []any       // see AddBatch
+	acc     any         // see AddReduce
+	msgs    []*envelope // envelopes folded into acc
+}
+
+// run returns an errgroupx.ContextFunc that passes each item received from
+// feed through the receiver's stages and, once feed is closed, finishes each
+// stage in turn.
+func (sq *sequencer) run(feed <-chan any) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer close(sq.out)
+
+		for {
+			v, ok, err := Recv[any](ctx, feed)
+			if err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+
+			if err := sq.fanOut(ctx, sq.roots, v); err != nil {
+				return err
+			}
+		}
+
+		for _, i := range sq.roots {
+			if err := sq.finish(ctx, i); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// fanOut passes v to each of the stages in to, splitting it first if it is
+// an envelope being passed to more than one (see AddBranch).
+func (sq *sequencer) fanOut(ctx context.Context, to []int, v any) error {
+	var msgs []*envelope
+	if m, ok := v.(*envelope); ok && len(to) > 1 {
+		msgs = m.split(len(to))
+	}
+
+	for k, i := range to {
+		if msgs != nil {
+			v = msgs[k]
+		}
+
+		if err := sq.push(ctx, i, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// push passes a single input item to stage i and emits the result, if any.
+func (sq *sequencer) push(ctx context.Context, i int, in any) error {
+	n := &sq.nodes[i]
+	s := n.s
+
+	switch {
+	case s.batch != nil:
+		s.stats.in.Add(1)
+
+		if n.batch = append(n.batch, in); len(n.batch) >= s.batch.size {
+			return sq.flush(ctx, i)
+		}
+
+		return nil
+
+	case s.reduce != nil:
+		s.stats.in.Add(1)
+
+		if m, ok := in.(*envelope); ok {
+			n.msgs = append(n.msgs, m)
+			in = m.Value
+		}
+
+		acc, err := s.reduce.fn(ctx, n.acc, in)
+		if err != nil {
+			s.stats.errs.Add(1)
+			err = &StageError{Stage: s.name, Input: in, Err: err}
+
+			for _, m := range n.msgs {
+				m.nack(err)
+			}
+
+			return err
+		}
+
+		n.acc = acc
+		return nil
+	}
+
+	out, err := s.process(ctx, in)
+
+	switch fe, failed := err.(failedError); {
+	case err == errSkipped:
+		ackSkipped(in)
+		s.envelopes.drop(in)
+		return nil
+
+	case failed:
+		nackOnError(in, fe.StageError)
+		s.envelopes.discard(in)
+		return nil
+
+	case err != nil:
+		nackOnError(in, err)
+		return err
+	}
+
+	return eachOut(out, func(item any) error {
+		return sq.emit(ctx, i, item)
+	})
+}
+
+// emit sends v, an output item of stage i, to the stages that follow it or,
+// if there are none, on toward Collect.
+func (sq *sequencer) emit(ctx context.Context, i int, v any) error {
+	n := &sq.nodes[i]
+
+	switch {
+	case n.s.routes != nil:
+		item := v
+		if m, ok := v.(*envelope); ok {
+			item = m.Value
+		}
+
+		for _, r := range n.s.routes {
+			if r.when == nil || r.when(item) {
+				return sq.push(ctx, r.to, v)
+			}
+		}
+
+		ackSkipped(v)
+		n.s.envelopes.drop(v)
+		return nil
+
+	case len(n.next) == 0:
+		return n.s.send(ctx, v, sq.out)
+	}
+
+	return sq.fanOut(ctx, n.next, v)
+}
+
+// flush emits the pending batch for stage i, if any.
+func (sq *sequencer) flush(ctx context.Context, i int) error {
+	n := &sq.nodes[i]
+	if len(n.batch) == 0 {
+		return nil
+	}
+
+	out := batchOut(n.batch)
+	n.batch = nil
+
+	n.s.stats.out.Add(1)
+
+	return sq.emit(ctx, i, out)
+}
+
+// finish is called once stage i will receive no further input; it emits
+// any pending output and then finishes each downstream stage for which
+// stage i was the last one unfinished.
+func (sq *sequencer) finish(ctx context.Context, i int) error {
+	n := &sq.nodes[i]
+
+	switch {
+	case n.s.batch != nil:
+		if err := sq.flush(ctx, i); err != nil {
+			return err
+		}
+
+	case n.s.reduce != nil:
+		n.s.stats.out.Add(1)
+
+		out := n.acc
+		if n.msgs != nil {
+			out = combineAcks(n.msgs, n.acc)
+		}
+
+		if err := sq.emit(ctx, i, out); err != nil {
+			return err
+		}
+	}
+
+	for _, j := range n.next {
+		if sq.nodes[j].waiting--; sq.nodes[j].waiting == 0 {
+			if err := sq.finish(ctx, j); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import "github.com/go-sage/synctools/pkg/waypoint"
+
+// WithSharedCapacity returns a StageOption that binds the stage to Waypoint
+// w, which may be shared by any number of stages (in any number of
+// Pipelines) in order to bound their combined concurrency; for example, all
+// stages that query the same database may share a Waypoint with a capacity
+// of 20. Each call to the stage's StageFunc (including each retry; see
+// WithRetry) holds an Active Worker of w in addition to one from the stage's
+// own Waypoint, so the stage's capacity still applies as well.
+//
+// The caller retains ownership of w and may Resize (or inspect) it as
+// usual. Time spent waiting for w counts toward the stage's timeout (see
+// WithTimeout).
+func WithSharedCapacity(w *waypoint.Waypoint) StageOption {
+	return func(s *stage) {
+		s.shared = w
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// Tee returns a CollectFunc that sends every item emerging from the Pipeline
+// to each of sinks, which run concurrently in their own goroutines. Each item
+// is sent to every sink before the next item is sent to any of them, so the
+// slowest sink governs the rate at which items are collected. A sink that
+// returns early (without error) receives no further items. If any sink
+// returns an error, the Context passed to the others is canceled and that
+// error is returned.
+//
+// Note that items are shared (not copied) between sinks so they should not
+// be modified by any of them.
+func Tee(sinks ...CollectFunc) CollectFunc {
+	all := make([]int, len(sinks))
+	for i := range all {
+		all[i] = i
+	}
+
+	return fanSinks(sinks, func(any) []int { return all })
+}
+
+// A SinkRoute directs items for which When returns true (or every item, if
+// When is nil) to the CollectFunc To; see RouteSinks.
+type SinkRoute struct {
+	To   CollectFunc
+	When func(item any) bool
+}
+
+// RouteSinks returns a CollectFunc that sends each item emerging from the
+// Pipeline to exactly one sink: the one given by the first SinkRoute whose
+// When function returns true or, if none do, def. If def is nil, items
+// matching no SinkRoute are dropped. As with Tee, each sink runs in its own
+// goroutine and an error from any of them is returned.
+func RouteSinks(def CollectFunc, routes ...SinkRoute) CollectFunc {
+	sinks := make([]CollectFunc, 0, len(routes)+1)
+	for _, r := range routes {
+		sinks = append(sinks, r.To)
+	}
+
+	if def != nil {
+		sinks = append(sinks, def)
+	}
+
+	targets := make([][]int, len(sinks))
+	for i := range targets {
+		targets[i] = []int{i}
+	}
+
+	return fanSinks(sinks, func(v any) []int {
+		for i, r := range routes {
+			if r.When == nil || r.When(v) {
+				return targets[i]
+			}
+		}
+
+		if def != nil {
+			return targets[len(routes)]
+		}
+
+		return nil
+	})
+}
+
+// fanSinks returns a CollectFunc that runs each of sinks in its own goroutine
+// and sends each item it receives to those sinks whose indexes are returned
+// by pick.
+func fanSinks(sinks []CollectFunc, pick func(any) []int) CollectFunc {
+	return func(ctx context.Context, ch <-chan any) error {
+		eg, ctx, cancel := errgroupx.WithCancel(ctx)
+		defer cancel()
+
+		chans := make([]chan any, len(sinks))
+		done := make([]chan struct{}, len(sinks))
+
+		for i, sink := range sinks {
+			chans[i], done[i] = make(chan any), make(chan struct{})
+
+			eg.GoContext(ctx, func(ctx context.Context) error {
+				defer close(done[i])
+				return sink(ctx, chans[i])
+			})
+		}
+
+		eg.GoContext(ctx, func(ctx context.Context) error {
+			defer func() {
+				for _, c := range chans {
+					close(c)
+				}
+			}()
+
+			for {
+				v, ok, err := Recv[any](ctx, ch)
+				if err != nil || !ok {
+					return err
+				}
+
+				for _, i := range pick(v) {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-done[i]:
+					case chans[i] <- v:
+					}
+				}
+			}
+		})
+
+		return eg.Wait()
+	}
+}
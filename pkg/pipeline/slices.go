@@ -0,0 +1,35 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"slices"
+)
+
+// FromSlice returns a FeedFunc that sends each element of s, in order, into
+// the Pipeline.
+func FromSlice[T any](s []T) FeedFunc {
+	return FeedSeq(slices.Values(s))
+}
+
+// ToSlice returns a CollectFunc that appends each data element emerging from
+// the Pipeline (asserted to be of type T) to the slice pointed to by dst. An
+// element of any other type causes an error wrapping ErrWrongType.
+//
+// Note that, since each stage processes its elements concurrently, elements
+// are appended in the order they emerge -- which may differ from the order
+// they were fed -- unless each stage has a capacity of one or uses
+// WithOrdered.
+func ToSlice[T any](dst *[]T) CollectFunc {
+	return func(ctx context.Context, ch <-chan any) error {
+		for {
+			v, ok, err := Recv[T](ctx, ch)
+			if err != nil || !ok {
+				return err
+			}
+
+			*dst = append(*dst, v)
+		}
+	}
+}
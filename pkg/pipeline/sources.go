@@ -0,0 +1,33 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// Merge returns a FeedFunc that runs each of feeds concurrently, in its own
+// goroutine, with all of their items merged into the Pipeline's first stage.
+// Each feed completes independently; the merged feed completes once all of
+// them have returned. If any feed returns an error, the Context passed to
+// the others is canceled and that error is returned. A common use is to
+// combine a live stream with the replay of a backlog.
+//
+// Note that the order in which items from different feeds are interleaved
+// is unspecified.
+func Merge(feeds ...FeedFunc) FeedFunc {
+	return func(ctx context.Context, ch chan<- any) error {
+		eg, ctx, cancel := errgroupx.WithCancel(ctx)
+		defer cancel()
+
+		for _, feed := range feeds {
+			eg.GoContext(ctx, func(ctx context.Context) error {
+				return feed(ctx, ch)
+			})
+		}
+
+		return eg.Wait()
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// A Codec converts items to and from bytes so they may be written to disk;
+// see WithSpill.
+type Codec interface {
+	Encode(v any) ([]byte, error)
+	Decode(data []byte) (any, error)
+}
+
+// JSONCodec returns a Codec that encodes items using encoding/json and
+// decodes them as values of type T.
+func JSONCodec[T any]() Codec {
+	return jsonCodec[T]{}
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Decode(data []byte) (any, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// WithSpill returns a StageOption that places an unbounded, disk-backed
+// buffer in front of the stage, allowing the stages preceding it to run ahead
+// (e.g. while a sink is stalled) without exhausting memory. Up to threshold
+// items are held in memory; beyond that, items are encoded using codec and
+// written to a temporary file in dir (see os.CreateTemp) from which they are
+// later read back, in order, as the stage catches up. The file is removed
+// once it has been read or the Pipeline stops.
+//
+// For a Pipeline created using NewAck (or with an Option causing items to
+// carry Metadata), only each item's value is written to disk; its callbacks
+// and Metadata remain in memory. An error from codec, or while reading or
+// writing the file, fails the Pipeline. The number of items written to disk
+// is reported by StageMetrics.Spilled. WithSpill has no effect when using
+// RunSequential and, if threshold is less than one, a value of one is used.
+func WithSpill(threshold int, dir string, codec Codec) StageOption {
+	return func(s *stage) {
+		s.spill = &spill{max(threshold, 1), dir, codec}
+	}
+}
+
+type spill struct {
+	threshold int
+	dir       string
+	codec     Codec
+}
+
+// spillRunner returns the errgroupx.ContextFunc used by runner for a stage
+// with a spill buffer: it relays items received from inch through the
+// buffer (see spool) to the stage's dataRunner.
+func (s *stage) spillRunner(inch <-chan any, outch chan<- any) errgroupx.ContextFunc {
+	ch := make(chan any)
+	run := s.dataRunner(ch, outch)
+
+	return func(ctx context.Context) error {
+		eg, ctx, cancel := errgroupx.WithCancel(ctx)
+		defer cancel()
+
+		eg.GoContext(ctx, s.spool(inch, ch))
+		eg.GoContext(ctx, run)
+
+		return eg.Wait()
+	}
+}
+
+// spool returns an errgroupx.ContextFunc that relays each item received from
+// in to out, buffering as many as necessary in a spillQueue.
+func (s *stage) spool(in <-chan any, out chan<- any) errgroupx.ContextFunc {
+	return func(ctx context.Context) error {
+		defer close(out)
+
+		q := &spillQueue{spill: s.spill, stats: s.stats}
+		defer q.close()
+
+		for in != nil || q.len() > 0 {
+			var (
+				next  any
+				outch chan<- any
+			)
+
+			if q.len() > 0 {
+				v, err := q.peek()
+				if err != nil {
+					return fmt.Errorf("stage %q: spill: %w", s.name, err)
+				}
+				next, outch = v, out
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case v, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+
+				if err := q.push(v); err != nil {
+					nackOnError(v, err)
+					return fmt.Errorf("stage %q: spill: %w", s.name, err)
+				}
+
+			case outch <- next:
+				q.pop()
+			}
+		}
+
+		return nil
+	}
+}
+
+// A spillQueue is a FIFO queue of items whose head is held in memory and
+// whose tail, once the memory threshold has been reached, is written to a
+// temporary file.
+type spillQueue struct {
+	*spill
+	stats *stageStats
+
+	mem    []any       // items at the head of the queue
+	shells []*envelope // envelope (or nil) for each item on disk
+	file   *os.File    // written using w
+	rfile  *os.File    // read using r
+	w      *bufio.Writer
+	r      *bufio.Reader
+}
+
+func (q *spillQueue) len() int {
+	return len(q.mem) + len(q.shells)
+}
+
+// push adds v to the end of the queue.
+func (q *spillQueue) push(v any) error {
+	if len(q.shells) == 0 && len(q.mem) < q.threshold {
+		q.mem = append(q.mem, v)
+		return nil
+	}
+
+	if q.file == nil {
+		if err := q.create(); err != nil {
+			return err
+		}
+	}
+
+	m, _ := v.(*envelope)
+	if m != nil {
+		v = m.Value
+	}
+
+	data, err := q.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.w.Write(binary.AppendUvarint(nil, uint64(len(data)))); err != nil {
+		return err
+	}
+
+	if _, err := q.w.Write(data); err != nil {
+		return err
+	}
+
+	if m != nil {
+		m.Value = nil // n.b. restored by peek
+	}
+
+	q.shells = append(q.shells, m)
+	q.stats.spilled.Add(1)
+
+	return nil
+}
+
+// peek returns the item at the head of the (non-empty) queue, reading more
+// items from disk if none remain in memory.
+func (q *spillQueue) peek() (any, error) {
+	if len(q.mem) > 0 {
+		return q.mem[0], nil
+	}
+
+	if err := q.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	for len(q.mem) < q.threshold && len(q.shells) > 0 {
+		n, err := binary.ReadUvarint(q.r)
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, n)
+		if _, err := io.ReadFull(q.r, data); err != nil {
+			return nil, err
+		}
+
+		v, err := q.codec.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if m := q.shells[0]; m != nil {
+			m.Value = v
+			v = m
+		}
+
+		q.mem = append(q.mem, v)
+		q.shells = q.shells[1:]
+	}
+
+	if len(q.shells) == 0 {
+		q.close()
+	}
+
+	return q.mem[0], nil
+}
+
+// pop removes the item at the head of the queue, which must be in memory.
+func (q *spillQueue) pop() {
+	q.mem[0] = nil
+	q.mem = q.mem[1:]
+}
+
+// create creates the receiver's file along with a separate handle (having
+// its own offset) from which it is read.
+func (q *spillQueue) create() error {
+	f, err := os.CreateTemp(q.dir, "pipeline-spill-*")
+	if err != nil {
+		return err
+	}
+
+	r, err := os.Open(f.Name())
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	q.file, q.rfile = f, r
+	q.w, q.r = bufio.NewWriter(f), bufio.NewReader(r)
+
+	return nil
+}
+
+// close removes the receiver's file, if any.
+func (q *spillQueue) close() {
+	if q.file == nil {
+		return
+	}
+
+	q.file.Close()
+	q.rfile.Close()
+	os.Remove(q.file.Name())
+
+	q.file, q.rfile, q.w, q.r = nil, nil, nil, nil
+}
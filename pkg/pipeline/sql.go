@@ -0,0 +1,51 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+)
+
+// A Queryer executes a query returning rows; it is satisfied by *sql.DB,
+// *sql.Conn and *sql.Tx.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// FromRows returns a FeedFunc that converts each of the given rows into a
+// value of type T using scan and sends that value into the Pipeline. The
+// rows are closed when the FeedFunc returns. Any error returned by scan
+// (or reported by rows.Err) is returned.
+func FromRows[T any](rows *sql.Rows, scan func(*sql.Rows) (T, error)) FeedFunc {
+	return func(ctx context.Context, ch chan<- any) error {
+		defer rows.Close()
+
+		for rows.Next() {
+			v, err := scan(rows)
+			if err != nil {
+				return err
+			}
+
+			if err := Send(ctx, v, ch); err != nil {
+				return err
+			}
+		}
+
+		return rows.Err()
+	}
+}
+
+// FromQuery returns a FeedFunc that executes query (with the given args)
+// using q and then behaves as described for FromRows. The query is executed
+// using the Pipeline's Context so it is canceled along with the Pipeline.
+func FromQuery[T any](q Queryer, scan func(*sql.Rows) (T, error), query string, args ...any) FeedFunc {
+	return func(ctx context.Context, ch chan<- any) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		return FromRows(rows, scan)(ctx, ch)
+	}
+}
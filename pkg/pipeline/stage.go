@@ -4,6 +4,12 @@ package pipeline
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-sage/synctools/pkg/errgroupx"
 	"github.com/go-sage/synctools/pkg/waypoint"
@@ -12,54 +18,390 @@ import (
 type stage struct {
 	name     string
 	capacity int
+	threads  int
+	inline   bool
 	sfunc    StageFunc
+	onError  ErrorPolicy
+	retry    RetryPolicy
+	timeout  time.Duration
+	key      func(any) any
+	group    int // branch group (or zero); see AddBranch
+	lane     int // branch index within group
+	routes   []route
+	batch    *batcher
+	reduce   *reducer
+	wpopts   []waypoint.Option
+	buffer   int // or -1 for the Pipeline's default
+	ordered  bool
 	waypt    *waypoint.Waypoint
+	shared   *waypoint.Waypoint // see WithSharedCapacity
+	scale    *waypoint.ScalePolicy
+	spill    *spill // see WithSpill
+	pool     *threadPool
+	fused    []*stage
+	stats    *stageStats
+	swapped  *atomic.Pointer[StageFunc] // see Replace
+	active   *atomic.Pointer[StageFunc] // see activate
+	setup    func(context.Context) error
+	teardown func(context.Context) error
+	opts     []StageOption // as registered; see AddPipeline
+	inType   reflect.Type  // if known; see Validate
+	outType  reflect.Type  // if known; see Validate
+
+	// deadLetter, tracer and report are copied from the Pipeline when it
+	// is run; see WithDeadLetters, WithTracer and ContinueOnError.
+	deadLetter func(context.Context, *StageError) error
+	tracer     Tracer
+	report     *errorCollector
+
+	// blocking, clock, prioritize, debug, envelopes and events are also
+	// copied from the Pipeline (see OnBackpressure, WithClock,
+	// WithPriority, WithDebugSampling, WithPooling and OnLifecycle) and
+	// input is the channel from which the stage receives its items.
+	blocking   *backpressure
+	input      <-chan any
+	clock      waypoint.Clock
+	prioritize bool
+	debug      *debugSampler
+	envelopes  *envelopePool
+	events     *eventStream
+
+	middleware []Middleware
+}
+
+// fusible returns true if the receiver may be fused into the stage that
+// precedes it; see WithInline.
+func (s *stage) fusible() bool {
+	return s.inline && s.threads == 0 && s.key == nil && s.sfunc != nil && s.wpopts == nil && !s.ordered && s.scale == nil && s.spill == nil
+}
+
+// init prepares the receiver for execution. It is called by the Pipeline's
+// run method (while the Pipeline is locked) prior to calling runner.
+func (s *stage) init() {
+	s.fused = nil
+	s.waypt = waypoint.New(s.capacity, append([]waypoint.Option{waypoint.WithClock(s.clock)}, s.wpopts...)...)
+
+	if s.threads > 0 {
+		s.pool = newThreadPool(s.threads)
+	}
+}
+
+// call executes the receiver's StageFunc using the given input value,
+// retrying according to the receiver's RetryPolicy and then handling any
+// error according to its ErrorPolicy. If the item is dropped, either by the
+// StageFunc (see ErrDrop), by that policy or because its deadline has passed
+// (see WithItemDeadline), errSkipped is returned.
+func (s *stage) call(ctx context.Context, in any) (out any, err error) {
+	s.stats.in.Add(1)
+	var dropped, stale bool
+
+	start := s.clock.Now()
+
+	ctx, span := s.startSpan(ctx)
+
+	defer func() {
+		endSpan(span, err)
+		s.stats.busy.Add(int64(s.clock.Since(start)))
+		s.stats.done.Add(1)
+
+		switch {
+		case isDropped(err):
+			s.stats.skipped.Add(1)
+			switch {
+			case stale:
+				s.stats.expired.Add(1)
+			case !dropped:
+				s.stats.errs.Add(1)
+			}
+		case err != nil:
+			s.stats.errs.Add(1)
+		default:
+			s.stats.out.Add(1)
+		}
+	}()
+
+	ictx, cancel := withDeadline(ctx)
+	defer cancel()
+
+	var attempt int
+
+	for attempt = 1; ; attempt++ {
+		if expired(ictx) {
+			attempt--
+			break
+		}
+
+		begin := s.clock.Now()
+		out, err = s.invoke(withAttempt(ictx, s.retry, attempt), in)
+		s.debugLog(ictx, attempt, in, out, err, s.clock.Since(begin))
+
+		if errors.Is(err, ErrDrop) {
+			dropped = true
+			return nil, errSkipped
+		}
+
+		if !s.retry.again(attempt, err) {
+			break
+		}
+
+		if perr := s.retry.pause(ictx, attempt); perr != nil {
+			if expired(ictx) {
+				break
+			}
+			return nil, perr
+		}
+	}
+
+	if expired(ictx) && (err != nil || attempt == 0) {
+		stale = true
+		return nil, s.expire(ctx, in, attempt)
+	}
+
+	return out, s.onError.handle(ctx, s, in, err, attempt)
+}
+
+// invoke calls the receiver's StageFunc once using the given input value;
+// if the receiver has a thread pool, the StageFunc is executed there. If
+// the receiver has a timeout, the call is given a Context with that
+// deadline and an error resulting from it is wrapped with ErrTimeout.
+func (s *stage) invoke(ctx context.Context, in any) (any, error) {
+	if s.timeout <= 0 {
+		return s.dispatch(ctx, in)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	out, err := s.dispatch(tctx, in)
+	if err != nil && tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = fmt.Errorf("%w after %v: %w", ErrTimeout, s.timeout, err)
+	}
+
+	return out, err
+}
+
+// dispatch calls the receiver's StageFunc for invoke, after waiting on its
+// shared Waypoint (if any) and using its thread pool (if any). It is kept
+// separate from invoke (and pooledCall from it) so that, in the common case,
+// neither its results nor its arguments escape to the heap.
+func (s *stage) dispatch(ctx context.Context, in any) (any, error) {
+	if s.shared != nil {
+		w, err := s.shared.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer w.Done()
+	}
+
+	if s.pool == nil {
+		return s.safeCall(ctx, in)
+	}
+
+	return s.pooledCall(ctx, in)
+}
+
+// pooledCall calls the receiver's StageFunc from its thread pool.
+func (s *stage) pooledCall(ctx context.Context, in any) (any, error) {
+	var out any
+	var err error
+
+	perr := s.pool.do(ctx, func() {
+		out, err = s.safeCall(ctx, in)
+	})
+
+	if perr != nil {
+		return nil, perr
+	}
+
+	return out, err
+}
+
+// safeCall calls the receiver's StageFunc, converting a panic into a
+// *PanicError.
+func (s *stage) safeCall(ctx context.Context, in any) (out any, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			out, err = nil, &PanicError{s.name, v, debug.Stack()}
+		}
+	}()
+
+	sfunc := s.sfunc
+	if f := s.active.Load(); f != nil {
+		sfunc = *f
+	}
+
+	return sfunc(ctx, in)
+}
+
+// process passes the given input value through the receiver's StageFunc
+// followed by those of any stages that have been fused into the receiver.
+// If in is an envelope (see NewAck), its value is processed and replaced
+// (unless it was dropped; see WithPooling).
+func (s *stage) process(ctx context.Context, in any) (any, error) {
+	if m, ok := in.(*envelope); ok {
+		out, err := s.process(m.context(ctx), m.Value)
+		if !isDropped(err) {
+			m.Value = out
+		}
+		return m, err
+	}
+
+	out, err := s.call(ctx, in)
+
+	for _, f := range s.fused {
+		if err != nil {
+			break
+		}
+
+		out, err = f.apply(ctx, out)
+	}
+
+	return out, err
+}
+
+// handle processes a single input value and sends the result to outch. A
+// dropped item is Ack'ed (see NewAck) and a failed item is Nack'ed.
+func (s *stage) handle(ctx context.Context, in any, outch chan<- any) error {
+	out, err := s.process(ctx, in)
+	return s.emit(ctx, in, out, err, outch)
+}
+
+// emit sends out, the result of processing in, to outch unless processing
+// returned err (which is returned) or the item was dropped.
+func (s *stage) emit(ctx context.Context, in, out any, err error, outch chan<- any) (rerr error) {
+	defer func() { nackOnError(in, rerr) }()
+
+	switch fe, failed := err.(failedError); {
+	case err == errSkipped:
+		ackSkipped(in)
+		s.envelopes.drop(in)
+		return nil
+	case failed:
+		nackOnError(in, fe.StageError)
+		s.envelopes.discard(in)
+		return nil
+	case err != nil:
+		return err
+	}
+
+	return s.send(ctx, out, outch)
 }
 
 // runner returns an [errgroupx.ContextFunc] as expected by the [GoContext] method
 // on type *errgroupx.Group.
 func (s *stage) runner(inch <-chan any, outch chan<- any) errgroupx.ContextFunc {
+	s.input = inch
+
+	if s.spill != nil {
+		return s.lifecycle(s.spillRunner(inch, outch))
+	}
+
+	return s.lifecycle(s.dataRunner(inch, outch))
+}
+
+// dataRunner returns the errgroupx.ContextFunc used by runner to process the
+// items received from inch: a batchRunner, reduceRunner or itemRunner.
+func (s *stage) dataRunner(inch <-chan any, outch chan<- any) errgroupx.ContextFunc {
+	switch {
+	case s.batch != nil:
+		return s.batchRunner(inch, outch)
+	case s.reduce != nil:
+		return s.reduceRunner(inch, outch)
+	}
+
+	return s.itemRunner(inch, outch)
+}
+
+// itemRunner returns the errgroupx.ContextFunc used by runner for stages
+// that process one item at a time.
+func (s *stage) itemRunner(inch <-chan any, outch chan<- any) errgroupx.ContextFunc {
 	return func(ctx context.Context) error {
 		defer close(outch)
 
-		s.waypt = waypoint.New(s.capacity)
+		if s.pool != nil {
+			s.pool.start()
+			defer s.pool.stop()
+		}
+
+		defer s.autoscale(ctx)()
+
 		eg, ctx, cancel := errgroupx.WithCancel(ctx)
 		defer cancel()
 
 		const errInputDone = errstr("no more input")
 
+		var (
+			lanes   *keyedLanes
+			pending *orderedQueue
+			waiting *priorityQueue
+		)
+
+		if s.prioritize {
+			waiting = newPriorityQueue(cap(inch))
+		}
+
+		switch {
+		case s.key != nil:
+			lanes = newKeyedLanes(func(ctx context.Context, in any) error {
+				return s.handle(ctx, in, outch)
+			})
+
+		case s.ordered:
+			pending = newOrderedQueue(s.capacity)
+			eg.GoContext(ctx, pending.emitter(s, outch))
+		}
+
 		runloop := func() error {
 			for {
-				in, ok, err := Recv[any](ctx, inch)
+				in, ok, err := waiting.recv(ctx, inch)
 				if err != nil {
 					return err
 				} else if !ok {
 					return errInputDone
 				}
 
+				s.stats.queued(len(inch))
+
 				w, err := s.waypt.Wait(ctx)
 				if err != nil {
 					return err
 				}
 
-				eg.Go(func() (err error) {
-					defer w.Done()
-					var out any
+				switch {
+				case lanes != nil:
+					lanes.submit(ctx, eg, s.keyOf(in), in, w)
+					continue
 
-					if out, err = s.sfunc(ctx, in); err != nil {
+				case pending != nil:
+					if err := pending.submit(ctx, eg, s, in, w); err != nil {
 						return err
 					}
+					continue
+				}
 
-					return Send(ctx, out, outch)
+				eg.Go(func() (err error) {
+					defer w.Done()
+					w.Do(ctx, func(ctx context.Context) {
+						err = s.handle(withWorker(ctx, w, in), in, outch)
+					})
+					return err
 				})
 			}
 		}
 
-		if err := runloop(); err != nil && err != errInputDone {
-			return err
+		// n.b. We must always wait for our worker goroutines to return
+		//      (even if runloop failed) since they may still be sending
+		//      to outch, which is closed as soon as we return.
+		//      If a worker failed, its error (rather than the cancelation
+		//      it caused in runloop) is what we report.
+		err := runloop()
+		pending.close()
+
+		if werr := eg.Wait(); err == errInputDone || werr != nil {
+			return werr
 		}
 
-		return eg.Wait()
+		return err
 	}
 }
 
@@ -0,0 +1,86 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import "context"
+
+// AddStateful registers a named stage with Pipeline p, as described for
+// (*Pipeline).Add, whose StageFunc fn is passed a worker-scoped state value
+// along with each of its input items. Each concurrently executing call to
+// fn is given its own state value, which is created by calling newState
+// and is then reused by later calls; fn may therefore use its state (e.g.
+// a scratch buffer or client connection) without any locking. At most one
+// state value is created for each unit of the stage's capacity (unless it
+// is resized) and all of them are retained between Runs.
+//
+// AddStateful is a function (rather than a method on *Pipeline) since Go
+// methods may not have type parameters of their own.
+func AddStateful[S any](p *Pipeline, name string, capacity int, newState func() S, fn func(ctx context.Context, state S, input any) (any, error), opts ...StageOption) error {
+	return p.Add(name, capacity, statefulFunc(newState, fn), opts...)
+}
+
+// NewStatefulStage is similar to NewStage except that fn is also passed a
+// worker-scoped state value as described for AddStateful.
+func NewStatefulStage[S, In, Out any](name string, capacity int, newState func() S, fn func(ctx context.Context, state S, input In) (Out, error), opts ...StageOption) Stage[In, Out] {
+	sfunc := statefulFunc(newState, func(ctx context.Context, state S, v any) (any, error) {
+		in, ok := as[In](v)
+		if !ok {
+			return nil, wrongType[In](v)
+		}
+
+		return fn(ctx, state, in)
+	})
+
+	opts = append(opts, withTypes[In, Out]())
+
+	// n.b. For RunDirect, each worker goroutine has a state of its own.
+	direct := directStage(name, capacity, func() func(context.Context, In) (Out, error) {
+		state := newState()
+		return func(ctx context.Context, in In) (Out, error) {
+			return fn(ctx, state, in)
+		}
+	})
+
+	return Stage[In, Out]{
+		defs:   []stageDef{{name, capacity, sfunc, opts}},
+		direct: []directFunc{direct},
+	}
+}
+
+// statefulFunc returns a StageFunc that calls fn with a state value taken
+// from (and then returned to) a free list that is populated using newState.
+func statefulFunc[S any](newState func() S, fn func(context.Context, S, any) (any, error)) StageFunc {
+	var (
+		free []S
+		mu   mutex
+	)
+
+	get := func() S {
+		mu.Lock()
+
+		if n := len(free); n > 0 {
+			state := free[n-1]
+			free = free[:n-1]
+			mu.Unlock()
+			return state
+		}
+
+		// n.b. newState may be slow (e.g. if it opens a connection) so
+		//      it's called without holding the lock.
+		mu.Unlock()
+		return newState()
+	}
+
+	put := func(state S) {
+		mu.Lock()
+		defer mu.Unlock()
+		free = append(free, state)
+	}
+
+	return func(ctx context.Context, in any) (any, error) {
+		state := get()
+		defer put(state)
+
+		return fn(ctx, state, in)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// A Producer is a source of values of type T whose Recv method returns io.EOF
+// once no more values are available. Client-streaming gRPC server streams
+// (and server-streaming client streams) satisfy Producer for their message
+// type.
+type Producer[T any] interface {
+	Recv() (T, error)
+}
+
+// A Consumer is a sink for values of type T. Server-streaming gRPC server
+// streams (and client-streaming client streams) satisfy Consumer for their
+// message type.
+type Consumer[T any] interface {
+	Send(T) error
+}
+
+// FromProducer returns a FeedFunc that sends each value received from p into
+// the Pipeline until p returns io.EOF. Any other error returned by p is
+// returned.
+//
+// Note that a blocked call to p.Recv cannot be interrupted by the Pipeline;
+// p should be tied to the same Context (e.g. the RPC's Context) so that it
+// is unblocked when the Pipeline is canceled.
+func FromProducer[T any](p Producer[T]) FeedFunc {
+	return func(ctx context.Context, ch chan<- any) error {
+		for {
+			v, err := p.Recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if err := Send(ctx, v, ch); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ToConsumer returns a CollectFunc that passes each data element emerging
+// from the Pipeline (asserted to be of type T) to c. Any error returned by c
+// is returned.
+func ToConsumer[T any](c Consumer[T]) CollectFunc {
+	return func(ctx context.Context, ch <-chan any) error {
+		for {
+			v, ok, err := Recv[T](ctx, ch)
+			if err != nil || !ok {
+				return err
+			}
+
+			if err := c.Send(v); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// FromRequest returns a FeedFunc that decodes a stream of JSON values from
+// the body of r into values of type T and sends each into the Pipeline. It
+// is equivalent to FromJSONLines(r.Body).
+func FromRequest[T any](r *http.Request) FeedFunc {
+	return FromJSONLines[T](r.Body)
+}
+
+// ToResponse returns a CollectFunc that writes each data element emerging
+// from the Pipeline to w as a line of JSON, flushing w after each one (if
+// it implements http.Flusher) so that clients receive results as they are
+// produced. Unless already set, the response's Content-Type is set to
+// "application/x-ndjson".
+func ToResponse(w http.ResponseWriter) CollectFunc {
+	return func(ctx context.Context, ch <-chan any) error {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+
+		for {
+			v, ok, err := Recv[any](ctx, ch)
+			if err != nil || !ok {
+				return err
+			}
+
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+)
+
+// WithLockedThreads returns a StageOption that causes the stage's StageFunc
+// to be executed on a dedicated pool of n goroutines, each of which is
+// locked to its own OS thread (see runtime.LockOSThread). This is intended
+// for stages that make blocking cgo or syscall-heavy calls, so that they
+// are isolated from (and do not starve the scheduler for) all other stages.
+//
+// Note that the stage's waypoint capacity still applies; if capacity is
+// greater than n, the excess Active workers will queue for an available
+// thread. A value for n less than 1 is treated as 1.
+func WithLockedThreads(n int) StageOption {
+	return func(s *stage) {
+		if n < 1 {
+			n = 1
+		}
+		s.threads = n
+	}
+}
+
+// ThreadMetrics represents point-in-time metrics for the pool of locked
+// OS threads used by a stage configured with WithLockedThreads.
+type ThreadMetrics struct {
+	Threads   int    // Number of threads in the pool
+	Busy      int    // Number of threads currently executing a StageFunc
+	Queued    int    // Number of calls waiting for an available thread
+	Completed uint64 // Total number of calls completed by the pool
+}
+
+type threadPool struct {
+	size      int
+	jobs      chan func()
+	busy      atomic.Int64
+	queued    atomic.Int64
+	completed atomic.Uint64
+}
+
+func newThreadPool(size int) *threadPool {
+	return &threadPool{
+		size: size,
+		jobs: make(chan func()),
+	}
+}
+
+// start launches the receiver's locked threads, each of which will execute
+// submitted jobs until the stop method is called.
+func (tp *threadPool) start() {
+	for i := 0; i < tp.size; i++ {
+		go func() {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+
+			for job := range tp.jobs {
+				job()
+			}
+		}()
+	}
+}
+
+// stop terminates all of the receiver's threads once they become idle. The
+// do method must not be called after stop.
+func (tp *threadPool) stop() {
+	close(tp.jobs)
+}
+
+// do executes fn on one of the receiver's locked threads and waits for it
+// to complete. If ctx is canceled before a thread becomes available, fn is
+// not executed and ctx.Err() is returned. However, once fn has started, do
+// waits for it to return regardless of ctx.
+func (tp *threadPool) do(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+
+	job := func() {
+		defer close(done)
+		defer tp.completed.Add(1)
+
+		tp.busy.Add(1)
+		defer tp.busy.Add(-1)
+
+		fn()
+	}
+
+	tp.queued.Add(1)
+
+	select {
+	case <-ctx.Done():
+		tp.queued.Add(-1)
+		return ctx.Err()
+	case tp.jobs <- job:
+		tp.queued.Add(-1)
+	}
+
+	<-done
+
+	return nil
+}
+
+func (tp *threadPool) metrics() *ThreadMetrics {
+	if tp == nil {
+		return nil
+	}
+
+	return &ThreadMetrics{
+		Threads:   tp.size,
+		Busy:      int(tp.busy.Load()),
+		Queued:    int(tp.queued.Load()),
+		Completed: tp.completed.Load(),
+	}
+}
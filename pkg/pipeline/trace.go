@@ -0,0 +1,92 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import "context"
+
+// A Tracer creates Spans for tracking items as they pass through the stages
+// of a Pipeline. As with waypoint.Tracer, this package intentionally avoids
+// depending upon any particular tracing library; an OpenTelemetry Tracer may
+// be adapted with just a few lines of code. For example:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, pipeline.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ trace.Span }
+//
+//	func (s otelSpan) End(err error) {
+//		if err != nil {
+//			s.Span.RecordError(err)
+//			s.Span.SetStatus(codes.Error, err.Error())
+//		}
+//		s.Span.End()
+//	}
+type Tracer interface {
+	// Start creates a new Span with the given name as a child of any span
+	// contained in ctx and returns a Context containing the new Span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span represents a single traced operation created by a Tracer.
+type Span interface {
+	// End completes the Span. A non-nil err indicates that the traced
+	// operation failed.
+	End(err error)
+}
+
+// ItemSpanName is the name given to the Span covering the entire trip of a
+// single item through a Pipeline (see WithTracer).
+const ItemSpanName = "pipeline.item"
+
+// WithTracer returns an Option that causes the Pipeline to trace each item
+// it processes using Tracer t. A Span named ItemSpanName is started as each
+// item is received from Feed and ends once the item reaches Collect (or is
+// dropped or fails). Each stage then creates a child of that Span, named
+// after the stage, covering its processing of the item (including any
+// retries). The Context passed to each StageFunc carries the stage's Span,
+// so any Spans it creates are children of it.
+//
+// For a Pipeline created using NewAck, the item Span is a child of the
+// AckItem's Context (if any), allowing a trace begun by the message's
+// producer to continue through the Pipeline, and it ends when the item is
+// Ack'ed or Nack'ed.
+func WithTracer(t Tracer) Option {
+	return func(p *Pipeline) {
+		p.tracer = t
+	}
+}
+
+// traceAcks returns a copy of item, using ctx for its Context, whose Ack and
+// Nack callbacks also end span.
+func traceAcks(item AckItem, ctx context.Context, span Span) AckItem {
+	item.Context = ctx
+	return onAck(item, span.End)
+}
+
+// startSpan starts a Span named after the receiver if it has a Tracer.
+// Otherwise, ctx and a nil Span are returned.
+func (s *stage) startSpan(ctx context.Context) (context.Context, Span) {
+	if s.tracer == nil {
+		return ctx, nil
+	}
+
+	return s.tracer.Start(ctx, s.name)
+}
+
+// endSpan ends span (if it is not nil) using err. Items that were skipped are
+// not considered failures.
+func endSpan(span Span, err error) {
+	if span == nil {
+		return
+	}
+
+	if err == errSkipped {
+		err = nil
+	}
+
+	span.End(err)
+}
@@ -0,0 +1,164 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"iter"
+
+	"github.com/go-sage/synctools/pkg/errgroupx"
+)
+
+// A Stage is a sequence of one or more Pipeline stages, with compile-time
+// types, that transforms values of type In into values of type Out. Stages
+// are created using NewStage and chained together using Then. Since each
+// stage is typed, StageFuncs need not assert the type of their input.
+//
+// A Stage may be registered with a Pipeline using its AddTo method, or it
+// may be executed directly using Run, RunDirect or Iterate.
+type Stage[In, Out any] struct {
+	defs   []stageDef
+	direct []directFunc // see RunDirect
+}
+
+// stageDef holds the arguments for a call to (*Pipeline).Add.
+type stageDef struct {
+	name     string
+	capacity int
+	sfunc    StageFunc
+	opts     []StageOption
+}
+
+// NewStage returns a Stage with the given name and capacity which calls fn
+// for each of its input values. Any provided StageOptions are applied as
+// described for (*Pipeline).Add.
+func NewStage[In, Out any](name string, capacity int, fn func(context.Context, In) (Out, error), opts ...StageOption) Stage[In, Out] {
+	sfunc := func(ctx context.Context, v any) (any, error) {
+		in, ok := as[In](v)
+		if !ok {
+			return nil, wrongType[In](v)
+		}
+
+		return fn(ctx, in)
+	}
+
+	opts = append(opts, withTypes[In, Out]())
+
+	direct := directStage(name, capacity, func() func(context.Context, In) (Out, error) {
+		return fn
+	})
+
+	return Stage[In, Out]{
+		defs:   []stageDef{{name, capacity, sfunc, opts}},
+		direct: []directFunc{direct},
+	}
+}
+
+// Then returns a Stage that passes the output from first as input to next.
+// It is a function (rather than a method on Stage) since Go methods may not
+// have type parameters of their own.
+func Then[A, B, C any](first Stage[A, B], next Stage[B, C]) Stage[A, C] {
+	defs := make([]stageDef, 0, len(first.defs)+len(next.defs))
+	defs = append(defs, first.defs...)
+	defs = append(defs, next.defs...)
+
+	direct := make([]directFunc, 0, len(first.direct)+len(next.direct))
+	direct = append(direct, first.direct...)
+	direct = append(direct, next.direct...)
+
+	return Stage[A, C]{defs: defs, direct: direct}
+}
+
+// AddTo registers each of the receiver's stages, in order, with Pipeline p.
+// Any error returned by (*Pipeline).Add is returned immediately.
+func (s Stage[In, Out]) AddTo(p *Pipeline) error {
+	for _, d := range s.defs {
+		if err := p.Add(d.name, d.capacity, d.sfunc, d.opts...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run executes a new Pipeline (created using the provided Options) made up of
+// the stages from s. The feed function acts as the Pipeline's data source and
+// collect as its data sink; they behave the same as the Feed and Collect
+// methods of an Interface except that their channels are typed. See the Run
+// method on type *Pipeline for details.
+func Run[In, Out any](ctx context.Context, s Stage[In, Out], feed func(context.Context, chan<- In) error, collect func(context.Context, <-chan Out) error, opts ...Option) error {
+	p := New(&typedImpl[In, Out]{feed: feed, collect: collect}, opts...)
+
+	if err := s.AddTo(p); err != nil {
+		return err
+	}
+
+	return p.Run(ctx)
+}
+
+// Iterate is similar to the package level Iterate function but uses the
+// stages from the receiver rather than a setup function.
+func (s Stage[In, Out]) Iterate(ctx context.Context, in iter.Seq[In], opts ...Option) iter.Seq2[Out, error] {
+	return Iterate[In, Out](ctx, in, s.AddTo, opts...)
+}
+
+// typedImpl is the Interface used by Run to adapt its typed feed and
+// collect functions.
+type typedImpl[In, Out any] struct {
+	feed    func(context.Context, chan<- In) error
+	collect func(context.Context, <-chan Out) error
+}
+
+func (ti *typedImpl[In, Out]) Feed(ctx context.Context, ch chan<- any) error {
+	eg, ctx, cancel := errgroupx.WithCancel(ctx)
+	defer cancel()
+
+	tch := make(chan In)
+
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		defer close(tch)
+		return ti.feed(ctx, tch)
+	})
+
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		for v := range tch {
+			if err := Send(ctx, v, ch); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return eg.Wait()
+}
+
+func (ti *typedImpl[In, Out]) Collect(ctx context.Context, ch <-chan any) error {
+	eg, ctx, cancel := errgroupx.WithCancel(ctx)
+	defer cancel()
+
+	tch := make(chan Out)
+
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		return ti.collect(ctx, tch)
+	})
+
+	eg.GoContext(ctx, func(ctx context.Context) error {
+		defer close(tch)
+
+		for {
+			v, ok, err := Recv[Out](ctx, ch)
+			if err != nil || !ok {
+				return err
+			}
+
+			select {
+			case tch <- v:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+
+	return eg.Wait()
+}
@@ -0,0 +1,115 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/go-sage/synctools/pkg/waypoint"
+)
+
+// withTypes returns a StageOption recording that the stage's StageFunc
+// accepts input values of type In and returns output values of type Out
+// (see Validate). It is used by the constructors for typed Stages.
+func withTypes[In, Out any]() StageOption {
+	return func(s *stage) {
+		s.inType = reflect.TypeFor[In]()
+		s.outType = reflect.TypeFor[Out]()
+	}
+}
+
+// _checkStages returns an error describing each of the receiver's stages
+// that is misconfigured (joined using errors.Join) or nil if there are none.
+func (p *Pipeline) _checkStages() error {
+	var errs []error
+
+	for i := range p.stages {
+		s := &p.stages[i]
+
+		if s.capacity < 0 {
+			errs = append(errs, fmt.Errorf("stage %q: %w: %d", s.name, waypoint.ErrInvalidCapacity, s.capacity))
+		}
+
+		if s.sfunc == nil && s.batch == nil && s.reduce == nil {
+			errs = append(errs, fmt.Errorf("stage %q: %w", s.name, ErrNoStageFunc))
+		}
+	}
+
+	for _, e := range p._flowEdges() {
+		from, to := &p.stages[e.from], &p.stages[e.to]
+		if from.outType == nil || to.inType == nil || compatible(from.outType, to.inType) {
+			continue
+		}
+
+		errs = append(errs, fmt.Errorf("stage %q: %w: %v from stage %q (wanted %v)", to.name, ErrWrongType, from.outType, from.name, to.inType))
+	}
+
+	return errors.Join(errs...)
+}
+
+// compatible returns false if no value of type out may be used as a value
+// of type in. If out is an interface type, its dynamic values might be of
+// type in, so compatibility can only be ruled out if in is a concrete type
+// that does not implement it.
+func compatible(out, in reflect.Type) bool {
+	switch {
+	case out.AssignableTo(in):
+		return true
+	case out.Kind() == reflect.Interface:
+		return in.Kind() == reflect.Interface || in.Implements(out)
+	}
+
+	return false
+}
+
+// DryRun validates the receiver (see Validate) and then passes probe, as its
+// only input item, through each of its stages using RunSequential. Neither
+// the Feed nor the Collect method of its Interface is called; instead, the
+// items that would have been sent to Collect are returned along with any
+// error. This allows a misconfigured Pipeline to be caught at startup, using
+// a representative item, rather than partway through its input.
+//
+// Note that each StageFunc is called as usual (along with any setup and
+// teardown functions; see WithSetup) and so must tolerate the probe, and
+// that the probe is included in the receiver's Metrics. ContextFuncs added
+// using GoContext, along with any StateStore (see WithStateExport),
+// OnProgress function or Checkpointer (see WithCheckpoints), are not used. DryRun must not be called while the
+// receiver is running; more stages may still be added once it returns.
+func (p *Pipeline) DryRun(ctx context.Context, probe any) ([]any, error) {
+	if p == nil {
+		return nil, ErrNilReceiver
+	}
+
+	var out []any
+
+	p.Lock()
+
+	var (
+		impl        = p.impl
+		started     = p.started
+		funcs       = p.funcs
+		store       = p.store
+		progress    = p.progress
+		checkpoints = p.checkpoints
+	)
+
+	p.impl = funcImpl{FromSlice([]any{probe}), ToSlice(&out)}
+	p.funcs, p.store, p.progress, p.checkpoints = nil, nil, nil, nil
+
+	p.Unlock()
+
+	defer func() {
+		p.Lock()
+		defer p.Unlock()
+
+		p.impl, p.started = impl, started
+		p.funcs, p.store, p.progress, p.checkpoints = funcs, store, progress, checkpoints
+	}()
+
+	err := p.RunSequential(ctx)
+
+	return out, err
+}
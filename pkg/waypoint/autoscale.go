@@ -0,0 +1,138 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"context"
+	"time"
+)
+
+// ScalePolicy defines the behavior of the capacity controller implemented
+// by AutoScale. Zero values for Step and Interval are replaced by their
+// documented defaults.
+type ScalePolicy struct {
+	// TargetWait is the desired average time Workers spend in the Waiting
+	// state. Capacity is increased while the average wait time (over the
+	// most recent Interval) exceeds this value and Workers are Waiting. It is
+	// decreased while the average wait time is less than half this value
+	// and there is unused capacity.
+	TargetWait time.Duration
+
+	// Min and Max bound the capacity values set by the controller.
+	Min int
+	Max int
+
+	// Step is the amount by which capacity is adjusted. Default: 1
+	Step int
+
+	// Interval is how often metrics are inspected. Default: 1s
+	Interval time.Duration
+
+	// Cooldown is the minimum time between consecutive adjustments. Note
+	// that a manual call to Resize also restarts the Cooldown period.
+	Cooldown time.Duration
+}
+
+// AutoScale runs a controller that periodically inspects the wait time and
+// queue depth metrics for w and calls its Resize method according to the
+// provided ScalePolicy. AutoScale blocks until ctx is canceled (returning
+// ctx.Err()) or until w's Done channel is closed (returning nil), so it is
+// normally run in its own goroutine. Any other error encountered while
+// resizing w is returned.
+//
+// AutoScale cooperates with manual resizing; if w's capacity is changed by
+// anything other than this controller (even while it is deciding upon an
+// adjustment), the new capacity is accepted as-is and no further adjustments
+// are made until the policy's Cooldown period has elapsed.
+func AutoScale(ctx context.Context, w *Waypoint, policy ScalePolicy) error {
+	if policy.Step <= 0 {
+		policy.Step = 1
+	}
+
+	if policy.Interval <= 0 {
+		policy.Interval = time.Second
+	}
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+
+	prev := w.Metrics()
+	last := prev.Capacity
+	var changed time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.done:
+			return nil
+		case <-ticker.C:
+		}
+
+		m := w.Metrics()
+
+		if m.Capacity != last {
+			// Someone else resized the Waypoint; respect their wishes.
+			last = m.Capacity
+			changed = m.Timestamp
+		}
+
+		if m.Finished < prev.Finished {
+			// Metrics were reset; start over from here.
+			prev = m
+			continue
+		}
+
+		newcap := policy.next(prev, m)
+		prev = m
+
+		if newcap == m.Capacity || m.Timestamp.Sub(changed) < policy.Cooldown {
+			continue
+		}
+
+		// n.b. If w was resized since m was taken, its new capacity is
+		//      noticed (as above) on the next tick.
+		ok, err := w.compareAndResize(m.Capacity, newcap)
+		switch {
+		case err == ErrClosed:
+			return nil
+		case err != nil:
+			return err
+		case !ok:
+			continue
+		}
+
+		last = newcap
+		changed = m.Timestamp
+	}
+}
+
+// next returns the capacity recommended by the receiver based on the
+// difference between the provided Metrics.
+func (sp ScalePolicy) next(prev, cur Metrics) int {
+	newcap := cur.Capacity
+
+	started := (cur.Active + cur.Finished) - (prev.Active + prev.Finished)
+
+	var avg time.Duration
+	if started > 0 {
+		avg = (cur.WaitTime - prev.WaitTime) / time.Duration(started)
+	}
+
+	switch {
+	case cur.Waiting > 0 && (avg > sp.TargetWait || started == 0):
+		newcap += sp.Step
+	case cur.Waiting == 0 && avg < sp.TargetWait/2 && cur.Active < cur.Capacity:
+		newcap -= sp.Step
+	}
+
+	if sp.Max > 0 && newcap > sp.Max {
+		newcap = sp.Max
+	}
+
+	if newcap < sp.Min {
+		newcap = sp.Min
+	}
+
+	return newcap
+}
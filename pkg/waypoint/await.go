@@ -0,0 +1,111 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"container/list"
+	"context"
+)
+
+// AwaitCapacity blocks until at least n units of the receiver's capacity are
+// unused (i.e. its capacity exceeds its number of Active Workers by n or
+// more) without creating a Worker or consuming any capacity. This can be
+// useful for gating a coordinator that should only dispatch work when there
+// is headroom. Note that Waiting Workers are given first claim to any newly
+// available capacity and, since capacity is not reserved, it may be consumed
+// by others before the caller can make use of it.
+//
+// AwaitCapacity returns nil once the capacity is available or ctx.Err() if
+// ctx is canceled first. ErrClosed is returned if the receiver has already
+// been closed, or if its Done channel is closed while AwaitCapacity is
+// blocked. ErrNilWaypoint is returned if the receiver is nil. Values of n
+// less than 1 are treated as 1.
+func (w *Waypoint) AwaitCapacity(ctx context.Context, n int) error {
+	if w == nil {
+		return ErrNilWaypoint
+	}
+
+	n = max(n, 1)
+
+	w.Lock()
+
+	if w.closed {
+		w.Unlock()
+		return ErrClosed
+	}
+
+	if w._free() >= n {
+		w.Unlock()
+		return nil
+	}
+
+	cw := &capWaiter{n: n, ready: make(chan struct{})}
+	elem := w.capWaiters.PushBack(cw)
+	w.Unlock()
+
+	select {
+	case <-cw.ready:
+		return nil
+
+	case <-w.done:
+		w.Lock()
+		defer w.Unlock()
+		w._removeCapWaiter(elem, cw)
+		return ErrClosed
+
+	case <-ctx.Done():
+		w.Lock()
+		defer w.Unlock()
+		if w._removeCapWaiter(elem, cw) {
+			return ctx.Err()
+		}
+		// n.b. Capacity became available concurrently; report that.
+		return nil
+	}
+}
+
+// A capWaiter represents a blocked call to AwaitCapacity.
+type capWaiter struct {
+	n     int
+	ready chan struct{}
+	woken bool
+}
+
+// _free returns the amount of the receiver's capacity not currently in use.
+func (w *Waypoint) _free() int {
+	return w.capacity - w._inUse()
+}
+
+// _notifyCapacity wakes any calls to AwaitCapacity that are satisfied by the
+// receiver's currently unused capacity.
+func (w *Waypoint) _notifyCapacity() {
+	if w.capWaiters.Len() == 0 {
+		return
+	}
+
+	free := w._free()
+
+	for e := w.capWaiters.Front(); e != nil; {
+		next := e.Next()
+
+		if cw := e.Value.(*capWaiter); cw.n <= free {
+			w.capWaiters.Remove(e)
+			cw.woken = true
+			close(cw.ready)
+		}
+
+		e = next
+	}
+}
+
+// _removeCapWaiter removes cw from the receiver's list of capWaiters and
+// returns true unless it had already been woken.
+func (w *Waypoint) _removeCapWaiter(elem *list.Element, cw *capWaiter) bool {
+	if cw.woken {
+		return false
+	}
+
+	w.capWaiters.Remove(elem)
+
+	return true
+}
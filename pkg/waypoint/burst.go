@@ -0,0 +1,59 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "time"
+
+// WithBurst returns an Option that allows the Waypoint to briefly exceed its
+// capacity by up to burst Active Workers. The burst allowance begins to
+// decay as soon as the Waypoint goes above its capacity, shrinking linearly
+// to zero over the given decay period; it is restored in full once the
+// number of Active Workers drops back to (or below) the Waypoint's capacity.
+//
+// Workers activated during a burst are not affected as the allowance decays;
+// instead, no further Workers are activated until enough of them have
+// Finished. Note also that a Waypoint with zero capacity never bursts.
+//
+// Burst values less than 1, or decay periods less than or equal to zero,
+// are ignored.
+func WithBurst(burst int, decay time.Duration) Option {
+	return func(w *Waypoint) {
+		if burst < 1 || decay <= 0 {
+			return
+		}
+
+		w.burst = &burstAllowance{size: burst, decay: decay}
+	}
+}
+
+type burstAllowance struct {
+	size  int
+	decay time.Duration
+	start time.Time // when the current burst began (or zero if none)
+}
+
+// _limit returns the maximum number of Workers the receiver currently allows
+// to be Active, including any burst allowance (see WithBurst).
+func (w *Waypoint) _limit() int {
+	b := w.burst
+	if b == nil || w.capacity == 0 {
+		return w.capacity
+	}
+
+	if w._inUse() <= w.capacity {
+		b.start = time.Time{}
+		return w.capacity + b.size
+	}
+
+	now := w.clock.Now()
+	if b.start.IsZero() {
+		b.start = now
+	}
+
+	elapsed := now.Sub(b.start)
+	if elapsed >= b.decay {
+		return w.capacity
+	}
+
+	return w.capacity + b.size - int(int64(b.size)*int64(elapsed)/int64(b.decay))
+}
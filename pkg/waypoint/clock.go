@@ -0,0 +1,32 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "time"
+
+// A Clock provides the current time to a Waypoint; see WithClock.
+type Clock interface {
+	Now() time.Time
+	Since(time.Time) time.Duration
+}
+
+// WithClock returns an Option that causes the Waypoint to use c for all
+// Worker timestamps and accumulated durations (as well as the timestamps
+// on its Metrics and Events). This is primarily useful for tests wishing
+// to control the passage of time. Note that timers (e.g. those used by
+// WithRateLimit, WithSchedule or WithWatchdog) continue to use real time.
+//
+// A nil Clock is ignored.
+func WithClock(c Clock) Option {
+	return func(w *Waypoint) {
+		if c != nil {
+			w.clock = c
+		}
+	}
+}
+
+// RealClock is the default Clock; it simply defers to package time.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time                  { return time.Now() }
+func (RealClock) Since(t time.Time) time.Duration { return time.Since(t) }
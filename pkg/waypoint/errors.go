@@ -0,0 +1,42 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "fmt"
+
+type errstr string
+
+func (s errstr) Error() string {
+	return string(s)
+}
+
+const (
+	ErrAbandoned       = errstr("waypoint closed with abandoned workers")
+	ErrClosed          = errstr("waypoint is closed")
+	ErrDuplicateName   = errstr("duplicate waypoint name")
+	ErrInvalidCapacity = errstr("invalid waypoint capacity")
+	ErrLeaseExpired    = errstr("worker lease expired")
+	ErrNilWaypoint     = errstr("nil waypoint")
+	ErrStuck           = errstr("worker active beyond watchdog limit")
+	ErrTooManyWaiting  = errstr("too many waiting workers")
+	ErrUnknownName     = errstr("unknown waypoint name")
+	ErrUnnamed         = errstr("waypoint has no name")
+)
+
+// A PanicError is the error passed to an OnGoError function when a function
+// passed to Go panics.
+type PanicError struct {
+	Value any    // The value passed to panic
+	Stack []byte // The stack trace of the panicking goroutine
+}
+
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("waypoint: panic: %v", pe.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error (or nil
+// otherwise).
+func (pe *PanicError) Unwrap() error {
+	err, _ := pe.Value.(error)
+	return err
+}
@@ -0,0 +1,94 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "time"
+
+// EventType identifies the kind of state change described by an Event.
+type EventType string
+
+const (
+	// WorkerCreated events are emitted as each new Worker enters the
+	// Waiting state.
+	WorkerCreated = EventType("WorkerCreated")
+
+	// WorkerActivated events are emitted as each Worker enters the Active
+	// state.
+	WorkerActivated = EventType("WorkerActivated")
+
+	// WorkerFinished events are emitted as each Worker enters the Finished
+	// state.
+	WorkerFinished = EventType("WorkerFinished")
+
+	// Resized events are emitted each time the Waypoint's capacity is
+	// changed.
+	Resized = EventType("Resized")
+
+	// Closed events are emitted the first time the Waypoint's Done (or
+	// CloseContext) method is called.
+	Closed = EventType("Closed")
+)
+
+// EventBufferSize is the number of Events that may be buffered by the
+// channel returned from Events before further Events are dropped.
+const EventBufferSize = 1024
+
+// An Event describes a single state change for a Waypoint or one of its
+// Workers.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	WorkerID uint64 // for Worker events; otherwise zero
+	Capacity int    // the Waypoint's capacity following the event
+
+	// Dropped is the number of Events that were discarded, immediately
+	// prior to this one, because the channel returned by Events was full.
+	Dropped int
+}
+
+// Events returns a channel on which the receiver emits an Event for each
+// Worker state change, as well as each time it is Resized or Closed. The
+// same channel is returned by every call to Events and it is closed once
+// the receiver's Done channel has been closed.
+//
+// Events are sent without blocking; if the channel's buffer (of size
+// EventBufferSize) is full, Events are discarded and the number discarded
+// is reported by the Dropped field of the next Event delivered. No Events
+// are emitted prior to the first call to Events.
+func (w *Waypoint) Events() <-chan Event {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.events == nil {
+		w.events = make(chan Event, EventBufferSize)
+
+		if w.final != nil {
+			close(w.events)
+		}
+	}
+
+	return w.events
+}
+
+// _emit sends an Event of type et to the receiver's Events channel (if
+// anyone has asked for it).
+func (w *Waypoint) _emit(et EventType, id uint64) {
+	if w.events == nil || w.final != nil {
+		return
+	}
+
+	ev := Event{
+		Type:     et,
+		Time:     w.clock.Now(),
+		WorkerID: id,
+		Capacity: w.capacity,
+		Dropped:  w.numDropped,
+	}
+
+	select {
+	case w.events <- ev:
+		w.numDropped = 0
+	default:
+		w.numDropped++
+	}
+}
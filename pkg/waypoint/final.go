@@ -0,0 +1,45 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+// FinalState describes the terminal state of a Waypoint whose Done channel
+// has been closed.
+type FinalState struct {
+	Abandoned int     // Number of Waiting Workers that were abandoned
+	Metrics   Metrics // The Waypoint's Metrics at the moment it finished
+}
+
+// Clean returns true if all of the Workers associated with the receiver's
+// Waypoint were able to reach the Finished state.
+func (fs FinalState) Clean() bool {
+	return fs.Abandoned == 0
+}
+
+// Err returns ErrAbandoned if the receiver's Waypoint was closed while it
+// had Waiting Workers that could never become Active. Otherwise, Err
+// returns nil.
+func (fs FinalState) Err() error {
+	if fs.Clean() {
+		return nil
+	}
+
+	return ErrAbandoned
+}
+
+// FinalState returns the receiver's terminal state and true if the channel
+// returned by its Done method has been closed. Otherwise, a zero FinalState
+// and false are returned.
+func (w *Waypoint) FinalState() (FinalState, bool) {
+	if w == nil {
+		return FinalState{}, false
+	}
+
+	w.RLock()
+	defer w.RUnlock()
+
+	if w.final == nil {
+		return FinalState{}, false
+	}
+
+	return *w.final, true
+}
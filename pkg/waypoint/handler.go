@@ -0,0 +1,124 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// NewHandler returns an http.Handler providing runtime administration for
+// the Waypoints registered with r (or DefaultRegistry if r is nil). The
+// handler serves the following requests (relative to wherever it is
+// mounted; see http.StripPrefix):
+//
+//	GET  /        JSON object of Metrics for all Waypoints, keyed by name
+//	GET  /{name}  JSON Metrics for the named Waypoint
+//	POST /{name}  Adjust the named Waypoint (PUT is equivalent)
+//
+// The body of a POST (or PUT) request is a JSON object holding either a new
+// capacity or a paused state:
+//
+//	{"capacity": 20}
+//	{"paused": true}
+//
+// Pausing a Waypoint sets its capacity to zero; currently Active Workers are
+// unaffected but no Waiting Workers will become Active until the Waypoint is
+// resumed (using {"paused": false}), which restores its previous capacity.
+// Paused state is kept by r (and so is shared by all of its handlers) until
+// the Waypoint is resumed, is given a new capacity (using the handler or the
+// Registry's Resize method) or is removed using Unregister.
+// On success, the named Waypoint's updated Metrics are returned.
+func NewHandler(r *Registry) http.Handler {
+	if r == nil {
+		r = DefaultRegistry
+	}
+
+	h := &handler{reg: r}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", h.list)
+	mux.HandleFunc("GET /{name}", h.get)
+	mux.HandleFunc("POST /{name}", h.update)
+	mux.HandleFunc("PUT /{name}", h.update)
+
+	return mux
+}
+
+type handler struct {
+	reg *Registry
+}
+
+// An adminRequest is the body of a POST (or PUT) request.
+type adminRequest struct {
+	Capacity *int  `json:"capacity"`
+	Paused   *bool `json:"paused"`
+}
+
+func (h *handler) list(rw http.ResponseWriter, _ *http.Request) {
+	writeJSON(rw, http.StatusOK, h.reg.Metrics())
+}
+
+func (h *handler) get(rw http.ResponseWriter, req *http.Request) {
+	w, ok := h.reg.Lookup(req.PathValue("name"))
+	if !ok {
+		http.Error(rw, ErrUnknownName.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, w.Metrics())
+}
+
+func (h *handler) update(rw http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+
+	var ar adminRequest
+	if err := json.NewDecoder(req.Body).Decode(&ar); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		w   *Waypoint
+		err error
+	)
+
+	switch {
+	case ar.Capacity != nil && ar.Paused != nil:
+		http.Error(rw, "capacity and paused are mutually exclusive", http.StatusBadRequest)
+		return
+
+	case ar.Capacity != nil:
+		w, _, err = h.reg.resize(name, *ar.Capacity)
+
+	case ar.Paused != nil && *ar.Paused:
+		w, err = h.reg.pause(name)
+
+	case ar.Paused != nil:
+		w, err = h.reg.resume(name)
+
+	default:
+		http.Error(rw, "one of capacity or paused is required", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case errors.Is(err, ErrUnknownName):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidCapacity):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrClosed):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(rw, http.StatusOK, w.Metrics())
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(v)
+}
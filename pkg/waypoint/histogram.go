@@ -0,0 +1,140 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultBuckets are the histogram bucket boundaries used by WithHistograms
+// when none are specified.
+var DefaultBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// WithHistograms returns an Option that causes the Waypoint to track the
+// distribution of individual Worker wait and active durations using the
+// given bucket boundaries (or DefaultBuckets if none are provided). The
+// resulting Histograms are reported by Metrics.
+func WithHistograms(bounds ...time.Duration) Option {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+
+	b := append([]time.Duration(nil), bounds...)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+
+	return func(w *Waypoint) {
+		w.waitHist = newHistogram(b)
+		w.activeHist = newHistogram(b)
+	}
+}
+
+// A Histogram represents the distribution of a set of durations.
+type Histogram struct {
+	// Bounds holds the inclusive upper bound for each bucket (in ascending
+	// order).
+	Bounds []time.Duration
+
+	// Counts holds the number of observations falling within each bucket.
+	// It has one more element than Bounds; the last being the number of
+	// observations greater than the largest bound.
+	Counts []uint64
+
+	Count uint64        // Total number of observations
+	Sum   time.Duration // Sum of all observations
+}
+
+func newHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// observe records a single duration d.
+func (h *Histogram) observe(d time.Duration) {
+	if h == nil {
+		return
+	}
+
+	i := sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] })
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// clone returns a deep copy of the receiver (or nil if it is nil).
+func (h *Histogram) clone() *Histogram {
+	if h == nil {
+		return nil
+	}
+
+	c := *h
+	c.Counts = append([]uint64(nil), h.Counts...)
+
+	return &c
+}
+
+// sub returns a copy of the receiver with the observations from prev (an
+// earlier clone of the receiver) removed. If prev is nil, the result is
+// simply a clone of the receiver.
+func (h *Histogram) sub(prev *Histogram) *Histogram {
+	c := h.clone()
+	if c == nil || prev == nil {
+		return c
+	}
+
+	for i := range c.Counts {
+		c.Counts[i] -= prev.Counts[i]
+	}
+
+	c.Count -= prev.Count
+	c.Sum -= prev.Sum
+
+	return c
+}
+
+// reset discards all of the receiver's observations.
+func (h *Histogram) reset() {
+	if h == nil {
+		return
+	}
+
+	clear(h.Counts)
+	h.Count = 0
+	h.Sum = 0
+}
+
+// Quantile returns an estimate for the q-th quantile (0 <= q <= 1) of the
+// receiver's observations. The estimate is the upper bound of the bucket
+// within which the quantile falls; if that is the overflow bucket, the
+// largest bound is returned. Zero is returned for an empty Histogram.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h == nil || h.Count == 0 || len(h.Bounds) == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(h.Count))
+	if rank >= h.Count {
+		rank = h.Count - 1
+	}
+
+	var seen uint64
+	for i, n := range h.Counts {
+		if seen += n; seen > rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+
+	return h.Bounds[len(h.Bounds)-1]
+}
@@ -0,0 +1,55 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+// A Hook is a callback function invoked as a Worker transitions from one
+// State to another. Hooks are always called without holding the Waypoint's
+// lock so they are free to call methods on the Worker or its Waypoint
+// (e.g. Metrics). However, since Hooks are called synchronously by the
+// goroutine causing the transition, long running Hooks will delay that
+// goroutine accordingly.
+type Hook func(*Worker)
+
+// OnWaitStart returns an Option that registers h to be called each time
+// a new Worker enters the Waiting state. Note that a Worker created by
+// Wait is visible to h even though Waiting workers are never returned to
+// the caller.
+func OnWaitStart(h Hook) Option {
+	return func(w *Waypoint) {
+		w.hooks.waitStart = append(w.hooks.waitStart, h)
+	}
+}
+
+// OnActivate returns an Option that registers h to be called each time a
+// Worker enters the Active state. It is called before Wait returns.
+func OnActivate(h Hook) Option {
+	return func(w *Waypoint) {
+		w.hooks.activate = append(w.hooks.activate, h)
+	}
+}
+
+// OnFinish returns an Option that registers h to be called each time a
+// Worker enters the Finished state. It is called before Done returns.
+func OnFinish(h Hook) Option {
+	return func(w *Waypoint) {
+		w.hooks.finish = append(w.hooks.finish, h)
+	}
+}
+
+type (
+	hooks struct {
+		waitStart hookList
+		activate  hookList
+		finish    hookList
+	}
+
+	hookList []Hook
+)
+
+// call invokes each of the receiver's Hooks, in the order registered,
+// using the provided Worker.
+func (hl hookList) call(w *Worker) {
+	for _, h := range hl {
+		h(w)
+	}
+}
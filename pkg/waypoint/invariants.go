@@ -0,0 +1,62 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "fmt"
+
+// CheckInvariants verifies the internal consistency of the receiver and
+// returns an error describing the first violation found (or nil if there
+// are none). It is intended for use by tests and stress tools that
+// exercise a Waypoint under heavy concurrency; it should never return an
+// error and, if it does, that is a bug in this package.
+func (w *Waypoint) CheckInvariants() error {
+	if w == nil {
+		return nil
+	}
+
+	w.RLock()
+	defer w.RUnlock()
+
+	switch {
+	case w.numWaiting < 0:
+		return fmt.Errorf("waypoint: negative waiting count: %d", w.numWaiting)
+
+	case w.reserved < 0:
+		return fmt.Errorf("waypoint: negative reserved count: %d", w.reserved)
+
+	case w.reserved > w.numWaiting:
+		return fmt.Errorf("waypoint: reserved count %d exceeds waiting count %d", w.reserved, w.numWaiting)
+
+	case w.capacity < 0:
+		return fmt.Errorf("waypoint: negative capacity: %d", w.capacity)
+
+	case w.final != nil && (!w.closed || len(w.active) > 0):
+		return fmt.Errorf("waypoint: finished with closed=%v and %d active workers", w.closed, len(w.active))
+	}
+
+	for id, a := range w.active {
+		if a.ID != id || a.State != Active {
+			return fmt.Errorf("waypoint: worker %d in active set with ID %d and state %s", id, a.ID, a.State)
+		}
+
+		if a.waypoint != w {
+			return fmt.Errorf("waypoint: worker %d belongs to another waypoint", id)
+		}
+	}
+
+	if w.quota != nil {
+		total := 0
+		for k, n := range w.quota.counts {
+			if n <= 0 || n > w.quota.limit {
+				return fmt.Errorf("waypoint: quota count %d for %q outside of (0, %d]", n, k, w.quota.limit)
+			}
+			total += n
+		}
+
+		if total > w._inUse() {
+			return fmt.Errorf("waypoint: quota total %d exceeds in-use count %d", total, w._inUse())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Labels are arbitrary key/value pairs attached to a Worker to aid in
+// identifying the work it is performing.
+type Labels map[string]string
+
+// clone returns a copy of the receiver (or nil if it is empty).
+func (l Labels) clone() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+
+	c := make(Labels, len(l))
+	for k, v := range l {
+		c[k] = v
+	}
+
+	return c
+}
+
+type labelsKey struct{}
+
+// WithLabels returns a copy of ctx carrying the provided Labels, merged with
+// any Labels already carried by ctx. A Worker created by a call to Wait
+// using the returned Context will have these Labels attached.
+func WithLabels(ctx context.Context, labels Labels) context.Context {
+	merged := LabelsFrom(ctx)
+	if merged == nil {
+		merged = make(Labels, len(labels))
+	}
+
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	return context.WithValue(ctx, labelsKey{}, merged)
+}
+
+// LabelsFrom returns a copy of the Labels carried by ctx (or nil if none).
+func LabelsFrom(ctx context.Context) Labels {
+	l, _ := ctx.Value(labelsKey{}).(Labels)
+	return l.clone()
+}
+
+// Labels returns a copy of the receiver's Labels.
+func (w *Worker) Labels() Labels {
+	return w.labels.clone()
+}
+
+// WorkerInfo is a point-in-time snapshot of a single Worker.
+type WorkerInfo struct {
+	ID      uint64
+	State   State
+	Created time.Time
+	Started time.Time
+	Labels  Labels
+	Stuck   bool // see WithWatchdog
+}
+
+// Workers returns a snapshot of the receiver's currently Active Workers,
+// ordered by ID. This can be useful for discovering what is holding a
+// Waypoint's capacity.
+func (w *Waypoint) Workers() []WorkerInfo {
+	if w == nil {
+		return nil
+	}
+
+	w.RLock()
+	defer w.RUnlock()
+
+	infos := make([]WorkerInfo, 0, len(w.active))
+	for _, a := range w.active {
+		infos = append(infos, a._info())
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	return infos
+}
+
+// _info returns a WorkerInfo snapshot of the receiver.
+func (w *Worker) _info() WorkerInfo {
+	return WorkerInfo{
+		ID:      w.ID,
+		State:   w.State,
+		Created: w.created,
+		Started: w.started,
+		Labels:  w.labels.clone(),
+		Stuck:   w.stuck,
+	}
+}
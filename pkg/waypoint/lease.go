@@ -0,0 +1,125 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "time"
+
+// WithLease returns an Option that causes each Active Worker to hold its
+// Waypoint's capacity under a lease of the given duration. A Worker's lease
+// begins when it becomes Active and is renewed by each call to its Heartbeat
+// method. If a Worker's lease expires, its Waypoint considers it abandoned:
+// the Worker is moved to the Finished state (as if Done had been called) and
+// its capacity is reclaimed for use by others. Leases are inspected by a
+// separate goroutine that runs only while the Waypoint has Waiting or Active
+// Workers, so neither calling Done nor any other cleanup is required.
+//
+// If onExpire is not nil, it is called (without holding the Waypoint's lock)
+// with a snapshot of each Worker whose lease has expired. If the Waypoint
+// was also created using a Watchdog with Cancel set, the expired Worker's
+// Context is canceled with ErrLeaseExpired as its cause.
+//
+// Since an expired Worker may still be in use by its goroutine, its tracing
+// span is ended, and OnFinish Hooks are called, only if (and when) its Done
+// method is eventually called.
+//
+// Durations less than or equal to zero are ignored.
+func WithLease(d time.Duration, onExpire func(WorkerInfo)) Option {
+	return func(w *Waypoint) {
+		if d <= 0 {
+			return
+		}
+
+		w.lease = &lease{duration: d, onExpire: onExpire}
+	}
+}
+
+type lease struct {
+	duration time.Duration
+	onExpire func(WorkerInfo)
+}
+
+// Heartbeat renews the receiver's lease (see WithLease) and returns nil. If
+// the receiver's lease has already expired, ErrLeaseExpired is returned and
+// the receiver should cease its work since its capacity has been reclaimed
+// by its Waypoint. Heartbeat is a no-op for Workers without a lease.
+func (w *Worker) Heartbeat() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.expired {
+		return ErrLeaseExpired
+	}
+
+	w.lastBeat = w.clock.Now()
+
+	return nil
+}
+
+// runLeases expires the leases of silent Workers until idle is closed (see
+// _startMonitors) or the receiver's Done channel is closed.
+func (w *Waypoint) runLeases(idle <-chan struct{}) {
+	// n.b. A ticker's interval must be positive, even for a 1ns lease.
+	ticker := time.NewTicker(max(w.lease.duration/4, 1))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-idle:
+			return
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		w.expireLeases()
+	}
+}
+
+// expireLeases reclaims the capacity held by any of the receiver's Active
+// Workers whose lease has expired.
+func (w *Waypoint) expireLeases() {
+	type expiry struct {
+		worker *Worker
+		parent *Worker // from the parent Waypoint (if any)
+		info   WorkerInfo
+	}
+
+	var expired []expiry
+
+	w.Lock()
+
+	for _, a := range w.active {
+		if w.clock.Since(a.lastBeat) < w.lease.duration {
+			continue
+		}
+
+		expired = append(expired, expiry{worker: a, parent: a.parent})
+	}
+
+	for i := range expired {
+		a := expired[i].worker
+
+		if a.cancel != nil {
+			a.cancel(ErrLeaseExpired)
+		}
+
+		a._finish()
+		a.expired = true
+		a.parent = nil
+		w.numExpired++
+
+		expired[i].info = a._info()
+	}
+
+	w.Unlock()
+
+	for _, e := range expired {
+		if e.parent != nil {
+			e.parent.Done()
+		}
+
+		if w.lease.onExpire != nil {
+			w.lease.onExpire(e.info)
+		}
+	}
+}
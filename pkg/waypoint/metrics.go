@@ -3,6 +3,8 @@
 package waypoint
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -13,8 +15,25 @@ type Metrics struct {
 	Waiting    int           // Current number of waiting Workers
 	Active     int           // Current number of active Workers
 	Finished   int           // Current number of finished Workers
+	Stuck      int           // Current number of stuck Workers (see WithWatchdog)
+	Expired    int           // Total number of expired Workers (see WithLease)
 	WaitTime   time.Duration // Total accumulated Wait time
 	ActiveTime time.Duration // Total accumulated Active time
+
+	// LongestWait is how long the longest currently Waiting Worker has been
+	// waiting. Starved is the current number of Workers that have been
+	// Waiting longer than the threshold set by WithStarvationThreshold and
+	// TotalStarved is the total number of Workers that became Active only
+	// after waiting longer than that threshold. Both are zero unless the
+	// Waypoint was created using WithStarvationThreshold.
+	LongestWait  time.Duration
+	Starved      int
+	TotalStarved int
+
+	// Distributions of individual Worker wait and active durations. These
+	// are nil unless the Waypoint was created using WithHistograms.
+	WaitHistogram   *Histogram
+	ActiveHistogram *Histogram
 }
 
 // Metrics returns a point-in-time Metrics value for the receiver.
@@ -26,13 +45,211 @@ func (w *Waypoint) Metrics() Metrics {
 	w.RLock()
 	defer w.RUnlock()
 
+	return w._metrics()
+}
+
+func (w *Waypoint) _metrics() Metrics {
+	now := w.clock.Now()
+	longest, starved := w._starvation(now)
+
 	return Metrics{
-		Timestamp:  time.Now(),
+		Timestamp:  now,
 		Capacity:   w.capacity,
 		Waiting:    w.numWaiting,
 		Active:     len(w.active),
 		Finished:   w.numFinished,
+		Stuck:      w.numStuck,
+		Expired:    w.numExpired,
 		WaitTime:   w.waitTime,
 		ActiveTime: w.activeTime,
+
+		LongestWait:  longest,
+		Starved:      starved,
+		TotalStarved: w.numStarved,
+
+		WaitHistogram:   w.waitHist.clone(),
+		ActiveHistogram: w.activeHist.clone(),
+	}
+}
+
+// MetricsDelta is similar to Metrics except that the cumulative values it
+// reports (i.e. Finished, WaitTime, ActiveTime and the Histograms) include
+// only that which has accumulated since the previous call to MetricsDelta
+// (or ResetMetrics). The first call reports everything accumulated since
+// the receiver was created. Point-in-time values (e.g. Capacity, Waiting
+// and Active) are reported as-is.
+func (w *Waypoint) MetricsDelta() Metrics {
+	if w == nil {
+		return Metrics{}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	cur := w._metrics()
+	delta := cur
+
+	if prev := w.lastDelta; prev != nil {
+		delta.Finished -= prev.Finished
+		delta.WaitTime -= prev.WaitTime
+		delta.ActiveTime -= prev.ActiveTime
+		delta.TotalStarved -= prev.TotalStarved
+		delta.WaitHistogram = cur.WaitHistogram.sub(prev.WaitHistogram)
+		delta.ActiveHistogram = cur.ActiveHistogram.sub(prev.ActiveHistogram)
+	}
+
+	w.lastDelta = &cur
+
+	return delta
+}
+
+// ResetMetrics zeroes the receiver's cumulative metrics (i.e. Finished,
+// WaitTime, ActiveTime and the Histograms) as well as the baseline used by
+// MetricsDelta. Point-in-time values (e.g. Capacity, Waiting and Active)
+// are unaffected.
+//
+// Note that AutoScale inspects the difference between successive Metrics;
+// resetting metrics for a Waypoint controlled by AutoScale causes it to
+// skip its next adjustment.
+func (w *Waypoint) ResetMetrics() {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.numFinished = 0
+	w.waitTime = 0
+	w.activeTime = 0
+	w.numStarved = 0
+	w.waitHist.reset()
+	w.activeHist.reset()
+	w.lastDelta = nil
+}
+
+// String returns a compact, human-readable representation of the receiver
+// suitable for logging.
+func (m Metrics) String() string {
+	return fmt.Sprintf("capacity=%d waiting=%d active=%d finished=%d stuck=%d expired=%d wait_time=%v active_time=%v longest_wait=%v starved=%d total_starved=%d",
+		m.Capacity, m.Waiting, m.Active, m.Finished, m.Stuck, m.Expired, m.WaitTime, m.ActiveTime, m.LongestWait, m.Starved, m.TotalStarved)
+}
+
+// metricsJSON defines the stable JSON representation of Metrics. Durations
+// are reported both in nanoseconds and as human-readable strings.
+type metricsJSON struct {
+	Timestamp       time.Time      `json:"timestamp"`
+	Capacity        int            `json:"capacity"`
+	Waiting         int            `json:"waiting"`
+	Active          int            `json:"active"`
+	Finished        int            `json:"finished"`
+	Stuck           int            `json:"stuck"`
+	Expired         int            `json:"expired"`
+	WaitTimeNS      int64          `json:"wait_time_ns"`
+	WaitTime        string         `json:"wait_time"`
+	ActiveTimeNS    int64          `json:"active_time_ns"`
+	ActiveTime      string         `json:"active_time"`
+	LongestWaitNS   int64          `json:"longest_wait_ns"`
+	LongestWait     string         `json:"longest_wait"`
+	Starved         int            `json:"starved"`
+	TotalStarved    int            `json:"total_starved"`
+	WaitHistogram   *histogramJSON `json:"wait_histogram,omitempty"`
+	ActiveHistogram *histogramJSON `json:"active_histogram,omitempty"`
+}
+
+type histogramJSON struct {
+	BoundsNS []int64  `json:"bounds_ns"`
+	Counts   []uint64 `json:"counts"`
+	Count    uint64   `json:"count"`
+	SumNS    int64    `json:"sum_ns"`
+	Sum      string   `json:"sum"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m Metrics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metricsJSON{
+		Timestamp:       m.Timestamp,
+		Capacity:        m.Capacity,
+		Waiting:         m.Waiting,
+		Active:          m.Active,
+		Finished:        m.Finished,
+		Stuck:           m.Stuck,
+		Expired:         m.Expired,
+		WaitTimeNS:      int64(m.WaitTime),
+		WaitTime:        m.WaitTime.String(),
+		ActiveTimeNS:    int64(m.ActiveTime),
+		ActiveTime:      m.ActiveTime.String(),
+		LongestWaitNS:   int64(m.LongestWait),
+		LongestWait:     m.LongestWait.String(),
+		Starved:         m.Starved,
+		TotalStarved:    m.TotalStarved,
+		WaitHistogram:   m.WaitHistogram.toJSON(),
+		ActiveHistogram: m.ActiveHistogram.toJSON(),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *Metrics) UnmarshalJSON(data []byte) error {
+	var mj metricsJSON
+	if err := json.Unmarshal(data, &mj); err != nil {
+		return err
+	}
+
+	*m = Metrics{
+		Timestamp:       mj.Timestamp,
+		Capacity:        mj.Capacity,
+		Waiting:         mj.Waiting,
+		Active:          mj.Active,
+		Finished:        mj.Finished,
+		Stuck:           mj.Stuck,
+		Expired:         mj.Expired,
+		WaitTime:        time.Duration(mj.WaitTimeNS),
+		ActiveTime:      time.Duration(mj.ActiveTimeNS),
+		LongestWait:     time.Duration(mj.LongestWaitNS),
+		Starved:         mj.Starved,
+		TotalStarved:    mj.TotalStarved,
+		WaitHistogram:   mj.WaitHistogram.fromJSON(),
+		ActiveHistogram: mj.ActiveHistogram.fromJSON(),
+	}
+
+	return nil
+}
+
+func (h *Histogram) toJSON() *histogramJSON {
+	if h == nil {
+		return nil
 	}
+
+	hj := &histogramJSON{
+		BoundsNS: make([]int64, len(h.Bounds)),
+		Counts:   h.Counts,
+		Count:    h.Count,
+		SumNS:    int64(h.Sum),
+		Sum:      h.Sum.String(),
+	}
+
+	for i, b := range h.Bounds {
+		hj.BoundsNS[i] = int64(b)
+	}
+
+	return hj
+}
+
+func (hj *histogramJSON) fromJSON() *Histogram {
+	if hj == nil {
+		return nil
+	}
+
+	h := &Histogram{
+		Bounds: make([]time.Duration, len(hj.BoundsNS)),
+		Counts: hj.Counts,
+		Count:  hj.Count,
+		Sum:    time.Duration(hj.SumNS),
+	}
+
+	for i, b := range hj.BoundsNS {
+		h.Bounds[i] = time.Duration(b)
+	}
+
+	return h
 }
@@ -0,0 +1,39 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "github.com/go-sage/synctools/internal/options"
+
+// An Option alters the default behavior of a Waypoint. Options are passed
+// to New (or Child) and are applied in the order provided. Since Options are
+// applied before New returns, they never race with concurrent use of the
+// Waypoint.
+type Option = options.Option[Waypoint]
+
+// WithName returns an Option that sets the Waypoint's name. A Waypoint's
+// name is purely informational; it is never required to be unique.
+func WithName(name string) Option {
+	return func(w *Waypoint) {
+		w.name = name
+	}
+}
+
+// Name returns the name assigned to the receiver using WithName.
+func (w *Waypoint) Name() string {
+	if w == nil {
+		return ""
+	}
+
+	return w.name
+}
+
+// WithMaxWaiting returns an Option that limits the number of Workers that
+// may be blocked in the Waiting state at any one time. Once this limit is
+// reached, calls to Wait that cannot immediately provide an Active Worker
+// fail with ErrTooManyWaiting instead of blocking. Values less than 1 mean
+// there is no limit (the default).
+func WithMaxWaiting(n int) Option {
+	return func(w *Waypoint) {
+		w.maxWaiting = max(n, 0)
+	}
+}
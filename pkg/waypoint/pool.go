@@ -0,0 +1,87 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"sort"
+	"sync"
+)
+
+// A Pool is a shared source of capacity from which multiple named Waypoints
+// may borrow; the sum of Active Workers across all of a Pool's Waypoints
+// never exceeds the Pool's limit. This is useful for modeling constraints
+// (such as "total database connections") that span several independent
+// streams of work. Each Waypoint in a Pool is a Child of the Pool's own
+// internal Waypoint.
+type Pool struct {
+	root   *Waypoint
+	byname map[string]*Waypoint
+	mu     sync.Mutex
+}
+
+// NewPool returns a new Pool with the provided limit. Any provided Options
+// are applied to the Pool's internal Waypoint.
+func NewPool(limit int, opts ...Option) *Pool {
+	return &Pool{
+		root:   New(limit, opts...),
+		byname: make(map[string]*Waypoint),
+	}
+}
+
+// Waypoint returns the receiver's Waypoint with the given name, creating it
+// (with the provided capacity and Options) if it does not yet exist. If the
+// named Waypoint already exists, capacity and opts are ignored.
+//
+// A Waypoint's capacity further limits its own Workers. To allow a single
+// Waypoint to consume the entire Pool, use a capacity equal to the Pool's
+// limit.
+func (p *Pool) Waypoint(name string, capacity int, opts ...Option) *Waypoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if w, ok := p.byname[name]; ok {
+		return w
+	}
+
+	w := p.root.Child(capacity, append([]Option{WithName(name)}, opts...)...)
+	p.byname[name] = w
+
+	return w
+}
+
+// Lookup returns the receiver's Waypoint with the given name and true, or
+// nil and false if no such Waypoint exists.
+func (p *Pool) Lookup(name string) (*Waypoint, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w, ok := p.byname[name]
+	return w, ok
+}
+
+// Names returns the sorted names of all of the receiver's Waypoints.
+func (p *Pool) Names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.byname))
+	for name := range p.byname {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Resize sets the receiver's limit to newlimit and returns the previous
+// value. See (*Waypoint).Resize for details.
+func (p *Pool) Resize(newlimit int) (int, error) {
+	return p.root.Resize(newlimit)
+}
+
+// Metrics returns point-in-time Metrics for the receiver as a whole; the
+// Capacity field holds the Pool's limit.
+func (p *Pool) Metrics() Metrics {
+	return p.root.Metrics()
+}
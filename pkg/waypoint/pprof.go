@@ -0,0 +1,63 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// ProfilerWaypointLabel is the pprof label key used to identify a Worker's
+// Waypoint (by name) when WithProfilerLabels is in effect.
+const ProfilerWaypointLabel = "waypoint"
+
+// WithProfilerLabels returns an Option that gives each Worker, upon becoming
+// Active, a set of pprof labels including the Waypoint's name (using the key
+// ProfilerWaypointLabel) along with each of the Worker's Labels (see
+// WithLabels). This makes CPU profiles attributable to specific Waypoints
+// and tenants.
+//
+// The labels are applied to the goroutine doing the Worker's work only for
+// the duration of a call to its Do method, which the Go method does on the
+// caller's behalf. They are also carried by the Context returned from the
+// Worker's Context method. Neither Wait nor Done change the labels of the
+// goroutine calling them.
+func WithProfilerLabels() Option {
+	return func(w *Waypoint) {
+		w.pprofLabels = true
+	}
+}
+
+// setProfilerLabels prepares the receiver's pprof labels (if the receiver's
+// Waypoint was created WithProfilerLabels) for use by Do.
+func (w *Worker) setProfilerLabels() {
+	if !w.pprofLabels {
+		return
+	}
+
+	kv := make([]string, 0, 2*len(w.labels)+2)
+	kv = append(kv, ProfilerWaypointLabel, w.name)
+
+	for k, v := range w.labels {
+		kv = append(kv, k, v)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.profile = pprof.Labels(kv...)
+	w.ctx = pprof.WithLabels(w.ctx, w.profile)
+}
+
+// Do calls fn, passing it ctx, on behalf of the receiver. If the receiver's
+// Waypoint was created WithProfilerLabels, the calling goroutine carries the
+// receiver's pprof labels until fn returns (see pprof.Do) as does the Context
+// passed to fn.
+func (w *Worker) Do(ctx context.Context, fn func(context.Context)) {
+	if !w.pprofLabels {
+		fn(ctx)
+		return
+	}
+
+	pprof.Do(ctx, w.profile, fn)
+}
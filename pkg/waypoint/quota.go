@@ -0,0 +1,93 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+// WithQuota returns an Option that limits the number of Workers that may be
+// Active at the same time for each distinct value of the named label (see
+// WithLabels) -- in addition to the Waypoint's overall capacity. For example,
+// a Waypoint created with:
+//
+//	wp := waypoint.New(100, waypoint.WithQuota("tenant", 10))
+//
+// allows up to 100 Active Workers overall but no more than 10 for any single
+// "tenant". Workers whose Labels do not include the named label are not
+// subject to the quota. A Worker held back by its quota does not block other
+// Waiting Workers from becoming Active.
+//
+// Limits less than 1 are ignored.
+func WithQuota(label string, limit int) Option {
+	return func(w *Waypoint) {
+		if limit < 1 {
+			return
+		}
+
+		w.quota = &quota{
+			label:  label,
+			limit:  limit,
+			counts: make(map[string]int),
+		}
+	}
+}
+
+type quota struct {
+	label  string
+	limit  int
+	counts map[string]int // of Active (or reserved) Workers per label value
+}
+
+// key returns the receiver's label value for Worker a and whether a is
+// subject to the receiver's limit.
+func (q *quota) key(a *Worker) (string, bool) {
+	if q == nil {
+		return "", false
+	}
+
+	k, ok := a.labels[q.label]
+	return k, ok
+}
+
+// QuotaUsage returns the number of Active Workers for each distinct value
+// of the label configured using WithQuota, or nil if the receiver has no
+// quota (or is nil).
+func (w *Waypoint) QuotaUsage() map[string]int {
+	if w == nil {
+		return nil
+	}
+
+	w.RLock()
+	defer w.RUnlock()
+
+	if w.quota == nil {
+		return nil
+	}
+
+	usage := make(map[string]int, len(w.quota.counts))
+	for k, n := range w.quota.counts {
+		usage[k] = n
+	}
+
+	return usage
+}
+
+// _quotaOK returns true if Worker a may be granted capacity without
+// exceeding the receiver's quota.
+func (w *Waypoint) _quotaOK(a *Worker) bool {
+	k, ok := w.quota.key(a)
+	return !ok || w.quota.counts[k] < w.quota.limit
+}
+
+// _quotaTake charges Worker a against the receiver's quota.
+func (w *Waypoint) _quotaTake(a *Worker) {
+	if k, ok := w.quota.key(a); ok {
+		w.quota.counts[k]++
+	}
+}
+
+// _quotaRelease returns the quota previously charged for Worker a.
+func (w *Waypoint) _quotaRelease(a *Worker) {
+	if k, ok := w.quota.key(a); ok {
+		if w.quota.counts[k]--; w.quota.counts[k] <= 0 {
+			delete(w.quota.counts, k)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"math"
+	"time"
+)
+
+// WithRateLimit returns an Option that causes the Waypoint to limit the rate
+// at which Workers become Active, in addition to limiting how many may be
+// Active concurrently. Activations are governed by a token bucket that is
+// refilled at perSecond tokens per second and holds at most burst tokens
+// (burst values less than 1 are treated as 1). Each activation consumes one
+// token; Waiting Workers remain blocked until both capacity and a token are
+// available.
+func WithRateLimit(perSecond float64, burst int) Option {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return func(w *Waypoint) {
+		if perSecond <= 0 {
+			return
+		}
+
+		w.limiter = &tokenBucket{
+			rate:   perSecond,
+			burst:  float64(burst),
+			tokens: float64(burst),
+		}
+	}
+}
+
+type tokenBucket struct {
+	rate   float64 // tokens added per second
+	burst  float64 // maximum number of tokens
+	tokens float64 // currently available tokens
+	last   time.Time
+}
+
+// take consumes a single token and returns zero if one is available at
+// time now. Otherwise, no token is consumed and the time remaining until
+// one becomes available is returned.
+func (tb *tokenBucket) take(now time.Time) time.Duration {
+	if tb == nil {
+		return 0
+	}
+
+	if !tb.last.IsZero() {
+		tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	}
+	tb.last = now
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0
+	}
+
+	need := (1 - tb.tokens) / tb.rate
+	return time.Duration(math.Ceil(need * float64(time.Second)))
+}
+
+// _admit returns true if the receiver's rate limit (if any) permits the
+// activation of another Worker right now. If not, a timer is armed to
+// dispatch Waiting Workers once a token becomes available.
+func (w *Waypoint) _admit() bool {
+	delay := w.limiter.take(w.clock.Now())
+	if delay == 0 {
+		return true
+	}
+
+	if !w.rateTimer {
+		w.rateTimer = true
+		time.AfterFunc(delay, func() {
+			w.Lock()
+			defer w.Unlock()
+			w.rateTimer = false
+			w._dispatch()
+		})
+	}
+
+	return false
+}
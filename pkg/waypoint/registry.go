@@ -0,0 +1,186 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"sort"
+	"sync"
+)
+
+// A Registry is a collection of Waypoints, indexed by name, which may be
+// inspected and administered collectively. Services creating many Waypoints
+// may use a Registry as a single place from which to enumerate, resize, and
+// scrape metrics for all of them.
+//
+// The zero value is not usable; use NewRegistry (or DefaultRegistry).
+type Registry struct {
+	byname map[string]*Waypoint
+	paused map[string]int // capacity prior to pausing; see NewHandler
+	mu     sync.RWMutex
+}
+
+// DefaultRegistry is a package level Registry provided for convenience.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{byname: make(map[string]*Waypoint), paused: make(map[string]int)}
+}
+
+// Register adds w to the receiver using its name (see WithName). It returns
+// ErrNilWaypoint if w is nil, ErrUnnamed if w has no name, or ErrDuplicateName
+// if another Waypoint is already registered using the same name.
+//
+// Note that Waypoints remain registered (even after they are closed) until
+// they are explicitly removed by Unregister.
+func (r *Registry) Register(w *Waypoint) error {
+	switch {
+	case w == nil:
+		return ErrNilWaypoint
+	case w.name == "":
+		return ErrUnnamed
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byname[w.name]; ok {
+		return ErrDuplicateName
+	}
+
+	r.byname[w.name] = w
+
+	return nil
+}
+
+// Unregister removes the Waypoint with the given name from the receiver and
+// returns true, or false if no such Waypoint is registered. Any paused state
+// for that name (see NewHandler) is discarded along with it.
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.byname[name]
+	delete(r.byname, name)
+	delete(r.paused, name)
+
+	return ok
+}
+
+// Lookup returns the registered Waypoint with the given name and true, or
+// nil and false if no such Waypoint is registered.
+func (r *Registry) Lookup(name string) (*Waypoint, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	w, ok := r.byname[name]
+	return w, ok
+}
+
+// Names returns the sorted names of all registered Waypoints.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.byname))
+	for name := range r.byname {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Resize calls Resize on the registered Waypoint with the given name and
+// returns its results; the Waypoint is no longer considered paused (see
+// NewHandler). If no such Waypoint is registered, zero and ErrUnknownName are
+// returned.
+func (r *Registry) Resize(name string, newcap int) (int, error) {
+	_, oldcap, err := r.resize(name, newcap)
+	return oldcap, err
+}
+
+// resize sets the capacity of the registered Waypoint with the given name
+// and discards any paused state held for it. It returns the Waypoint along
+// with the results of its Resize method, or ErrUnknownName if no such
+// Waypoint is registered.
+func (r *Registry) resize(name string, newcap int) (*Waypoint, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.byname[name]
+	if !ok {
+		return nil, 0, ErrUnknownName
+	}
+
+	delete(r.paused, name)
+
+	oldcap, err := w.Resize(newcap)
+
+	return w, oldcap, err
+}
+
+// pause sets the capacity of the registered Waypoint with the given name to
+// zero, remembering its current value (unless it is already paused). It
+// returns the Waypoint, or ErrUnknownName if no such Waypoint is registered.
+func (r *Registry) pause(name string) (*Waypoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.byname[name]
+	if !ok {
+		return nil, ErrUnknownName
+	}
+
+	if _, ok := r.paused[name]; ok {
+		return w, nil
+	}
+
+	oldcap, err := w.Resize(0)
+	if err == nil {
+		r.paused[name] = oldcap
+	}
+
+	return w, err
+}
+
+// resume restores the capacity of the registered Waypoint with the given
+// name from before it was paused (if it is). It returns the Waypoint, or
+// ErrUnknownName if no such Waypoint is registered.
+func (r *Registry) resume(name string) (*Waypoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.byname[name]
+	if !ok {
+		return nil, ErrUnknownName
+	}
+
+	oldcap, ok := r.paused[name]
+	if !ok {
+		return w, nil
+	}
+
+	if _, err := w.Resize(oldcap); err != nil {
+		return w, err
+	}
+
+	delete(r.paused, name)
+
+	return w, nil
+}
+
+// Metrics returns point-in-time Metrics for each registered Waypoint keyed
+// by name.
+func (r *Registry) Metrics() map[string]Metrics {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	metrics := make(map[string]Metrics, len(r.byname))
+	for name, w := range r.byname {
+		metrics[name] = w.Metrics()
+	}
+
+	return metrics
+}
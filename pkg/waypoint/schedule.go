@@ -0,0 +1,132 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// A ScheduleEntry sets a Waypoint's capacity beginning at a specific time
+// of day; it remains in effect until the time of the next ScheduleEntry.
+type ScheduleEntry struct {
+	At       time.Duration // offset from midnight; e.g. 9 * time.Hour
+	Capacity int
+}
+
+// A Schedule is a daily sequence of capacity values; see WithSchedule.
+type Schedule struct {
+	// Entries are the capacity values for each part of the day (in any
+	// order). The last entry of the day remains in effect until the first
+	// entry of the next.
+	Entries []ScheduleEntry
+
+	// Location is the time zone used to determine the time of day.
+	// Default: time.Local
+	Location *time.Location
+}
+
+// WithSchedule returns an Option that adjusts the Waypoint's capacity
+// according to the provided Schedule. The capacity provided to New is
+// replaced by the Schedule's current value and the Waypoint is resized
+// (by a separate goroutine) each time another ScheduleEntry takes effect.
+//
+// The goroutine runs only while the Waypoint has Waiting or Active Workers,
+// so neither calling Done nor any other cleanup is required. While the
+// Waypoint is idle, its capacity is not updated until the next call to
+// Wait (i.e. its Metrics may report the capacity of an earlier entry).
+//
+// For example, to run with a capacity of 50 during business hours and 200
+// overnight:
+//
+//	wp := waypoint.New(0, waypoint.WithSchedule(waypoint.Schedule{
+//		Entries: []waypoint.ScheduleEntry{
+//			{At: 9 * time.Hour, Capacity: 50},
+//			{At: 17 * time.Hour, Capacity: 200},
+//		},
+//	}))
+//
+// Note that a manual call to Resize remains in effect only until the next
+// ScheduleEntry takes effect. A Schedule without any Entries is ignored.
+func WithSchedule(s Schedule) Option {
+	return func(w *Waypoint) {
+		if len(s.Entries) == 0 {
+			return
+		}
+
+		if s.Location == nil {
+			s.Location = time.Local
+		}
+
+		s.Entries = slices.Clone(s.Entries)
+		slices.SortStableFunc(s.Entries, func(a, b ScheduleEntry) int {
+			return cmp.Compare(a.At, b.At)
+		})
+
+		w.schedule = &s
+	}
+}
+
+// capacityAt returns the capacity in effect at time t along with the time
+// at which it is next due to change.
+func (s *Schedule) capacityAt(t time.Time) (int, time.Time) {
+	t = t.In(s.Location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.Location)
+	offset := t.Sub(midnight)
+
+	// Before the first entry of the day, the last entry from the previous
+	// day remains in effect.
+	cur := s.Entries[len(s.Entries)-1]
+
+	for _, e := range s.Entries {
+		if e.At > offset {
+			return cur.Capacity, midnight.Add(e.At)
+		}
+		cur = e
+	}
+
+	return cur.Capacity, midnight.AddDate(0, 0, 1).Add(s.Entries[0].At)
+}
+
+// _applySchedule sets the receiver's capacity to that of its Schedule if
+// another ScheduleEntry has taken effect since it was last set.
+func (w *Waypoint) _applySchedule() {
+	now := w.clock.Now()
+	if now.Before(w.schedNext) {
+		return
+	}
+
+	var newcap int
+	newcap, w.schedNext = w.schedule.capacityAt(now)
+	w._resize(newcap)
+}
+
+// runSchedule resizes the receiver according to its Schedule, beginning at
+// time next, until idle is closed (see _startMonitors) or the receiver is
+// closed.
+func (w *Waypoint) runSchedule(idle <-chan struct{}, next time.Time) {
+	timer := time.NewTimer(next.Sub(w.clock.Now()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-idle:
+			return
+		case <-w.done:
+			return
+		case <-timer.C:
+		}
+
+		w.Lock()
+		if w.closed {
+			w.Unlock()
+			return
+		}
+		w._applySchedule()
+		next = w.schedNext
+		w.Unlock()
+
+		timer.Reset(next.Sub(w.clock.Now()))
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"context"
+	"sync"
+)
+
+// A Semaphore adapts a Waypoint to the method set of the Weighted type from
+// package golang.org/x/sync/semaphore so that code written against that type
+// may gain the observability of a Waypoint without rewriting call sites.
+// Each unit of weight acquired from a Semaphore is held by an Active Worker
+// of its Waypoint; hence, the Semaphore's size is the Waypoint's capacity.
+//
+// Like a Weighted semaphore, calls to Acquire are served in order; a large
+// request blocks subsequent requests until it can be satisfied.
+type Semaphore struct {
+	w    *Waypoint
+	turn chan struct{} // held while acquiring
+	held []*Worker
+	mu   sync.Mutex
+}
+
+// NewSemaphore returns a new Semaphore backed by w.
+func NewSemaphore(w *Waypoint) *Semaphore {
+	return &Semaphore{w: w, turn: make(chan struct{}, 1)}
+}
+
+// Acquire acquires the semaphore with a weight of n, blocking until
+// resources are available or ctx is done. On success, it returns nil. On
+// failure, it returns the error from the underlying Waypoint's Wait method
+// (e.g. ctx.Err()) and leaves the semaphore unchanged.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	select {
+	case s.turn <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	defer func() { <-s.turn }()
+
+	return s.acquire(ctx, n)
+}
+
+// TryAcquire acquires the semaphore with a weight of n without blocking. On
+// success, it returns true. On failure, it returns false and leaves the
+// semaphore unchanged.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	select {
+	case s.turn <- struct{}{}:
+	default:
+		return false
+	}
+
+	defer func() { <-s.turn }()
+
+	// n.b. Waypoint's Wait method always provides an Active Worker when
+	//      capacity is immediately available -- even if its Context has
+	//      already been canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return s.acquire(ctx, n) == nil
+}
+
+// Release releases the semaphore with a weight of n. Like the Weighted type,
+// Release panics if more weight is released than is currently held.
+func (s *Semaphore) Release(n int64) {
+	s.mu.Lock()
+
+	if n > int64(len(s.held)) {
+		s.mu.Unlock()
+		panic("waypoint: semaphore released more than held")
+	}
+
+	idx := len(s.held) - int(n)
+	workers := append([]*Worker(nil), s.held[idx:]...)
+	clear(s.held[idx:])
+	s.held = s.held[:idx]
+
+	s.mu.Unlock()
+
+	for _, a := range workers {
+		a.Done()
+	}
+}
+
+// acquire obtains n Active Workers from the receiver's Waypoint; if any
+// one of them cannot be obtained, all are released and the error returned.
+func (s *Semaphore) acquire(ctx context.Context, n int64) error {
+	workers := make([]*Worker, 0, n)
+
+	for range n {
+		a, err := s.w.Wait(ctx)
+		if err != nil {
+			for _, a := range workers {
+				a.Done()
+			}
+			return err
+		}
+
+		workers = append(workers, a)
+	}
+
+	s.mu.Lock()
+	s.held = append(s.held, workers...)
+	s.mu.Unlock()
+
+	return nil
+}
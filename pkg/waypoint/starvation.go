@@ -0,0 +1,41 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "time"
+
+// WithStarvationThreshold returns an Option that causes the Waypoint to
+// report starvation metrics (see the LongestWait, Starved and TotalStarved
+// fields of Metrics). A Worker is considered starved if it has been Waiting
+// longer than d. These metrics provide an alertable signal that work is
+// piling up behind the Waypoint.
+//
+// Thresholds less than or equal to zero are ignored.
+func WithStarvationThreshold(d time.Duration) Option {
+	return func(w *Waypoint) {
+		if d > 0 {
+			w.starveAfter = d
+		}
+	}
+}
+
+// _starvation returns the longest current wait time among the receiver's
+// queued Workers along with the number that have waited longer than the
+// receiver's starvation threshold.
+func (w *Waypoint) _starvation(now time.Time) (longest time.Duration, starved int) {
+	if w.starveAfter == 0 {
+		return 0, 0
+	}
+
+	for e := w.queue.Front(); e != nil; e = e.Next() {
+		d := now.Sub(e.Value.(*waiter).worker.created)
+
+		longest = max(longest, d)
+
+		if d > w.starveAfter {
+			starved++
+		}
+	}
+
+	return longest, starved
+}
@@ -0,0 +1,96 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "context"
+
+// A Tracer creates Spans for tracking Worker lifecycles in a distributed
+// tracing system. This package intentionally avoids depending upon any
+// particular tracing library; instead, an OpenTelemetry Tracer may be
+// adapted with just a few lines of code. For example:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, w *waypoint.Worker) (context.Context, waypoint.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name, trace.WithAttributes(attribute.Int64("worker.id", int64(w.ID))))
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ trace.Span }
+//
+//	func (s otelSpan) End(err error) {
+//		if err != nil {
+//			s.Span.RecordError(err)
+//			s.Span.SetStatus(codes.Error, err.Error())
+//		}
+//		s.Span.End()
+//	}
+type Tracer interface {
+	// Start creates a new Span with the given name for Worker w as a child
+	// of any span contained in ctx.
+	Start(ctx context.Context, name string, w *Worker) (context.Context, Span)
+}
+
+// A Span represents a single traced operation created by a Tracer.
+type Span interface {
+	// End completes the Span. A non-nil err indicates that the traced
+	// operation failed.
+	End(err error)
+}
+
+const (
+	// WaitSpanName is the name given to Spans covering the time a Worker
+	// spends in the Waiting state.
+	WaitSpanName = "waypoint.wait"
+
+	// ActiveSpanName is the name given to Spans covering the time a Worker
+	// spends in the Active state.
+	ActiveSpanName = "waypoint.active"
+)
+
+// WithTracer returns an Option that causes the Waypoint to create two Spans
+// for each of its Workers using Tracer t. The first (named WaitSpanName)
+// begins when Wait is called and ends once the Worker becomes Active (or
+// Wait fails). The second (named ActiveSpanName) begins when the Worker
+// becomes Active and ends when its Done method is called. Both Spans are
+// children of the Context passed to Wait and the Context returned by the
+// Worker's Context method (and so, the one passed to a function given to
+// Go) carries the latter.
+func WithTracer(t Tracer) Option {
+	return func(w *Waypoint) {
+		w.tracer = t
+	}
+}
+
+// startSpan starts a new Span named name for Worker a if the receiver has
+// a Tracer, returning it along with the Context (derived from ctx) that
+// carries it. Otherwise, ctx and a nil Span are returned.
+func (w *Waypoint) startSpan(ctx context.Context, name string, a *Worker) (context.Context, Span) {
+	if w.tracer == nil {
+		return ctx, nil
+	}
+
+	return w.tracer.Start(ctx, name, a)
+}
+
+// startActiveSpan starts the receiver's ActiveSpanName Span (see WithTracer)
+// as a child of its Context, which is then replaced by the one carrying it.
+func (w *Worker) startActiveSpan() {
+	if w.tracer == nil {
+		return
+	}
+
+	ctx, span := w.startSpan(w.Context(), ActiveSpanName, w)
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.ctx, w.span = ctx, span
+}
+
+// endSpan ends span (if it is not nil) using err.
+func endSpan(span Span, err error) {
+	if span != nil {
+		span.End(err)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import (
+	"context"
+	"time"
+)
+
+// A Watchdog defines how a Waypoint detects stuck Workers; see WithWatchdog.
+type Watchdog struct {
+	// Limit is how long a Worker may remain Active before it is considered
+	// to be stuck.
+	Limit time.Duration
+
+	// Interval is how often Active Workers are inspected.
+	// Default: Limit / 4 (but at least 1ns)
+	Interval time.Duration
+
+	// OnStuck, if not nil, is called once for each Worker found to be stuck.
+	// It is called from the watchdog's own goroutine, without holding the
+	// Waypoint's lock.
+	OnStuck func(WorkerInfo)
+
+	// If Cancel is true, the Context returned by each stuck Worker's Context
+	// method is canceled with ErrStuck as its cause.
+	Cancel bool
+}
+
+// WithWatchdog returns an Option that causes the Waypoint to periodically
+// inspect its Active Workers (from a separate goroutine) and flag those that
+// have been Active longer than the provided Watchdog's Limit. Stuck Workers
+// are counted by the Stuck field of the Waypoint's Metrics and identified by
+// the Stuck field of each WorkerInfo returned by its Workers method. The
+// watchdog's goroutine runs only while the Waypoint has Waiting or Active
+// Workers, so neither calling Done nor any other cleanup is required.
+//
+// A Watchdog with a Limit less than or equal to zero is ignored.
+func WithWatchdog(wd Watchdog) Option {
+	return func(w *Waypoint) {
+		if wd.Limit <= 0 {
+			return
+		}
+
+		if wd.Interval <= 0 {
+			wd.Interval = max(wd.Limit/4, 1)
+		}
+
+		w.watchdog = &wd
+	}
+}
+
+// Context returns the Context provided to the Wait call that created the
+// receiver (or, if the receiver's Waypoint was created WithTracer, one
+// derived from it that carries the receiver's active Span). If the
+// receiver's Waypoint was created using a Watchdog whose Cancel field is
+// true, the returned Context is canceled (with ErrStuck as its cause) if
+// the receiver is found to be stuck.
+func (w *Worker) Context() context.Context {
+	w.RLock()
+	defer w.RUnlock()
+	return w.ctx
+}
+
+// runWatchdog flags the receiver's stuck Workers until idle is closed (see
+// _startMonitors) or the receiver's Done channel is closed.
+func (w *Waypoint) runWatchdog(idle <-chan struct{}) {
+	ticker := time.NewTicker(w.watchdog.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-idle:
+			return
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		if h := w.watchdog.OnStuck; h != nil {
+			for _, info := range w.checkStuck() {
+				h(info)
+			}
+		} else {
+			w.checkStuck()
+		}
+	}
+}
+
+// checkStuck flags any of the receiver's Active Workers that have become
+// stuck since the last check and returns a snapshot of each.
+func (w *Waypoint) checkStuck() []WorkerInfo {
+	w.Lock()
+	defer w.Unlock()
+
+	var stuck []WorkerInfo
+	for _, a := range w.active {
+		if a.stuck || w.clock.Since(a.started) < w.watchdog.Limit {
+			continue
+		}
+
+		a.stuck = true
+		w.numStuck++
+
+		if a.cancel != nil {
+			a.cancel(ErrStuck)
+		}
+
+		stuck = append(stuck, a._info())
+	}
+
+	return stuck
+}
@@ -94,33 +94,85 @@
 // enough Waiting Workers will immediately unblock in order to consume
 // the newly available capacity.
 //
-// Note that a Waypoint is not a queue; the order in which Workers become
-// unblocked is unrelated to the order they're created.
+// Waiting Workers become Active in the order they were created (i.e. first
+// in, first out) except that a Worker held back by a quota (see WithQuota)
+// does not prevent those behind it from becoming Active. The number of
+// Waiting Workers may be bounded using WithMaxWaiting.
 package waypoint
 
 import (
+	"container/list"
 	"context"
+	"runtime/debug"
 	"sync"
 	"time"
+
+	"github.com/go-sage/synctools/internal/options"
 )
 
 type (
 	// A Waypoint is a coordination point that ensure only a set number of
 	// Workers are allowed to do work concurrently.
 	Waypoint struct {
+		name        string
 		idSeq       uint64
 		capacity    int
 		numWaiting  int
 		numFinished int
 		active      map[uint64]*Worker
-		cond        *sync.Cond
+		queue       list.List // of *waiter
+		capWaiters  list.List // of *capWaiter
 
-		closed bool
-		done   chan struct{}
-		once   sync.Once
+		closed       bool
+		abandoned    bool
+		numAbandoned int
+		done         chan struct{}
+		once         sync.Once
+		final        *FinalState
 
 		waitTime   time.Duration
 		activeTime time.Duration
+		waitHist   *Histogram
+		activeHist *Histogram
+		window     *window
+
+		hooks  hooks
+		tracer Tracer
+
+		parent   *Waypoint
+		reserved int
+
+		limiter   *tokenBucket
+		rateTimer bool
+
+		quota     *quota
+		schedule  *Schedule
+		schedNext time.Time // when the Schedule's capacity next changes
+		burst     *burstAllowance
+
+		idle chan struct{} // see _startMonitors
+
+		events     chan Event
+		numDropped int
+
+		watchdog *Watchdog
+		numStuck int
+
+		lease      *lease
+		numExpired int
+
+		starveAfter time.Duration
+		numStarved  int
+
+		maxWaiting int
+
+		clock Clock
+
+		lastDelta *Metrics // see MetricsDelta
+
+		pprofLabels bool
+
+		goErr func(error) // see OnGoError
 
 		rwMutex
 	}
@@ -130,71 +182,314 @@ type (
 	rwMutex = sync.RWMutex
 )
 
-// New returns a new Waypoint initialized to the provided capacity.
-func New(capacity int) *Waypoint {
+// New returns a new Waypoint initialized to the provided capacity. Any
+// provided Options are applied, in order, before New returns.
+func New(capacity int, opts ...Option) *Waypoint {
 	w := &Waypoint{
 		capacity: capacity,
 		active:   make(map[uint64]*Worker),
 		done:     make(chan struct{}),
+		window:   newWindow(defaultRetention, defaultResolution),
+		clock:    RealClock{},
 	}
 
-	w.cond = sync.NewCond(w)
+	w = options.Apply(w, opts)
+
+	if w.schedule != nil {
+		w.capacity, w.schedNext = w.schedule.capacityAt(w.clock.Now())
+	}
 
 	return w
 }
 
+// _startMonitors starts the goroutines used by the receiver's Schedule,
+// Watchdog and lease (if any) unless they are already running. They run
+// only while the receiver has Waiting or Active Workers so that an idle
+// Waypoint holds no goroutines; see _stopMonitors.
+func (w *Waypoint) _startMonitors() {
+	if w.idle != nil || (w.schedule == nil && w.watchdog == nil && w.lease == nil) {
+		return
+	}
+
+	w.idle = make(chan struct{})
+
+	if w.schedule != nil {
+		w._applySchedule()
+		go w.runSchedule(w.idle, w.schedNext)
+	}
+
+	if w.watchdog != nil {
+		go w.runWatchdog(w.idle)
+	}
+
+	if w.lease != nil {
+		go w.runLeases(w.idle)
+	}
+}
+
+// _stopMonitors stops the goroutines started by _startMonitors once the
+// receiver has no Waiting or Active Workers.
+func (w *Waypoint) _stopMonitors() {
+	if w.idle == nil || w.numWaiting > 0 || len(w.active) > 0 {
+		return
+	}
+
+	close(w.idle)
+	w.idle = nil
+}
+
 // Wait returns an Active *Worker ready to do some work.  If the receiver
 // has available capacity, Wait returns immediately, otherwise it blocks
 // until capacity is made available. If the provided context is canceled
 // or times out while waiting, a nil *Worker is returned along with the
 // error value returned by ctx.Err().
+//
+// If the receiver has already been closed (see Done), Wait returns a nil
+// *Worker and ErrClosed immediately. The same is true if the receiver is
+// closed while Wait is blocked and its Waiting Workers are abandoned (see
+// Done and CloseContext). If the receiver was created using WithMaxWaiting
+// and too many Workers are already Waiting, a nil *Worker and
+// ErrTooManyWaiting are returned immediately.
 func (w *Waypoint) Wait(ctx context.Context) (*Worker, error) {
-	done := make(chan struct{})
-	defer close(done)
-
-	// n.b. Since sync.Cond.Wait does not accept a Context, we'll need
-	// this extra goroutine to watch for context cancelation. If/when
-	// the provided context is canceled, we'll send a broadcast to the
-	// receiver's 'cond' thus waking up all goroutines blocked on this
-	// method. If this method returns before the context gets canceled,
-	// then the closing of the local 'done' channel will allow this
-	// func to return as well.
+	if w == nil {
+		return nil, ErrNilWaypoint
+	}
+
+	w.Lock()
+	if w.closed {
+		w.Unlock()
+		return nil, ErrClosed
+	}
+
+	a := w._next()
+	a.labels = LabelsFrom(ctx)
+	a.ctx = ctx
+	w.numWaiting++
+	w._startMonitors()
+	w._emit(WorkerCreated, a.ID)
+	w.Unlock()
+
+	w.hooks.waitStart.call(a)
+
+	wctx, span := w.startSpan(ctx, WaitSpanName, a)
+
+	if err := w.wait(wctx, a); err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	endSpan(span, nil)
+	a.setProfilerLabels()
+	a.startActiveSpan()
+
+	w.hooks.activate.call(a)
+
+	return a, nil
+}
+
+// Go waits for the receiver to provide an Active Worker (as with Wait) and
+// then calls fn in a new goroutine using the Worker's Do method, passing it
+// the Worker's Context (see Worker.Context). The Worker's
+// Done method is called when fn returns -- even if it panics, in which case
+// the panic is recovered and converted to a *PanicError.
+//
+// The returned error is non-nil only if Wait fails, in which case fn is not
+// called. An error returned by fn (or a *PanicError) is passed to the
+// receiver's OnGoError function or, if it has none, discarded.
+func (w *Waypoint) Go(ctx context.Context, fn func(context.Context) error) error {
+	a, err := w.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
 	go func() {
-		select {
-		case <-ctx.Done():
-			w.cond.Broadcast()
-		case <-done:
-			return
+		defer a.Done()
+
+		var err error
+		a.Do(a.Context(), func(ctx context.Context) { err = safeCall(ctx, fn) })
+
+		if err != nil && w.goErr != nil {
+			w.goErr(err)
+		}
+	}()
+
+	return nil
+}
+
+// OnGoError returns an Option that causes fn to be called with each non-nil
+// error returned by a function passed to the Waypoint's Go method (or with
+// a *PanicError if it panics). It is called from that function's goroutine
+// before its Worker is Done.
+func OnGoError(fn func(err error)) Option {
+	return func(w *Waypoint) {
+		w.goErr = fn
+	}
+}
+
+// safeCall calls fn, converting a panic into a *PanicError.
+func safeCall(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{v, debug.Stack()}
 		}
 	}()
 
+	return fn(ctx)
+}
+
+// wait blocks until the receiver has capacity for Worker a and then moves
+// it into the Active state. If ctx is canceled before that happens, its
+// error is returned and a remains in the Waiting state.
+//
+// Each blocked call to wait is represented by a waiter in the receiver's
+// queue. Capacity is granted to waiters (in the order they were queued)
+// by _dispatch, which wakes each of them individually as capacity becomes
+// available. A canceled Context wakes only its own waiter.
+func (w *Waypoint) wait(ctx context.Context, a *Worker) error {
 	w.Lock()
 	defer w.Unlock()
 
-	w.numWaiting++
-	defer func() { w.numWaiting-- }()
+	defer func() {
+		w.numWaiting--
+		w._stopMonitors()
+		w._stop()
+	}()
 
-	a := w._next()
+	if w.abandoned {
+		w.numAbandoned++
+		return ErrClosed
+	}
+
+	// n.b. If others are already queued, we must wait our turn even if
+	//      capacity is currently available.
+	if w.queue.Len() == 0 && w._quotaOK(a) && w._tryReserve() {
+		w._quotaTake(a)
+	} else {
+		if w.maxWaiting > 0 && w.queue.Len() >= w.maxWaiting {
+			return ErrTooManyWaiting
+		}
+
+		wt := &waiter{worker: a, ready: make(chan struct{})}
+		elem := w.queue.PushBack(wt)
+
+		// Those ahead of us may only be held back by their quota.
+		w._dispatch()
+
+		if !wt.granted {
+			w.Unlock()
+			select {
+			case <-wt.ready:
+			case <-ctx.Done():
+			}
+			w.Lock()
+		}
+
+		switch {
+		case wt.abandoned:
+			w.numAbandoned++
+			return ErrClosed
 
-	for len(w.active) >= w.capacity {
-		w.cond.Wait()
-
-		// Before we turn around and recheck the above condition (since
-		// that's what the docs for cond.Wait() tell us to do), we'll need
-		// to check whether we were awoken by the broadcast in the above
-		// anonymous goroutine.
-		//
-		// If so, we'll return ctx.Err() -- otherwise, we can check our
-		// condition and act accordingly.
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
-			continue
+		case !wt.granted:
+			w.queue.Remove(elem)
+			return ctx.Err()
 		}
+
+		// n.b. If we were granted capacity, that takes precedence over
+		//      a concurrently canceled Context.
 	}
 
-	return a._start(), nil
+	// At this point, we hold a reserved slot of capacity on behalf of a.
+
+	if w.parent != nil {
+		// Our reserved slot of local capacity keeps the Worker Waiting
+		// while we wait for the parent Waypoint without holding our own
+		// lock.
+		w.Unlock()
+		pa, err := w.parent.Wait(ctx)
+		w.Lock()
+
+		if err != nil {
+			// Our reserved slot is available to someone else.
+			w.reserved--
+			w._quotaRelease(a)
+			w._dispatch()
+			return err
+		}
+
+		a.parent = pa
+	}
+
+	w.reserved--
+	a._start()
+
+	return nil
+}
+
+// A waiter represents a blocked call to wait.
+type waiter struct {
+	worker    *Worker       // the Waiting Worker
+	ready     chan struct{} // closed once granted or abandoned
+	granted   bool          // capacity has been reserved for this waiter
+	abandoned bool          // this waiter will never become Active
+}
+
+// _tryReserve reserves a slot of the receiver's capacity and returns true
+// if one is available. Otherwise, it returns false.
+func (w *Waypoint) _tryReserve() bool {
+	if w._inUse() >= w._limit() || !w._admit() {
+		return false
+	}
+
+	w.reserved++
+
+	return true
+}
+
+// _dispatch grants capacity to as many queued waiters as possible. Waiters
+// held back by the receiver's quota (see WithQuota) are passed over in favor
+// of those queued behind them. Any capacity remaining afterward is offered
+// to callers of AwaitCapacity.
+func (w *Waypoint) _dispatch() {
+	for e := w.queue.Front(); e != nil && w._inUse() < w._limit(); {
+		wt := e.Value.(*waiter)
+		next := e.Next()
+
+		if w._quotaOK(wt.worker) {
+			if !w._tryReserve() {
+				break
+			}
+
+			w.queue.Remove(e)
+			w._quotaTake(wt.worker)
+			wt.granted = true
+			close(wt.ready)
+		}
+
+		e = next
+	}
+
+	w._notifyCapacity()
+}
+
+// _inUse returns the amount of the receiver's capacity currently in use.
+func (w *Waypoint) _inUse() int {
+	return len(w.active) + w.reserved
+}
+
+// Child returns a new Waypoint with the given capacity whose Workers also
+// consume capacity from the receiver. This allows for a global concurrency
+// ceiling (enforced by the parent) across several subsystems each limited
+// independently (by their own child Waypoint). Any provided Options are
+// applied to the child.
+//
+// A Worker from a child Waypoint first waits for capacity from the child
+// and then, while still Waiting, from its parent; the Worker only becomes
+// Active once it has acquired both. When the Worker is Done, capacity is
+// returned to both Waypoints.
+func (w *Waypoint) Child(capacity int, opts ...Option) *Waypoint {
+	c := New(capacity, opts...)
+	c.parent = w
+	return c
 }
 
 // Resize sets the receiver's capacity to newcap returning the previous
@@ -214,18 +509,105 @@ func (w *Waypoint) Wait(ctx context.Context) (*Worker, error) {
 // such time that capacity is increased. Also, since this method cannot
 // be called on a closed Waypoint, setting capacity to zero then closing
 // the Waypoint will abandon all Waiting Workers.
-func (w *Waypoint) Resize(newcap int) int {
-	if w == nil || newcap < 0 || w.closed {
-		return -1
+//
+// Resize returns the receiver's previous capacity and a nil error on
+// success. Otherwise, it returns zero along with ErrNilWaypoint if the
+// receiver is nil, ErrInvalidCapacity if newcap is negative, or ErrClosed
+// if the receiver has been closed.
+func (w *Waypoint) Resize(newcap int) (int, error) {
+	switch {
+	case w == nil:
+		return 0, ErrNilWaypoint
+	case newcap < 0:
+		return 0, ErrInvalidCapacity
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	return w._resize(newcap), nil
+}
+
+// Grow atomically increases the receiver's capacity by n and returns the new
+// capacity. Unlike calling Resize with a value derived from the receiver's
+// current Metrics, concurrent calls to Grow (or Shrink) never clobber one
+// another. The errors returned are the same as those from Resize (where a
+// negative n is considered an invalid capacity).
+func (w *Waypoint) Grow(n int) (int, error) {
+	if n < 0 {
+		return 0, ErrInvalidCapacity
+	}
+
+	return w.adjust(n)
+}
+
+// Shrink atomically decreases the receiver's capacity by n (but never below
+// zero) and returns the new capacity. See Grow for details.
+func (w *Waypoint) Shrink(n int) (int, error) {
+	if n < 0 {
+		return 0, ErrInvalidCapacity
+	}
+
+	return w.adjust(-n)
+}
+
+// adjust provides common logic for Grow and Shrink.
+func (w *Waypoint) adjust(delta int) (int, error) {
+	if w == nil {
+		return 0, ErrNilWaypoint
 	}
 
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	newcap := max(w.capacity+delta, 0)
+	w._resize(newcap)
+
+	return newcap, nil
+}
+
+// compareAndResize sets the receiver's capacity to newcap, as with Resize,
+// but only if its current capacity is oldcap; otherwise, it returns false.
+// It is used by AutoScale so that it never overrides a concurrent Resize.
+func (w *Waypoint) compareAndResize(oldcap, newcap int) (bool, error) {
+	if newcap < 0 {
+		return false, ErrInvalidCapacity
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	switch {
+	case w.closed:
+		return false, ErrClosed
+	case w.capacity != oldcap:
+		return false, nil
+	}
+
+	w._resize(newcap)
+
+	return true, nil
+}
+
+// _resize sets the receiver's capacity to newcap and returns its previous
+// value.
+func (w *Waypoint) _resize(newcap int) int {
 	oldcap := w.capacity
 	w.capacity = newcap
+	w._emit(Resized, 0)
 
 	if newcap > oldcap {
 		// We have more capacity!!
-		// Let's tell everyone!
-		w.cond.Broadcast()
+		// Let's tell the next in line!
+		w._dispatch()
 	}
 
 	return oldcap
@@ -236,34 +618,127 @@ func (w *Waypoint) Resize(newcap int) int {
 // to continue and currently Waiting Workers will become Active when/if
 // capacity becomes available.  However, since capacity cannot be altered
 // on a closed Waypoint, if Done is called with zero capacity, all Waiting
-// Workers will be abandoned and will never become Active.
+// Workers will be abandoned and will never become Active; their calls to
+// Wait return ErrClosed.
 //
 // The returned channel will be closed once all actionable Workers have
-// reached the Finished state.
+// reached the Finished state. Afterward, the FinalState method may be used
+// to discover how the receiver came to be finished.
 func (w *Waypoint) Done() <-chan struct{} {
 	w.Lock()
 	defer w.Unlock()
 
-	w.closed = true
+	if !w.closed {
+		w.closed = true
+		w._emit(Closed, 0)
+	}
+
 	w._stop()
 
 	return w.done
 }
 
-func (w *Waypoint) _removeWorker(id uint64) {
-	if _, ok := w.active[id]; ok {
-		delete(w.active, id)
+// Closed returns true if the receiver has been closed by a call to Done (or
+// CloseContext). Note that a closed Waypoint may still have Active (or
+// Waiting) Workers; use the channel returned by Done to learn when they
+// have all Finished.
+func (w *Waypoint) Closed() bool {
+	if w == nil {
+		return false
 	}
 
-	if len(w.active) == 0 {
+	w.RLock()
+	defer w.RUnlock()
+
+	return w.closed
+}
+
+// CloseContext is similar to Done except that it blocks until either all
+// actionable Workers have reached the Finished state or ctx is canceled.
+// In the former case, the error returned is that from the receiver's
+// FinalState (i.e. ErrAbandoned if any Waiting Workers were abandoned,
+// otherwise nil).
+//
+// If ctx is canceled first, all Waiting Workers are abandoned immediately
+// (their calls to Wait return ErrClosed) and ctx.Err() is returned.
+// Currently Active Workers are unaffected and the channel returned by Done
+// will be closed once they have all Finished.
+func (w *Waypoint) CloseContext(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+
+	done := w.Done()
+
+	select {
+	case <-done:
+		fs, _ := w.FinalState()
+		return fs.Err()
+
+	case <-ctx.Done():
+		w.Lock()
+		w._abandon()
 		w._stop()
+		w.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// _abandon causes all of the receiver's Waiting Workers to be abandoned.
+func (w *Waypoint) _abandon() {
+	w.abandoned = true
+
+	for e := w.queue.Front(); e != nil; e = e.Next() {
+		wt := e.Value.(*waiter)
+		wt.abandoned = true
+		close(wt.ready)
 	}
+
+	w.queue.Init()
+}
+
+// _removeWorker removes the Worker with the given id from the receiver's
+// set of Active Workers which frees up capacity for others.
+//
+// Note that _dispatch must be called *before* _stop to allow a closed
+// Waypoint, with non-zero capacity, to continue activating Waiting Workers
+// -- otherwise, removing the only Active Worker from a closed Waypoint would
+// shut this whole thing down.
+func (w *Waypoint) _removeWorker(id uint64) {
+	delete(w.active, id)
+	w._dispatch()
+	w._stopMonitors()
+	w._stop()
 }
 
+// _stop closes the receiver's done channel if it has been closed and no
+// actionable Workers remain; i.e. there are no Active Workers and there
+// are either no Waiting Workers or no capacity for them to become Active.
+// In the latter case, all remaining Waiting Workers are abandoned.
 func (w *Waypoint) _stop() {
-	if w.closed {
-		w.once.Do(func() {
-			close(w.done)
-		})
+	if !w.closed || w._inUse() > 0 {
+		return
+	}
+
+	if w.numWaiting > 0 && w.capacity > 0 && !w.abandoned {
+		return
+	}
+
+	w.once.Do(func() {
+		w.final = &FinalState{
+			Abandoned: w.numAbandoned + w.numWaiting,
+			Metrics:   w._metrics(),
+		}
+
+		close(w.done)
+
+		if w.events != nil {
+			close(w.events)
+		}
+	})
+
+	if w.numWaiting > 0 && !w.abandoned {
+		w._abandon()
 	}
 }
@@ -4,7 +4,15 @@ package waypoint
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -105,3 +113,1329 @@ func TestFoo(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestHooks(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+
+	record := func(name string) Hook {
+		return func(w *Worker) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, fmt.Sprintf("%s:%d:%s", name, w.ID, w.State))
+		}
+	}
+
+	wp := New(1, OnWaitStart(record("wait")), OnActivate(record("active")), OnFinish(record("finish")))
+
+	a, err := wp.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Done()
+
+	want := []string{"wait:1:Waiting", "active:1:Active", "finish:1:Finished"}
+	if got := strings.Join(events, " "); got != strings.Join(want, " ") {
+		t.Errorf("hook events: got %q; wanted %q", got, want)
+	}
+}
+
+func TestFinalState(t *testing.T) {
+	wp := New(2)
+
+	a, err := wp.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := wp.FinalState(); ok {
+		t.Fatal("FinalState available before Done")
+	}
+
+	done := wp.Done()
+	a.Done()
+	<-done
+
+	fs, ok := wp.FinalState()
+	if !ok {
+		t.Fatal("FinalState unavailable after Done")
+	}
+
+	if err := fs.Err(); err != nil || fs.Metrics.Finished != 1 {
+		t.Errorf("FinalState: got (%v, %d finished); wanted (nil, 1 finished)", err, fs.Metrics.Finished)
+	}
+
+	// A zero capacity Waypoint closed with a Waiting Worker abandons it.
+	wp = New(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go wp.Wait(ctx)
+
+	for wp.Metrics().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	<-wp.Done()
+
+	if fs, _ := wp.FinalState(); fs.Err() != ErrAbandoned || fs.Abandoned != 1 {
+		t.Errorf("FinalState: got (%v, %d abandoned); wanted (%v, 1 abandoned)", fs.Err(), fs.Abandoned, ErrAbandoned)
+	}
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+type testSpan struct {
+	tt   *testTracer
+	name string
+}
+
+func (tt *testTracer) Start(ctx context.Context, name string, w *Worker) (context.Context, Span) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.spans = append(tt.spans, fmt.Sprintf("start:%s:%d", name, w.ID))
+	return context.WithValue(ctx, testSpanKey{}, name), &testSpan{tt, name}
+}
+
+type testSpanKey struct{}
+
+func (ts *testSpan) End(err error) {
+	ts.tt.mu.Lock()
+	defer ts.tt.mu.Unlock()
+	ts.tt.spans = append(ts.tt.spans, fmt.Sprintf("end:%s:%v", ts.name, err))
+}
+
+func TestTracer(t *testing.T) {
+	tt := &testTracer{}
+	wp := New(1, WithTracer(tt))
+
+	a, err := wp.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := a.Context().Value(testSpanKey{}); got != ActiveSpanName {
+		t.Errorf("Context span: got %v; wanted %q", got, ActiveSpanName)
+	}
+
+	a.Done()
+
+	want := "start:waypoint.wait:1 end:waypoint.wait:<nil> start:waypoint.active:1 end:waypoint.active:<nil>"
+	if got := strings.Join(tt.spans, " "); got != want {
+		t.Errorf("spans: got %q; wanted %q", got, want)
+	}
+
+	spanc := make(chan any, 1)
+	wp.Go(context.Background(), func(ctx context.Context) error {
+		spanc <- ctx.Value(testSpanKey{})
+		return nil
+	})
+
+	if got := <-spanc; got != ActiveSpanName {
+		t.Errorf("Go span: got %v; wanted %q", got, ActiveSpanName)
+	}
+}
+
+func TestAutoScale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wp := New(1)
+
+	// n.b. Since every Active Worker is held until release is closed, no
+	//      Worker starts during most intervals while others are Waiting,
+	//      so capacity grows regardless of how long each interval takes.
+	go AutoScale(ctx, wp, ScalePolicy{
+		TargetWait: time.Hour,
+		Min:        1,
+		Max:        4,
+		Interval:   time.Millisecond,
+	})
+
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a, err := wp.Wait(ctx)
+			if err != nil {
+				return
+			}
+			<-release
+			a.Done()
+		}()
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); wp.Metrics().Active < 4; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("capacity after scaling: got %d; wanted 4", wp.Metrics().Capacity)
+		}
+	}
+
+	if got := wp.Metrics().Capacity; got != 4 {
+		t.Errorf("capacity after scaling: got %d; wanted 4", got)
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+func TestCompareAndResize(t *testing.T) {
+	wp := New(2)
+
+	// n.b. This is what AutoScale sees if wp is resized after it has
+	//      taken its Metrics but before it resizes wp itself.
+	wp.Resize(3)
+
+	if ok, err := wp.compareAndResize(2, 4); ok || err != nil {
+		t.Errorf("compareAndResize(2, 4): got (%v, %v); wanted (false, nil)", ok, err)
+	}
+
+	if got := wp.Metrics().Capacity; got != 3 {
+		t.Errorf("capacity: got %d; wanted 3", got)
+	}
+
+	if ok, err := wp.compareAndResize(3, 4); !ok || err != nil {
+		t.Errorf("compareAndResize(3, 4): got (%v, %v); wanted (true, nil)", ok, err)
+	}
+
+	<-wp.Done()
+
+	if _, err := wp.compareAndResize(4, 5); err != ErrClosed {
+		t.Errorf("closed compareAndResize: got %v; wanted %v", err, ErrClosed)
+	}
+}
+
+func TestHistograms(t *testing.T) {
+	wp := New(1, WithHistograms(time.Millisecond, time.Hour))
+
+	for i := 0; i < 4; i++ {
+		a, err := wp.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 3 {
+			time.Sleep(2 * time.Millisecond)
+		}
+		a.Done()
+	}
+
+	h := wp.Metrics().ActiveHistogram
+	if h == nil || h.Count != 4 || h.Counts[0] != 3 || h.Counts[1] != 1 {
+		t.Fatalf("active histogram: got %+v", h)
+	}
+
+	if got, want := h.Quantile(0.5), time.Millisecond; got != want {
+		t.Errorf("p50: got %v; wanted %v", got, want)
+	}
+
+	if got, want := h.Quantile(0.99), time.Hour; got != want {
+		t.Errorf("p99: got %v; wanted %v", got, want)
+	}
+}
+
+func TestMetricsWindow(t *testing.T) {
+	wp := New(2, WithMetricsWindow(time.Hour, time.Hour))
+
+	for i := 0; i < 3; i++ {
+		a, err := wp.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Done()
+	}
+
+	wm := wp.MetricsWindow(time.Minute)
+	if wm.Window != time.Hour || wm.Started != 3 || wm.Finished != 3 {
+		t.Errorf("MetricsWindow: got %+v; wanted 3 started and finished over 1h", wm)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	wp := New(3)
+	ctx := WithLabels(context.Background(), Labels{"tenant": "acme"})
+
+	a1, _ := wp.Wait(ctx)
+	a2, _ := wp.Wait(context.Background())
+	defer a2.Done()
+
+	a1.Done()
+
+	infos := wp.Workers()
+	if len(infos) != 1 || infos[0].ID != a2.ID || infos[0].State != Active {
+		t.Fatalf("Workers: got %+v; wanted only worker %d", infos, a2.ID)
+	}
+
+	if got := a1.Labels()["tenant"]; got != "acme" {
+		t.Errorf("Labels: got %q; wanted %q", got, "acme")
+	}
+
+	finished := a1.Finished()
+	if finished.IsZero() {
+		t.Errorf("Finished: got zero time for a finished Worker")
+	}
+
+	// n.b. A second call to Done must not finish a2 (or anything else).
+	a1.Done()
+
+	if got := a1.Finished(); !got.Equal(finished) {
+		t.Errorf("Finished: got %v after second Done; wanted %v", got, finished)
+	}
+
+	if infos := wp.Workers(); len(infos) != 1 || infos[0].ID != a2.ID {
+		t.Errorf("Workers: got %+v after second Done; wanted only worker %d", infos, a2.ID)
+	}
+}
+
+func TestChild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	parent := New(3)
+	c1 := parent.Child(2)
+	c2 := parent.Child(2)
+
+	var (
+		mu      sync.Mutex
+		cur     int
+		highest int
+		wg      sync.WaitGroup
+	)
+
+	for i := 0; i < 20; i++ {
+		c := c1
+		if i%2 == 1 {
+			c = c2
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			a, err := c.Wait(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer a.Done()
+
+			mu.Lock()
+			if cur++; cur > highest {
+				highest = cur
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			cur--
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if highest > 3 {
+		t.Errorf("concurrency exceeded parent capacity: got %d; wanted <= 3", highest)
+	}
+
+	if m := parent.Metrics(); m.Finished != 20 || m.Active != 0 {
+		t.Errorf("parent metrics: got %+v", m)
+	}
+}
+
+func TestPool(t *testing.T) {
+	pool := NewPool(2)
+	db1 := pool.Waypoint("db1", 2)
+	db2 := pool.Waypoint("db2", 2)
+
+	if pool.Waypoint("db1", 5) != db1 || db1.Name() != "db1" {
+		t.Fatal("Waypoint did not return existing Waypoint")
+	}
+
+	a1, _ := db1.Wait(context.Background())
+	a2, _ := db2.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := db1.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait on exhausted pool: got %v; wanted %v", err, context.DeadlineExceeded)
+	}
+
+	a1.Done()
+	a2.Done()
+
+	if m := pool.Metrics(); m.Finished != 2 || m.Active != 0 {
+		t.Errorf("pool metrics: got %+v", m)
+	}
+
+	if got := strings.Join(pool.Names(), ","); got != "db1,db2" {
+		t.Errorf("Names: got %q; wanted %q", got, "db1,db2")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	wp := New(10, WithRateLimit(100, 1))
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		a, err := wp.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Done()
+	}
+
+	// 5 activations at 100/s with a burst of 1 takes at least 40ms
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 rate limited activations took %v; wanted >= 40ms", elapsed)
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	wp := New(1)
+
+	a, _ := wp.Wait(context.Background())
+
+	errc := make(chan error)
+	go func() {
+		_, err := wp.Wait(context.Background())
+		errc <- err
+	}()
+
+	for wp.Metrics().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := wp.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("CloseContext: got %v; wanted %v", err, context.DeadlineExceeded)
+	}
+
+	if err := <-errc; err != ErrClosed {
+		t.Errorf("abandoned Wait: got %v; wanted %v", err, ErrClosed)
+	}
+
+	a.Done()
+	<-wp.Done()
+
+	if fs, _ := wp.FinalState(); fs.Abandoned != 1 {
+		t.Errorf("FinalState: got %d abandoned; wanted 1", fs.Abandoned)
+	}
+}
+
+func TestWaitCancel(t *testing.T) {
+	wp := New(1)
+
+	a, _ := wp.Wait(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error, 2)
+	for i, c := range []context.Context{ctx, context.Background()} {
+		go func() {
+			w, err := wp.Wait(c)
+			if err == nil {
+				w.Done()
+			}
+			errc <- err
+		}()
+
+		for wp.Metrics().Waiting <= i {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Canceling the first waiter must not disturb the second.
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("canceled Wait: got %v; wanted %v", err, context.Canceled)
+	}
+
+	if got := wp.Metrics().Waiting; got != 1 {
+		t.Errorf("Waiting: got %d; wanted 1", got)
+	}
+
+	a.Done()
+
+	if err := <-errc; err != nil {
+		t.Errorf("second Wait: got %v; wanted nil", err)
+	}
+}
+
+func TestGo(t *testing.T) {
+	wp := New(2)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var active, peak, count atomic.Int32
+
+	for range 10 {
+		wg.Add(1)
+		err := wp.Go(ctx, func(context.Context) error {
+			defer wg.Done()
+			n := active.Add(1)
+			defer active.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(time.Millisecond)
+			count.Add(1)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Go: %v", err)
+		}
+	}
+
+	wg.Wait()
+
+	if got := count.Load(); got != 10 {
+		t.Errorf("Go: %d funcs called; wanted 10", got)
+	}
+
+	if got := peak.Load(); got > 2 {
+		t.Errorf("Go: %d concurrent funcs; wanted <= 2", got)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		errFail := errors.New("fail")
+		errs := make(chan error, 2)
+
+		wp := New(1, OnGoError(func(err error) { errs <- err }))
+
+		wp.Go(ctx, func(context.Context) error { return errFail })
+		wp.Go(ctx, func(context.Context) error { panic("boom") })
+
+		if err := <-errs; err != errFail {
+			t.Errorf("OnGoError: got %v; wanted %v", err, errFail)
+		}
+
+		var pe *PanicError
+		if err := <-errs; !errors.As(err, &pe) || pe.Value != "boom" {
+			t.Errorf("OnGoError: got %v; wanted a *PanicError for %q", err, "boom")
+		}
+
+		// n.b. The panicking func's Worker must still be released.
+		if a, err := wp.Wait(ctx); err != nil {
+			t.Errorf("Wait after panic: %v", err)
+		} else {
+			a.Done()
+		}
+	})
+}
+
+func TestQuota(t *testing.T) {
+	wp := New(4, WithQuota("tenant", 2))
+
+	acme := WithLabels(context.Background(), Labels{"tenant": "acme"})
+	other := WithLabels(context.Background(), Labels{"tenant": "other"})
+
+	a1, _ := wp.Wait(acme)
+	a2, _ := wp.Wait(acme)
+
+	// A third "acme" Worker must wait...
+	errc := make(chan error)
+	go func() {
+		a, err := wp.Wait(acme)
+		if err == nil {
+			a.Done()
+		}
+		errc <- err
+	}()
+
+	for wp.Metrics().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// ...but must not block another tenant.
+	ctx, cancel := context.WithTimeout(other, time.Second)
+	defer cancel()
+
+	o1, err := wp.Wait(ctx)
+	if err != nil {
+		t.Fatalf("Wait(other): %v", err)
+	}
+
+	if got := wp.QuotaUsage(); got["acme"] != 2 || got["other"] != 1 {
+		t.Errorf("QuotaUsage: got %v; wanted acme:2 other:1", got)
+	}
+
+	if err := wp.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+
+	a1.Done()
+
+	if err := <-errc; err != nil {
+		t.Errorf("Wait(acme): %v", err)
+	}
+
+	a2.Done()
+	o1.Done()
+
+	if got := wp.QuotaUsage(); len(got) != 0 {
+		t.Errorf("QuotaUsage: got %v; wanted none", got)
+	}
+
+	var nilwp *Waypoint
+	if got := nilwp.QuotaUsage(); got != nil {
+		t.Errorf("nil QuotaUsage: got %v; wanted nil", got)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s := Schedule{
+		Entries: []ScheduleEntry{
+			{At: 17 * time.Hour, Capacity: 200},
+			{At: 9 * time.Hour, Capacity: 50},
+		},
+		Location: time.UTC,
+	}
+
+	wp := New(1, WithSchedule(s))
+	defer wp.Done()
+
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		offset time.Duration
+		want   int
+		next   time.Time
+	}{
+		{2 * time.Hour, 200, day.Add(9 * time.Hour)},
+		{9 * time.Hour, 50, day.Add(17 * time.Hour)},
+		{12 * time.Hour, 50, day.Add(17 * time.Hour)},
+		{20 * time.Hour, 200, day.Add(33 * time.Hour)},
+	} {
+		got, next := wp.schedule.capacityAt(day.Add(tc.offset))
+		if got != tc.want || !next.Equal(tc.next) {
+			t.Errorf("capacityAt(+%v): got (%d, %v); wanted (%d, %v)", tc.offset, got, next, tc.want, tc.next)
+		}
+	}
+
+	want, _ := wp.schedule.capacityAt(time.Now())
+	if got := wp.Metrics().Capacity; got != want {
+		t.Errorf("Capacity: got %d; wanted %d", got, want)
+	}
+}
+
+func TestBurst(t *testing.T) {
+	wp := New(2, WithBurst(2, 20*time.Millisecond))
+	ctx := context.Background()
+
+	var workers []*Worker
+	for range 4 {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		a, err := wp.Wait(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("Wait within burst: %v", err)
+		}
+		workers = append(workers, a)
+	}
+
+	errc := make(chan error)
+	go func() {
+		a, err := wp.Wait(ctx)
+		if err == nil {
+			a.Done()
+		}
+		errc <- err
+	}()
+
+	// Once the burst allowance has decayed, finishing a single Worker
+	// must not activate another.
+	time.Sleep(30 * time.Millisecond)
+	workers[0].Done()
+	time.Sleep(10 * time.Millisecond)
+
+	if got := wp.Metrics().Waiting; got != 1 {
+		t.Errorf("Waiting after decay: got %d; wanted 1", got)
+	}
+
+	// ...until we're back to capacity.
+	workers[1].Done()
+
+	if err := <-errc; err != nil {
+		t.Errorf("Wait after burst: %v", err)
+	}
+
+	workers[2].Done()
+	workers[3].Done()
+}
+
+func TestEvents(t *testing.T) {
+	wp := New(1)
+	events := wp.Events()
+
+	a, _ := wp.Wait(context.Background())
+	id := a.ID
+	wp.Resize(2)
+	a.Done()
+	<-wp.Done()
+
+	var got []EventType
+	for ev := range events {
+		if ev.Time.IsZero() || ev.Dropped != 0 {
+			t.Errorf("unexpected Event: %+v", ev)
+		}
+		if ev.WorkerID != 0 && ev.WorkerID != id {
+			t.Errorf("Event %s: got WorkerID %d", ev.Type, ev.WorkerID)
+		}
+		got = append(got, ev.Type)
+	}
+
+	want := []EventType{WorkerCreated, WorkerActivated, Resized, WorkerFinished, Closed}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Events: got %v; wanted %v", got, want)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	stuck := make(chan WorkerInfo, 1)
+
+	wp := New(2, WithWatchdog(Watchdog{
+		Limit:   10 * time.Millisecond,
+		OnStuck: func(info WorkerInfo) { stuck <- info },
+		Cancel:  true,
+	}))
+	defer wp.Done()
+
+	a, _ := wp.Wait(context.Background())
+	ctx := a.Context()
+
+	var info WorkerInfo
+	select {
+	case info = <-stuck:
+	case <-time.After(time.Second):
+		t.Fatal("stuck Worker not reported")
+	}
+
+	if info.ID != a.ID || !info.Stuck {
+		t.Errorf("OnStuck: got %+v; wanted stuck worker %d", info, a.ID)
+	}
+
+	if got := wp.Metrics().Stuck; got != 1 {
+		t.Errorf("Metrics.Stuck: got %d; wanted 1", got)
+	}
+
+	<-ctx.Done()
+	if cause := context.Cause(ctx); cause != ErrStuck {
+		t.Errorf("Context cause: got %v; wanted %v", cause, ErrStuck)
+	}
+
+	a.Done()
+
+	if got := wp.Metrics().Stuck; got != 0 {
+		t.Errorf("Metrics.Stuck after Done: got %d; wanted 0", got)
+	}
+}
+
+func TestWatchdogTiny(t *testing.T) {
+	stuck := make(chan WorkerInfo, 1)
+	wp := New(1, WithWatchdog(Watchdog{
+		Limit:   time.Nanosecond,
+		OnStuck: func(info WorkerInfo) { stuck <- info },
+	}))
+
+	a, err := wp.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Done()
+
+	select {
+	case <-stuck:
+	case <-time.After(time.Second):
+		t.Fatal("stuck Worker not reported")
+	}
+}
+
+func TestMonitorsIdle(t *testing.T) {
+	s := Schedule{Entries: []ScheduleEntry{{At: 0, Capacity: 2}}}
+	wp := New(1, WithSchedule(s), WithWatchdog(Watchdog{Limit: time.Hour}), WithLease(time.Hour, nil))
+
+	if wp.idle != nil {
+		t.Fatal("monitors started before Wait")
+	}
+
+	a, _ := wp.Wait(context.Background())
+
+	wp.RLock()
+	idle := wp.idle
+	wp.RUnlock()
+
+	if idle == nil {
+		t.Fatal("monitors not started by Wait")
+	}
+
+	a.Done()
+
+	select {
+	case <-idle:
+	default:
+		t.Fatal("monitors not stopped once idle")
+	}
+
+	// n.b. Done was never called on wp; its monitors must restart as needed.
+	a, _ = wp.Wait(context.Background())
+	defer a.Done()
+
+	wp.RLock()
+	defer wp.RUnlock()
+
+	if wp.idle == nil {
+		t.Error("monitors not restarted by Wait")
+	}
+}
+
+func TestMaxWaiting(t *testing.T) {
+	wp := New(1, WithMaxWaiting(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, _ := wp.Wait(ctx)
+	defer a.Done()
+
+	go wp.Wait(ctx)
+
+	for wp.Metrics().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := wp.Wait(ctx); err != ErrTooManyWaiting {
+		t.Errorf("Wait: got %v; wanted %v", err, ErrTooManyWaiting)
+	}
+}
+
+func TestResizeErrors(t *testing.T) {
+	var nilwp *Waypoint
+
+	if _, err := nilwp.Resize(1); err != ErrNilWaypoint {
+		t.Errorf("nil Resize: got %v; wanted %v", err, ErrNilWaypoint)
+	}
+
+	if _, err := nilwp.Wait(context.Background()); err != ErrNilWaypoint {
+		t.Errorf("nil Wait: got %v; wanted %v", err, ErrNilWaypoint)
+	}
+
+	wp := New(1)
+
+	if _, err := wp.Resize(-1); err != ErrInvalidCapacity {
+		t.Errorf("Resize(-1): got %v; wanted %v", err, ErrInvalidCapacity)
+	}
+
+	if old, err := wp.Resize(3); old != 1 || err != nil {
+		t.Errorf("Resize(3): got (%d, %v); wanted (1, nil)", old, err)
+	}
+
+	<-wp.Done()
+
+	if _, err := wp.Resize(2); err != ErrClosed {
+		t.Errorf("closed Resize: got %v; wanted %v", err, ErrClosed)
+	}
+}
+
+func TestWaitClosed(t *testing.T) {
+	wp := New(1)
+
+	if wp.Closed() {
+		t.Error("Closed: got true before Done")
+	}
+
+	done := wp.Done()
+
+	if !wp.Closed() {
+		t.Error("Closed: got false after Done")
+	}
+
+	if _, err := wp.Wait(context.Background()); err != ErrClosed {
+		t.Errorf("Wait after Done: got %v; wanted %v", err, ErrClosed)
+	}
+
+	<-done
+
+	if fs, _ := wp.FinalState(); !fs.Clean() {
+		t.Errorf("FinalState: got %+v; wanted clean", fs)
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)}
+	wp := New(1, WithClock(clock))
+
+	a1, _ := wp.Wait(context.Background())
+
+	ch := make(chan *Worker)
+	go func() {
+		a, _ := wp.Wait(context.Background())
+		ch <- a
+	}()
+
+	for wp.Metrics().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.advance(5 * time.Second)
+	a1.Done()
+	a2 := <-ch
+
+	clock.advance(3 * time.Second)
+	a2.Done()
+
+	m := wp.Metrics()
+	if m.WaitTime != 5*time.Second || m.ActiveTime != 8*time.Second {
+		t.Errorf("Metrics: got wait=%v active=%v; wanted wait=5s active=8s", m.WaitTime, m.ActiveTime)
+	}
+
+	if !m.Timestamp.Equal(clock.Now()) {
+		t.Errorf("Timestamp: got %v; wanted %v", m.Timestamp, clock.Now())
+	}
+}
+
+func TestMetricsDelta(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)}
+	wp := New(2, WithClock(clock), WithHistograms(time.Second))
+
+	run := func(n int) {
+		for range n {
+			a, _ := wp.Wait(context.Background())
+			clock.advance(time.Second)
+			a.Done()
+		}
+	}
+
+	run(3)
+	if m := wp.MetricsDelta(); m.Finished != 3 || m.ActiveTime != 3*time.Second || m.ActiveHistogram.Count != 3 {
+		t.Errorf("first MetricsDelta: got %+v", m)
+	}
+
+	run(2)
+	if m := wp.MetricsDelta(); m.Finished != 2 || m.ActiveTime != 2*time.Second || m.ActiveHistogram.Count != 2 {
+		t.Errorf("second MetricsDelta: got %+v", m)
+	}
+
+	if m := wp.Metrics(); m.Finished != 5 {
+		t.Errorf("Metrics.Finished: got %d; wanted 5", m.Finished)
+	}
+
+	wp.ResetMetrics()
+
+	if m := wp.Metrics(); m.Finished != 0 || m.ActiveTime != 0 || m.ActiveHistogram.Count != 0 {
+		t.Errorf("Metrics after reset: got %+v", m)
+	}
+
+	run(1)
+	if m := wp.MetricsDelta(); m.Finished != 1 {
+		t.Errorf("MetricsDelta after reset: got %d finished; wanted 1", m.Finished)
+	}
+}
+
+func TestProfilerLabels(t *testing.T) {
+	wp := New(1, WithName("wp"), WithProfilerLabels())
+	ctx := WithLabels(context.Background(), Labels{"tenant": "acme"})
+
+	a, _ := wp.Wait(ctx)
+	defer a.Done()
+
+	want := map[string]string{ProfilerWaypointLabel: "wp", "tenant": "acme"}
+
+	for k, v := range want {
+		if got, _ := pprof.Label(a.Context(), k); got != v {
+			t.Errorf("pprof label %q: got %q; wanted %q", k, got, v)
+		}
+	}
+
+	a.Do(context.Background(), func(ctx context.Context) {
+		for k, v := range want {
+			if got, _ := pprof.Label(ctx, k); got != v {
+				t.Errorf("Do: pprof label %q: got %q; wanted %q", k, got, v)
+			}
+		}
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	if err := r.Register(New(1)); err != ErrUnnamed {
+		t.Errorf("Register(unnamed): got %v; wanted %v", err, ErrUnnamed)
+	}
+
+	for _, name := range []string{"beta", "alpha"} {
+		if err := r.Register(New(1, WithName(name))); err != nil {
+			t.Errorf("Register(%q): %v", name, err)
+		}
+	}
+
+	if err := r.Register(New(1, WithName("alpha"))); err != ErrDuplicateName {
+		t.Errorf("Register(duplicate): got %v; wanted %v", err, ErrDuplicateName)
+	}
+
+	if got := strings.Join(r.Names(), ","); got != "alpha,beta" {
+		t.Errorf("Names: got %q; wanted %q", got, "alpha,beta")
+	}
+
+	if old, err := r.Resize("alpha", 5); old != 1 || err != nil {
+		t.Errorf("Resize: got (%d, %v); wanted (1, nil)", old, err)
+	}
+
+	if _, err := r.Resize("gamma", 5); err != ErrUnknownName {
+		t.Errorf("Resize(unknown): got %v; wanted %v", err, ErrUnknownName)
+	}
+
+	if m := r.Metrics(); len(m) != 2 || m["alpha"].Capacity != 5 {
+		t.Errorf("Metrics: got %+v", m)
+	}
+
+	if !r.Unregister("beta") || r.Unregister("beta") {
+		t.Error("Unregister: wanted true then false")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	wp := New(4, WithName("alpha"))
+	r.Register(wp)
+
+	srv := httptest.NewServer(NewHandler(r))
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, Metrics) {
+		t.Helper()
+
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var m Metrics
+		json.NewDecoder(resp.Body).Decode(&m)
+
+		return resp.StatusCode, m
+	}
+
+	if code, m := do("GET", "/alpha", ""); code != 200 || m.Capacity != 4 {
+		t.Errorf("GET /alpha: got (%d, %d); wanted (200, 4)", code, m.Capacity)
+	}
+
+	if code, _ := do("GET", "/gamma", ""); code != 404 {
+		t.Errorf("GET /gamma: got %d; wanted 404", code)
+	}
+
+	if code, m := do("POST", "/alpha", `{"capacity": 8}`); code != 200 || m.Capacity != 8 {
+		t.Errorf("POST capacity: got (%d, %d); wanted (200, 8)", code, m.Capacity)
+	}
+
+	if code, m := do("PUT", "/alpha", `{"paused": true}`); code != 200 || m.Capacity != 0 {
+		t.Errorf("PUT paused: got (%d, %d); wanted (200, 0)", code, m.Capacity)
+	}
+
+	if code, m := do("PUT", "/alpha", `{"paused": false}`); code != 200 || m.Capacity != 8 {
+		t.Errorf("PUT resumed: got (%d, %d); wanted (200, 8)", code, m.Capacity)
+	}
+
+	if code, _ := do("POST", "/alpha", `{"capacity": -1}`); code != 400 {
+		t.Errorf("POST invalid capacity: got %d; wanted 400", code)
+	}
+
+	if code, _ := do("POST", "/gamma", `{"capacity": 2}`); code != 404 {
+		t.Errorf("POST /gamma: got %d; wanted 404", code)
+	}
+
+	// n.b. Resizing through the Registry discards the paused state, so
+	//      resuming afterward must not restore the capacity from before.
+	do("PUT", "/alpha", `{"paused": true}`)
+	r.Resize("alpha", 6)
+
+	if code, m := do("PUT", "/alpha", `{"paused": false}`); code != 200 || m.Capacity != 6 {
+		t.Errorf("PUT resumed after Resize: got (%d, %d); wanted (200, 6)", code, m.Capacity)
+	}
+
+	do("PUT", "/alpha", `{"paused": true}`)
+	r.Unregister("alpha")
+	r.Register(New(3, WithName("alpha")))
+
+	if code, m := do("PUT", "/alpha", `{"paused": true}`); code != 200 || m.Capacity != 0 {
+		t.Errorf("PUT paused after re-Register: got (%d, %d); wanted (200, 0)", code, m.Capacity)
+	}
+
+	r.Unregister("alpha")
+	r.Register(wp)
+
+	<-wp.Done()
+
+	if code, _ := do("POST", "/alpha", `{"capacity": 2}`); code != 409 {
+		t.Errorf("POST closed: got %d; wanted 409", code)
+	}
+}
+
+func TestAwaitCapacity(t *testing.T) {
+	wp := New(3)
+	ctx := context.Background()
+
+	a1, _ := wp.Wait(ctx)
+	a2, _ := wp.Wait(ctx)
+
+	if err := wp.AwaitCapacity(ctx, 1); err != nil {
+		t.Errorf("AwaitCapacity(1): %v", err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if err := wp.AwaitCapacity(tctx, 2); err != context.DeadlineExceeded {
+		t.Errorf("AwaitCapacity(2): got %v; wanted %v", err, context.DeadlineExceeded)
+	}
+
+	errc := make(chan error)
+	go func() { errc <- wp.AwaitCapacity(ctx, 3) }()
+
+	a1.Done()
+	a2.Done()
+
+	if err := <-errc; err != nil {
+		t.Errorf("AwaitCapacity(3): %v", err)
+	}
+
+	if got := wp.Metrics().Active; got != 0 {
+		t.Errorf("Active: got %d; wanted 0", got)
+	}
+
+	<-wp.Done()
+
+	if err := wp.AwaitCapacity(ctx, 1); err != ErrClosed {
+		t.Errorf("AwaitCapacity(closed): got %v; wanted %v", err, ErrClosed)
+	}
+}
+
+func TestGrowShrink(t *testing.T) {
+	wp := New(100)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(2)
+		go func() { defer wg.Done(); wp.Grow(2) }()
+		go func() { defer wg.Done(); wp.Shrink(1) }()
+	}
+	wg.Wait()
+
+	if got := wp.Metrics().Capacity; got != 150 {
+		t.Errorf("Capacity: got %d; wanted 150", got)
+	}
+
+	if got, err := wp.Shrink(200); got != 0 || err != nil {
+		t.Errorf("Shrink(200): got (%d, %v); wanted (0, nil)", got, err)
+	}
+
+	if _, err := wp.Grow(-1); err != ErrInvalidCapacity {
+		t.Errorf("Grow(-1): got %v; wanted %v", err, ErrInvalidCapacity)
+	}
+}
+
+func TestLease(t *testing.T) {
+	expired := make(chan WorkerInfo, 1)
+	wp := New(1, WithLease(20*time.Millisecond, func(info WorkerInfo) { expired <- info }))
+	ctx := context.Background()
+
+	a1, _ := wp.Wait(ctx)
+
+	// A Worker that keeps sending heartbeats keeps its capacity...
+	for range 5 {
+		time.Sleep(10 * time.Millisecond)
+		if err := a1.Heartbeat(); err != nil {
+			t.Fatalf("Heartbeat: %v", err)
+		}
+	}
+
+	// ...but a silent one does not.
+	id := a1.ID
+	a2, err := wp.Wait(ctx)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	if info := <-expired; info.ID != id {
+		t.Errorf("onExpire: got worker %d; wanted %d", info.ID, id)
+	}
+
+	if err := a1.Heartbeat(); err != ErrLeaseExpired {
+		t.Errorf("expired Heartbeat: got %v; wanted %v", err, ErrLeaseExpired)
+	}
+
+	a1.Done()
+	a2.Done()
+
+	if m := wp.Metrics(); m.Expired != 1 || m.Finished != 2 || m.Active != 0 {
+		t.Errorf("Metrics: got %+v", m)
+	}
+}
+
+func TestLeaseTiny(t *testing.T) {
+	expired := make(chan WorkerInfo, 1)
+	wp := New(1, WithLease(time.Nanosecond, func(info WorkerInfo) { expired <- info }))
+
+	a, err := wp.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("1ns lease did not expire")
+	}
+
+	a.Done()
+}
+
+func TestMetricsJSON(t *testing.T) {
+	wp := New(2, WithHistograms(time.Second))
+
+	a, _ := wp.Wait(context.Background())
+	time.Sleep(time.Millisecond)
+	a.Done()
+
+	m := wp.Metrics()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+
+	for _, k := range []string{"capacity", "finished", "active_time_ns", "active_time", "active_histogram"} {
+		if _, ok := fields[k]; !ok {
+			t.Errorf("JSON field %q missing from %s", k, data)
+		}
+	}
+
+	var got Metrics
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.String() != m.String() || got.ActiveHistogram.Count != 1 {
+		t.Errorf("round trip: got %v; wanted %v", got, m)
+	}
+
+	if s := m.String(); !strings.Contains(s, "capacity=2") || !strings.Contains(s, "finished=1") {
+		t.Errorf("String: got %q", s)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	wp := New(3)
+	sem := NewSemaphore(wp)
+	ctx := context.Background()
+
+	if err := sem.Acquire(ctx, 2); err != nil {
+		t.Fatalf("Acquire(2): %v", err)
+	}
+
+	if sem.TryAcquire(2) {
+		t.Error("TryAcquire(2): got true; wanted false")
+	}
+
+	if got := wp.Metrics().Active; got != 2 {
+		t.Errorf("Active after failed TryAcquire: got %d; wanted 2", got)
+	}
+
+	if !sem.TryAcquire(1) {
+		t.Error("TryAcquire(1): got false; wanted true")
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if err := sem.Acquire(tctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("Acquire(1) when full: got %v; wanted %v", err, context.DeadlineExceeded)
+	}
+
+	sem.Release(3)
+
+	if got := wp.Metrics().Active; got != 0 {
+		t.Errorf("Active after Release: got %d; wanted 0", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Release(1) with nothing held: wanted panic")
+		}
+	}()
+
+	sem.Release(1)
+}
+
+func TestStarvation(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)}
+	wp := New(1, WithClock(clock), WithStarvationThreshold(time.Second))
+
+	a1, _ := wp.Wait(context.Background())
+
+	ch := make(chan *Worker)
+	for i := range 2 {
+		go func() {
+			a, _ := wp.Wait(context.Background())
+			ch <- a
+		}()
+
+		for wp.Metrics().Waiting <= i {
+			time.Sleep(time.Millisecond)
+		}
+
+		clock.advance(time.Second)
+	}
+
+	m := wp.Metrics()
+	if m.LongestWait != 2*time.Second || m.Starved != 1 || m.TotalStarved != 0 {
+		t.Errorf("Metrics: got longest=%v starved=%d total=%d; wanted 2s, 1, 0", m.LongestWait, m.Starved, m.TotalStarved)
+	}
+
+	a1.Done()
+	a2 := <-ch
+	a2.Done()
+	a3 := <-ch
+	a3.Done()
+
+	m = wp.Metrics()
+	if m.LongestWait != 0 || m.Starved != 0 || m.TotalStarved != 1 {
+		t.Errorf("Metrics: got longest=%v starved=%d total=%d; wanted 0, 0, 1", m.LongestWait, m.Starved, m.TotalStarved)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2024 Timothy E. Peoples
+
+package waypoint
+
+import "time"
+
+const (
+	defaultRetention  = time.Minute
+	defaultResolution = time.Second
+)
+
+// WithMetricsWindow returns an Option that alters how much recent history
+// a Waypoint retains for use by its MetricsWindow method. Activity is kept
+// for the most recent retention period in slots of the given resolution.
+// By default, one minute of activity is retained at one second resolution.
+// Non-positive arguments are ignored.
+func WithMetricsWindow(retention, resolution time.Duration) Option {
+	return func(w *Waypoint) {
+		if retention <= 0 || resolution <= 0 {
+			return
+		}
+		w.window = newWindow(retention, resolution)
+	}
+}
+
+// WindowMetrics represents Waypoint activity over a recent period of time.
+type WindowMetrics struct {
+	Timestamp  time.Time     // Time these metrics were gathered
+	Window     time.Duration // Length of the window actually covered
+	Started    int           // Number of Workers that became Active
+	Finished   int           // Number of Workers that became Finished
+	Throughput float64       // Finished Workers per second
+	AvgWait    time.Duration // Average wait time of Started Workers
+	AvgActive  time.Duration // Average active time of Finished Workers
+}
+
+// MetricsWindow returns WindowMetrics describing the receiver's activity
+// over (approximately) the most recent period d. The period covered is
+// rounded up to a multiple of the receiver's window resolution and is
+// limited by its retention; see WithMetricsWindow.
+func (w *Waypoint) MetricsWindow(d time.Duration) WindowMetrics {
+	if w == nil {
+		return WindowMetrics{}
+	}
+
+	w.RLock()
+	defer w.RUnlock()
+
+	return w.window.metrics(w.clock.Now(), d)
+}
+
+type (
+	window struct {
+		resolution time.Duration
+		slots      []slot
+	}
+
+	slot struct {
+		id         int64 // time (in units of resolution) this slot covers
+		started    int
+		finished   int
+		waitTime   time.Duration
+		activeTime time.Duration
+	}
+)
+
+func newWindow(retention, resolution time.Duration) *window {
+	n := int((retention + resolution - 1) / resolution)
+	return &window{
+		resolution: resolution,
+		slots:      make([]slot, n),
+	}
+}
+
+// slot returns the slot for time t, resetting it if it last held data
+// for an earlier period.
+func (win *window) slot(t time.Time) *slot {
+	id := t.UnixNano() / int64(win.resolution)
+	s := &win.slots[id%int64(len(win.slots))]
+
+	if s.id != id {
+		*s = slot{id: id}
+	}
+
+	return s
+}
+
+func (win *window) recordStart(t time.Time, wait time.Duration) {
+	s := win.slot(t)
+	s.started++
+	s.waitTime += wait
+}
+
+func (win *window) recordFinish(t time.Time, active time.Duration) {
+	s := win.slot(t)
+	s.finished++
+	s.activeTime += active
+}
+
+func (win *window) metrics(now time.Time, d time.Duration) WindowMetrics {
+	n := int64((d + win.resolution - 1) / win.resolution)
+	if n > int64(len(win.slots)) {
+		n = int64(len(win.slots))
+	}
+
+	if n < 1 {
+		n = 1
+	}
+
+	wm := WindowMetrics{
+		Timestamp: now,
+		Window:    time.Duration(n) * win.resolution,
+	}
+
+	var waitTime, activeTime time.Duration
+	cur := now.UnixNano() / int64(win.resolution)
+
+	for id := cur - n + 1; id <= cur; id++ {
+		s := win.slots[id%int64(len(win.slots))]
+		if s.id != id {
+			continue
+		}
+
+		wm.Started += s.started
+		wm.Finished += s.finished
+		waitTime += s.waitTime
+		activeTime += s.activeTime
+	}
+
+	wm.Throughput = float64(wm.Finished) / wm.Window.Seconds()
+
+	if wm.Started > 0 {
+		wm.AvgWait = waitTime / time.Duration(wm.Started)
+	}
+
+	if wm.Finished > 0 {
+		wm.AvgActive = activeTime / time.Duration(wm.Finished)
+	}
+
+	return wm
+}
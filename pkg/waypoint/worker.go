@@ -11,7 +11,11 @@
 
 package waypoint
 
-import "time"
+import (
+	"context"
+	"runtime/pprof"
+	"time"
+)
 
 // State is the type used to populate a Worker's State field.
 type State string
@@ -38,6 +42,10 @@ type (
 	//
 	// Note that, since each Worker maintains a reference to the Waypoint
 	// that created it, no Worker should be copied once it has been created.
+	//
+	// Each call to Wait allocates a new Worker. Workers are not recycled
+	// since they may still be used (e.g. to inspect their Labels or Finished
+	// time) after Done has been called.
 	Worker struct {
 		ID    uint64
 		State State
@@ -45,6 +53,16 @@ type (
 		created  time.Time
 		started  time.Time
 		finished time.Time
+		span     Span
+		labels   Labels
+		ctx      context.Context
+		profile  pprof.LabelSet          // see WithProfilerLabels
+		cancel   context.CancelCauseFunc // see Watchdog.Cancel
+		stuck    bool
+		lastBeat time.Time // see WithLease
+		expired  bool
+		parent   *Worker // from the parent Waypoint (if any)
+		released bool    // see Done
 
 		// An embedded reference to the creating Waypoint
 		// (and its embedded RWMutex)
@@ -65,7 +83,7 @@ func (w *Waypoint) _next() *Worker {
 	return &Worker{
 		ID:       w.idSeq,
 		State:    Waiting,
-		created:  time.Now(),
+		created:  w.clock.Now(),
 		waypoint: w,
 	}
 }
@@ -74,35 +92,121 @@ func (w *Waypoint) _next() *Worker {
 // receiver into the Active state. Note that _start assumes that its receiver
 // has already been locked.
 func (w *Worker) _start() *Worker {
-	now := time.Now()
+	now := w.clock.Now()
 	w.started = now
 	w.waitTime += now.Sub(w.created)
+	w.waitHist.observe(now.Sub(w.created))
+	w.window.recordStart(now, now.Sub(w.created))
+
+	if w.starveAfter > 0 && now.Sub(w.created) > w.starveAfter {
+		w.numStarved++
+	}
+
 	w.State = Active
+	w.lastBeat = now
 	w.active[w.ID] = w
+
+	if w.watchdog != nil && w.watchdog.Cancel {
+		w.ctx, w.cancel = context.WithCancelCause(w.ctx)
+	}
+
+	w._emit(WorkerActivated, w.ID)
 	return w
 }
 
+// Created returns the time at which the receiver was created (i.e. when it
+// entered the Waiting state).
+func (w *Worker) Created() time.Time {
+	w.RLock()
+	defer w.RUnlock()
+	return w.created
+}
+
+// Started returns the time at which the receiver entered the Active state,
+// or the zero time if it has not yet done so.
+func (w *Worker) Started() time.Time {
+	w.RLock()
+	defer w.RUnlock()
+	return w.started
+}
+
+// Finished returns the time at which the receiver entered the Finished
+// state, or the zero time if it has not yet done so.
+func (w *Worker) Finished() time.Time {
+	w.RLock()
+	defer w.RUnlock()
+	return w.finished
+}
+
 // Done is called to transition the receiver to the Finished state. If this
 // drops the associated Waypoint below its set, non-zero, capacity -- and the
 // Waypoint has not yet been closed -- a Worker from the associated Waypoint's
 // pool of Waiting Workers will be moved to the Active state to begin work.
+// If the associated Waypoint is a Child, capacity is returned to its parent
+// as well.
+//
+// If the receiver's lease has already expired (see WithLease), its capacity
+// has already been reclaimed and Done simply discards the receiver.
+//
+// Only the first call to Done has any effect; later calls return
+// immediately.
 func (w *Worker) Done() {
 	w.Lock()
+
+	if w.released {
+		w.Unlock()
+		return
+	}
+
+	w.released = true
+
+	if w.expired {
+		w.Unlock()
+		endSpan(w.span, ErrLeaseExpired)
+		w.hooks.finish.call(w)
+		return
+	}
+
+	defer w.hooks.finish.call(w)
+	defer endSpan(w.span, nil)
+	defer w.parentDone()
 	defer w.Unlock()
 
+	w._finish()
+}
+
+// _finish transitions the receiver to the Finished state and removes it
+// from its Waypoint's set of Active Workers.
+func (w *Worker) _finish() {
 	w.State = Finished
-	w.finished = time.Now()
+	w.finished = w.clock.Now()
 
 	w.numFinished++
 	w.activeTime += w.finished.Sub(w.started)
+	w.activeHist.observe(w.finished.Sub(w.started))
+	w.window.recordFinish(w.finished, w.finished.Sub(w.started))
 
-	// Note that calling cond.Signal() will likely trigger a call to the
-	// above _start method (if there are Workers "Waiting" in the wings).
-	w.cond.Signal()
+	w.waypoint._quotaRelease(w)
 
-	// n.b. This must be called *after* cond.Signal() to allow a closed
-	//      Waypoint, with non-zero capacity, to continue activating
-	//      Waiting Workers -- otherwise, removing the only Active Worker
-	//      from a closed Waypoint would shut this whole thing down.
+	if w.stuck {
+		w.numStuck--
+	}
+
+	if w.cancel != nil {
+		w.cancel(nil)
+	}
+
+	w._emit(WorkerFinished, w.ID)
+
+	// Note that this will likely grant capacity to the next Worker that
+	// is "Waiting" in the wings.
 	w._removeWorker(w.ID)
 }
+
+// parentDone calls Done on the receiver's Worker from its parent Waypoint
+// (if it has one).
+func (w *Worker) parentDone() {
+	if w.parent != nil {
+		w.parent.Done()
+	}
+}